@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"encoding/json"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -38,6 +39,73 @@ type HibernatorSpec struct {
 	Action               Action             `json:"action"`
 	DeleteStore          bool               `json:"deleteStore,omitempty"`
 	TargetReplicas       *[]int             `json:"targetReplicas,omitempty"`
+	GitOps               GitOps             `json:"gitOps,omitempty"`
+	// LockNamespaces applies a ResourceQuota allowing no pods to the namespaces whose selected workloads are all
+	// hibernated, so that no new pods can be created in them until they are woken up. Only namespaces selected whole,
+	// by an inclusion of all the types without any filter on the objects, are locked
+	LockNamespaces bool `json:"lockNamespaces,omitempty"`
+	// Parallelism is the maximum number of objects patched or deleted concurrently, defaults to 10
+	Parallelism int `json:"parallelism,omitempty"`
+	// DriftPolicy decides how a change to the replica count of a hibernated object made outside of the hibernator is
+	// handled, one of enforce, respect-manual-wake or alert-only, defaults to enforce
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// Idle hibernates the selected objects which stay idle for a duration outside of the time ranges, only with the
+	// hibernate action
+	Idle *Idle `json:"idle,omitempty"`
+	// WakeOnRequest routes services to the activator while hibernated, a request to one of them wakes up the
+	// selected objects and is proxied once the service is ready again
+	WakeOnRequest *WakeOnRequest `json:"wakeOnRequest,omitempty"`
+	// ExpressionErrorPolicy decides how an object for which a fieldSelector expression fails, or the rule whose
+	// exclusions fail to select objects, is handled, one of ignore or fail-closed, defaults to fail-closed with the
+	// delete action and ignore otherwise
+	ExpressionErrorPolicy ExpressionErrorPolicy `json:"expressionErrorPolicy,omitempty"`
+}
+
+// WakeOnRequest configures waking up on requests to hibernated HTTP services
+type WakeOnRequest struct {
+	// Services are the services routed to the activator while hibernated
+	Services []ServiceReference `json:"services"`
+	// Timeout is how long a request waits for the service to be ready, defaults to 2m
+	Timeout string `json:"timeout,omitempty"`
+	// SleepAfter is how long the selected objects are kept awake after a request woke them up, defaults to 30m
+	SleepAfter string `json:"sleepAfter,omitempty"`
+}
+
+type ServiceReference struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Port is the name or number of the service port requests are proxied to, defaults to the first port
+	Port string `json:"port,omitempty"`
+	// Hosts are the additional hosts, such as the hosts of an Ingress, through which the service is requested
+	Hosts []string `json:"hosts,omitempty"`
+}
+
+// Idle configures the detection of idle objects from their usage metrics
+type Idle struct {
+	// Source of the usage metrics, prometheus or metrics-server
+	Source MetricsSource `json:"source"`
+	// PrometheusURL is the base URL of the Prometheus HTTP API, required with the prometheus source
+	PrometheusURL string `json:"prometheusURL,omitempty"`
+	// Queries are the PromQL queries of the metrics keyed by metric name such as cpu.p95, added to or replacing the
+	// default queries. $namespace, $name and $window are replaced with the namespace and name of the object and the
+	// window.
+	Queries map[string]string `json:"queries,omitempty"`
+	// Condition is the expression evaluated against the metrics of an object, eg {{cpu.p95}} < 0.05
+	Condition string `json:"condition"`
+	// Window is the range over which the prometheus queries aggregate the metrics, defaults to 5m. The metrics-server
+	// source only has the current usage.
+	Window string `json:"window,omitempty"`
+	// Duration is how long the condition has to hold before the object is hibernated, eg 30m or 1d
+	Duration string `json:"duration"`
+}
+
+// GitOps configures cooperation with GitOps tools managing the hibernated objects
+type GitOps struct {
+	// SuspendOwners suspends auto sync of the Argo CD Application or reconciliation of the Flux Kustomization or
+	// HelmRelease owning an object while it is hibernated, so that they do not revert the replica count
+	SuspendOwners bool `json:"suspendOwners,omitempty"`
+	// ArgoCDNamespace is the namespace of Argo CD Applications, defaults to argocd
+	ArgoCDNamespace string `json:"argoCDNamespace,omitempty"`
 }
 
 type Rule struct {
@@ -71,16 +139,69 @@ type Selector struct {
 }
 
 type ObjectSelector struct {
-	Labels        []string `json:"labels,omitempty"`
-	Name          string   `json:"name,omitempty"`
-	Type          string   `json:"type"`
-	FieldSelector []string `json:"fieldSelector,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	// Name selects objects by comma separated names, each an exact name, a glob such as qa-* or a regular expression
+	// which has to be enclosed in slashes, such as /feature-.*-preview/, and match the whole name. A regular expression
+	// without the slashes, such as feature-.*-preview, is taken as a glob.
+	Name string `json:"name,omitempty"`
+	Type string `json:"type,omitempty"`
+	// FieldSelector selects the objects for which all the expressions are true, each given as a string for an expr
+	// expression or as an object along with its language
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	FieldSelector []FieldExpression `json:"fieldSelector,omitempty"`
+	// LabelSelector selects objects by matchLabels and matchExpressions, combined with Labels when both are set
+	LabelSelector *metaV1.LabelSelector `json:"labelSelector,omitempty"`
+	// AnnotationSelector selects objects by their annotations with the syntax of a label selector
+	AnnotationSelector *metaV1.LabelSelector `json:"annotationSelector,omitempty"`
+	// HelmRelease selects all the objects of comma separated Helm releases given as <name> or <namespace>/<name>,
+	// Type defaults to the scalable workloads. The objects of the releases are narrowed down by Labels, LabelSelector
+	// and FieldSelector when set
+	HelmRelease string `json:"helmRelease,omitempty"`
+	// ResolveOwners replaces matched objects managed by a controller, such as pods or replica sets, with their top
+	// level controller found through owner references
+	ResolveOwners bool `json:"resolveOwners,omitempty"`
+	// TopLevelWorkloads keeps only matched objects not managed by a controller, Type defaults to the scalable workloads
+	TopLevelWorkloads bool `json:"topLevelWorkloads,omitempty"`
+}
+
+// FieldExpression is a fieldSelector expression in its language
+type FieldExpression struct {
+	Expression string `json:"expression"`
+	// Language is expr, the default, with the fields of the object read through {{path}}, or cel with the object
+	// as self
+	Language ExpressionLanguage `json:"language,omitempty"`
+}
+
+// UnmarshalJSON reads an expr expression given as a string, or an expression given as an object
+func (f *FieldExpression) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*f = FieldExpression{}
+		return json.Unmarshal(data, &f.Expression)
+	}
+	type fieldExpression FieldExpression
+	return json.Unmarshal(data, (*fieldExpression)(f))
+}
+
+// MarshalJSON writes an expr expression as a string, as it was given before expressions had a language
+func (f FieldExpression) MarshalJSON() ([]byte, error) {
+	if len(f.Language) == 0 || f.Language == ExprLanguage {
+		return json.Marshal(f.Expression)
+	}
+	type fieldExpression FieldExpression
+	return json.Marshal(fieldExpression(f))
 }
 
 type NamespaceSelector struct {
-	Labels        []string `json:"labels,omitempty"`
+	Labels []string `json:"labels,omitempty"`
+	// Name selects namespaces by comma separated names, all for every namespace, with the syntax of the name of an
+	// object selector, regular expressions being enclosed in slashes such as /feature-.*-preview/
 	Name          string   `json:"name,omitempty"`
 	FieldSelector []string `json:"fieldSelector,omitempty"`
+	// LabelSelector selects namespaces by matchLabels and matchExpressions, combined with Labels when both are set
+	LabelSelector *metaV1.LabelSelector `json:"labelSelector,omitempty"`
+	// AnnotationSelector selects namespaces by their annotations with the syntax of a label selector
+	AnnotationSelector *metaV1.LabelSelector `json:"annotationSelector,omitempty"`
 }
 
 // HibernatorStatus defines the observed state of Hibernator
@@ -92,6 +213,100 @@ type HibernatorStatus struct {
 	Message       string            `json:"message"`
 	IsHibernating bool              `json:"isHibernating"`
 	Action        Action            `json:"action"`
+	// ExcludedCounts is the number of objects excluded in the last run for each exclusion reason
+	ExcludedCounts map[string]int `json:"excludedCounts,omitempty"`
+	// OriginalReplicas is the replica count of scaled down objects before hibernation keyed by resource key
+	OriginalReplicas map[string]int `json:"originalReplicas,omitempty"`
+	// SuspendedOwners are the GitOps owners suspended during hibernation along with their original settings
+	SuspendedOwners []SuspendedOwner `json:"suspendedOwners,omitempty"`
+	// PairedAutoscalers are the horizontal pod autoscalers, KEDA scaled objects and vertical pod autoscalers of
+	// hibernated objects along with their original settings
+	PairedAutoscalers []PairedAutoscaler `json:"pairedAutoscalers,omitempty"`
+	// HelmReleases is the progress of the last run for each Helm release selected through helmRelease
+	HelmReleases []HelmReleaseStatus `json:"helmReleases,omitempty"`
+	// LockedNamespaces are the namespaces in which the hibernator has applied its ResourceQuota
+	LockedNamespaces []string `json:"lockedNamespaces,omitempty"`
+	// ScaledReplicas is the replica count hibernated objects were scaled to keyed by resource key, any other count
+	// is a change made outside of the hibernator
+	ScaledReplicas map[string]int `json:"scaledReplicas,omitempty"`
+	// ManualOverrides are the hibernated objects whose replica count was changed outside of the hibernator since
+	// they were hibernated, cleared on wake up
+	ManualOverrides []ManualOverride `json:"manualOverrides,omitempty"`
+	// IdleSince is the time from which objects not yet hibernated have been found idle keyed by resource key
+	IdleSince map[string]metaV1.Time `json:"idleSince,omitempty"`
+	// IdleHibernated is the time objects were hibernated for being idle keyed by resource key, they are woken up at
+	// the end of the next time range
+	IdleHibernated map[string]metaV1.Time `json:"idleHibernated,omitempty"`
+	// RoutedServices are the services routed to the activator along with their original selector
+	RoutedServices []RoutedService `json:"routedServices,omitempty"`
+	// WokenOnRequest is the time a request to a routed service woke up the selected objects, they are kept awake
+	// for wakeOnRequest.sleepAfter from then
+	WokenOnRequest *metaV1.Time `json:"wokenOnRequest,omitempty"`
+	// ExpressionErrors are the fieldSelector expressions which failed in the last run
+	ExpressionErrors []ExpressionError `json:"expressionErrors,omitempty"`
+	// SelectionErrors are the inclusions and exclusions which failed to select objects in the last run, eg for an
+	// invalid label selector or name pattern
+	SelectionErrors []SelectionError `json:"selectionErrors,omitempty"`
+}
+
+type SelectionError struct {
+	// RuleIndex is the index of the rule in spec.selectors
+	// +optional
+	RuleIndex int `json:"ruleIndex"`
+	// Exclusion is set when an exclusion of the rule failed, an inclusion otherwise
+	Exclusion bool   `json:"exclusion,omitempty"`
+	Error     string `json:"error"`
+}
+
+type ExpressionError struct {
+	Expression string `json:"expression"`
+	// Error is the error of the first object the expression failed for
+	Error string `json:"error"`
+	// Objects is the number of objects the expression failed for
+	Objects int `json:"objects"`
+}
+
+type RoutedService struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Selector is the selector of the service before it was routed
+	Selector map[string]string `json:"selector"`
+}
+
+type ManualOverride struct {
+	ResourceKey string `json:"resourceKey"`
+	// Replicas is the replica count the object was changed to
+	Replicas   int         `json:"replicas"`
+	DetectedAt metaV1.Time `json:"detectedAt"`
+}
+
+type HelmReleaseStatus struct {
+	// Release is the namespace and name of the Helm release as <namespace>/<name>
+	Release string `json:"release"`
+	// Total is the number of selected objects of the release
+	Total int `json:"total"`
+	// Done is the number of selected objects of the release acted upon or already at target
+	Done    int    `json:"done"`
+	Message string `json:"message"`
+}
+
+type PairedAutoscaler struct {
+	ResourceKey string `json:"resourceKey"`
+	// TargetKey is the resource key of the scale target of the autoscaler
+	TargetKey   string `json:"targetKey"`
+	MinReplicas int    `json:"minReplicas,omitempty"`
+	MaxReplicas int    `json:"maxReplicas,omitempty"`
+	// UpdateMode is the update mode of a vertical pod autoscaler, empty if not set
+	UpdateMode string `json:"updateMode,omitempty"`
+}
+
+type SuspendedOwner struct {
+	ResourceKey string `json:"resourceKey"`
+	// OriginalSettings is spec.syncPolicy.automated of an Argo CD Application or spec.suspend of a Flux object
+	// before suspension, as JSON
+	OriginalSettings string `json:"originalSettings"`
+	Message          string `json:"message,omitempty"`
+	Status           string `json:"status,omitempty"`
 }
 
 type ImpactedObject struct {
@@ -105,6 +320,14 @@ type ImpactedObject struct {
 	RelatedDeletedObject string `json:"relatedDeletedObject"`
 	Message              string `json:"message"`
 	Status               string `json:"status"`
+	// RuleIndex is the index of the rule in spec.selectors which matched the object, always serialized as 0 is the
+	// first rule. It is optional for the entries recorded before it was added.
+	// +optional
+	RuleIndex int `json:"ruleIndex"`
+	// HelmRelease is the Helm release, as <namespace>/<name>, through which the object was selected
+	HelmRelease string `json:"helmRelease,omitempty"`
+	// ResolvedFrom is the resource key of the matched object which was resolved to this object through owner references
+	ResolvedFrom string `json:"resolvedFrom,omitempty"`
 }
 
 type ExcludedObject struct {
@@ -114,7 +337,8 @@ type ExcludedObject struct {
 	//Kind        string `json:"kind"`
 	//Name        string `json:"name"`
 	//Namespace   string `json:"namespace"`
-	Reason string `json:"reason"`
+	Reason  string `json:"reason"`
+	Message string `json:"message,omitempty"`
 }
 
 type RevisionHistory struct {
@@ -123,6 +347,7 @@ type RevisionHistory struct {
 	Action          Action           `json:"action"`
 	ImpactedObjects []ImpactedObject `json:"impactedObjects"`
 	ExcludedObjects []ExcludedObject `json:"excludedObjects"`
+	SuspendedOwners []SuspendedOwner `json:"suspendedOwners,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -156,6 +381,66 @@ const (
 	Sleep       Action = "sleep" // for legacy reason; sleep is same as hibernate
 )
 
+// Reasons for which a selected object is not impacted, used in ExcludedObject
+const (
+	ExcludedByRule       = "excluded-by-rule"
+	AlreadyAtTarget      = "already-at-target"
+	MissingOriginalCount = "missing-original-count"
+	NoReplicaField       = "no-replica-field"
+	OptedOut             = "opted-out"
+	ManuallyChanged      = "manually-changed"
+	IdleHibernated       = "idle"
+	ExclusionError       = "error"
+)
+
+// DriftPolicy decides how a change to the replica count of a hibernated object made outside of the hibernator is handled
+type DriftPolicy string
+
+const (
+	// Enforce scales the object back to its hibernated replica count as soon as the change is seen
+	Enforce DriftPolicy = "enforce"
+	// RespectManualWake records the change and releases the object from the hibernator until it wakes up, the
+	// object is then left at the replica count it was changed to
+	RespectManualWake DriftPolicy = "respect-manual-wake"
+	// AlertOnly records the change and leaves the object as is until it is woken up along with the other objects
+	AlertOnly DriftPolicy = "alert-only"
+)
+
+// ExpressionErrorPolicy decides how an object for which a fieldSelector expression fails, or a rule whose exclusions
+// fail to select objects, is handled
+type ExpressionErrorPolicy string
+
+const (
+	// IgnoreExpressionErrors does not select the object through the inclusion whose expression failed and does not
+	// exclude it through the exclusion whose expression failed, a rule whose exclusions failed is executed with the
+	// exclusions which did not fail
+	IgnoreExpressionErrors ExpressionErrorPolicy = "ignore"
+	// FailClosed does not select the object through the inclusion whose expression failed and excludes it through
+	// the exclusion whose expression failed, so that a broken exclusion does not let it be acted upon. No object of
+	// a rule whose exclusions failed is acted upon.
+	FailClosed ExpressionErrorPolicy = "fail-closed"
+)
+
+// ExpressionLanguage is the language of a fieldSelector expression
+type ExpressionLanguage string
+
+const (
+	// ExprLanguage evaluates an expr expression whose {{path}} variables are read from the object through gjson
+	ExprLanguage ExpressionLanguage = "expr"
+	// CELLanguage evaluates a CEL expression against the object as self
+	CELLanguage ExpressionLanguage = "cel"
+)
+
+// MetricsSource is the source of the usage metrics of the objects for idle detection
+type MetricsSource string
+
+const (
+	// PrometheusSource queries the Prometheus HTTP API
+	PrometheusSource MetricsSource = "prometheus"
+	// MetricsServerSource reads the pod metrics of the metrics.k8s.io API
+	MetricsServerSource MetricsSource = "metrics-server"
+)
+
 type Weekday string
 
 const (
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestFieldExpression_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []FieldExpression
+		// marshalled is the data when it is marshalled again, the data itself when empty
+		marshalled string
+	}{
+		{name: "string", data: `["{{spec.replicas}} == 1"]`, want: []FieldExpression{{Expression: "{{spec.replicas}} == 1"}}},
+		{name: "cel object", data: `[{"expression":"self.spec.replicas == 1","language":"cel"}]`, want: []FieldExpression{{Expression: "self.spec.replicas == 1", Language: CELLanguage}}},
+		{
+			name:       "expr object",
+			data:       `[{"expression":"{{spec.replicas}} == 1","language":"expr"}]`,
+			want:       []FieldExpression{{Expression: "{{spec.replicas}} == 1", Language: ExprLanguage}},
+			marshalled: `["{{spec.replicas}} == 1"]`,
+		},
+		{
+			name:       "mixed",
+			data:       `["{{spec.replicas}} == 1",{"expression":"self.spec.paused","language":"cel"}]`,
+			want:       []FieldExpression{{Expression: "{{spec.replicas}} == 1"}, {Expression: "self.spec.paused", Language: CELLanguage}},
+			marshalled: `["{{spec.replicas}} == 1",{"expression":"self.spec.paused","language":"cel"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []FieldExpression
+			if err := json.Unmarshal([]byte(tt.data), &got); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.want)
+			}
+			data, err := json.Marshal(got)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			want := tt.marshalled
+			if len(want) == 0 {
+				want = tt.data
+			}
+			if string(data) != want {
+				t.Errorf("Marshal() = %s, want %s", data, want)
+			}
+		})
+	}
+}
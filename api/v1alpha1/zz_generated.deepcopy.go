@@ -22,6 +22,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -55,6 +56,66 @@ func (in *ExcludedObject) DeepCopy() *ExcludedObject {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExpressionError) DeepCopyInto(out *ExpressionError) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExpressionError.
+func (in *ExpressionError) DeepCopy() *ExpressionError {
+	if in == nil {
+		return nil
+	}
+	out := new(ExpressionError)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FieldExpression) DeepCopyInto(out *FieldExpression) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FieldExpression.
+func (in *FieldExpression) DeepCopy() *FieldExpression {
+	if in == nil {
+		return nil
+	}
+	out := new(FieldExpression)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitOps) DeepCopyInto(out *GitOps) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitOps.
+func (in *GitOps) DeepCopy() *GitOps {
+	if in == nil {
+		return nil
+	}
+	out := new(GitOps)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HelmReleaseStatus) DeepCopyInto(out *HelmReleaseStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HelmReleaseStatus.
+func (in *HelmReleaseStatus) DeepCopy() *HelmReleaseStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HelmReleaseStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Hibernator) DeepCopyInto(out *Hibernator) {
 	*out = *in
@@ -140,6 +201,17 @@ func (in *HibernatorSpec) DeepCopyInto(out *HibernatorSpec) {
 			copy(*out, *in)
 		}
 	}
+	out.GitOps = in.GitOps
+	if in.Idle != nil {
+		in, out := &in.Idle, &out.Idle
+		*out = new(Idle)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WakeOnRequest != nil {
+		in, out := &in.WakeOnRequest, &out.WakeOnRequest
+		*out = new(WakeOnRequest)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HibernatorSpec.
@@ -162,6 +234,89 @@ func (in *HibernatorStatus) DeepCopyInto(out *HibernatorStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.ExcludedCounts != nil {
+		in, out := &in.ExcludedCounts, &out.ExcludedCounts
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.OriginalReplicas != nil {
+		in, out := &in.OriginalReplicas, &out.OriginalReplicas
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.SuspendedOwners != nil {
+		in, out := &in.SuspendedOwners, &out.SuspendedOwners
+		*out = make([]SuspendedOwner, len(*in))
+		copy(*out, *in)
+	}
+	if in.PairedAutoscalers != nil {
+		in, out := &in.PairedAutoscalers, &out.PairedAutoscalers
+		*out = make([]PairedAutoscaler, len(*in))
+		copy(*out, *in)
+	}
+	if in.HelmReleases != nil {
+		in, out := &in.HelmReleases, &out.HelmReleases
+		*out = make([]HelmReleaseStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.LockedNamespaces != nil {
+		in, out := &in.LockedNamespaces, &out.LockedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ScaledReplicas != nil {
+		in, out := &in.ScaledReplicas, &out.ScaledReplicas
+		*out = make(map[string]int, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.ManualOverrides != nil {
+		in, out := &in.ManualOverrides, &out.ManualOverrides
+		*out = make([]ManualOverride, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.IdleSince != nil {
+		in, out := &in.IdleSince, &out.IdleSince
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.IdleHibernated != nil {
+		in, out := &in.IdleHibernated, &out.IdleHibernated
+		*out = make(map[string]v1.Time, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
+	if in.RoutedServices != nil {
+		in, out := &in.RoutedServices, &out.RoutedServices
+		*out = make([]RoutedService, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.WokenOnRequest != nil {
+		in, out := &in.WokenOnRequest, &out.WokenOnRequest
+		*out = (*in).DeepCopy()
+	}
+	if in.ExpressionErrors != nil {
+		in, out := &in.ExpressionErrors, &out.ExpressionErrors
+		*out = make([]ExpressionError, len(*in))
+		copy(*out, *in)
+	}
+	if in.SelectionErrors != nil {
+		in, out := &in.SelectionErrors, &out.SelectionErrors
+		*out = make([]SelectionError, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HibernatorStatus.
@@ -174,6 +329,28 @@ func (in *HibernatorStatus) DeepCopy() *HibernatorStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Idle) DeepCopyInto(out *Idle) {
+	*out = *in
+	if in.Queries != nil {
+		in, out := &in.Queries, &out.Queries
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Idle.
+func (in *Idle) DeepCopy() *Idle {
+	if in == nil {
+		return nil
+	}
+	out := new(Idle)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImpactedObject) DeepCopyInto(out *ImpactedObject) {
 	*out = *in
@@ -189,6 +366,22 @@ func (in *ImpactedObject) DeepCopy() *ImpactedObject {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ManualOverride) DeepCopyInto(out *ManualOverride) {
+	*out = *in
+	in.DetectedAt.DeepCopyInto(&out.DetectedAt)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ManualOverride.
+func (in *ManualOverride) DeepCopy() *ManualOverride {
+	if in == nil {
+		return nil
+	}
+	out := new(ManualOverride)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespaceSelector) DeepCopyInto(out *NamespaceSelector) {
 	*out = *in
@@ -202,6 +395,16 @@ func (in *NamespaceSelector) DeepCopyInto(out *NamespaceSelector) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AnnotationSelector != nil {
+		in, out := &in.AnnotationSelector, &out.AnnotationSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceSelector.
@@ -239,9 +442,19 @@ func (in *ObjectSelector) DeepCopyInto(out *ObjectSelector) {
 	}
 	if in.FieldSelector != nil {
 		in, out := &in.FieldSelector, &out.FieldSelector
-		*out = make([]string, len(*in))
+		*out = make([]FieldExpression, len(*in))
 		copy(*out, *in)
 	}
+	if in.LabelSelector != nil {
+		in, out := &in.LabelSelector, &out.LabelSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AnnotationSelector != nil {
+		in, out := &in.AnnotationSelector, &out.AnnotationSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ObjectSelector.
@@ -254,6 +467,21 @@ func (in *ObjectSelector) DeepCopy() *ObjectSelector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PairedAutoscaler) DeepCopyInto(out *PairedAutoscaler) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PairedAutoscaler.
+func (in *PairedAutoscaler) DeepCopy() *PairedAutoscaler {
+	if in == nil {
+		return nil
+	}
+	out := new(PairedAutoscaler)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *RevisionHistory) DeepCopyInto(out *RevisionHistory) {
 	*out = *in
@@ -268,6 +496,11 @@ func (in *RevisionHistory) DeepCopyInto(out *RevisionHistory) {
 		*out = make([]ExcludedObject, len(*in))
 		copy(*out, *in)
 	}
+	if in.SuspendedOwners != nil {
+		in, out := &in.SuspendedOwners, &out.SuspendedOwners
+		*out = make([]SuspendedOwner, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RevisionHistory.
@@ -280,6 +513,28 @@ func (in *RevisionHistory) DeepCopy() *RevisionHistory {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RoutedService) DeepCopyInto(out *RoutedService) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RoutedService.
+func (in *RoutedService) DeepCopy() *RoutedService {
+	if in == nil {
+		return nil
+	}
+	out := new(RoutedService)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Rule) DeepCopyInto(out *Rule) {
 	*out = *in
@@ -309,6 +564,21 @@ func (in *Rule) DeepCopy() *Rule {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SelectionError) DeepCopyInto(out *SelectionError) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SelectionError.
+func (in *SelectionError) DeepCopy() *SelectionError {
+	if in == nil {
+		return nil
+	}
+	out := new(SelectionError)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Selector) DeepCopyInto(out *Selector) {
 	*out = *in
@@ -326,6 +596,41 @@ func (in *Selector) DeepCopy() *Selector {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceReference) DeepCopyInto(out *ServiceReference) {
+	*out = *in
+	if in.Hosts != nil {
+		in, out := &in.Hosts, &out.Hosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceReference.
+func (in *ServiceReference) DeepCopy() *ServiceReference {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SuspendedOwner) DeepCopyInto(out *SuspendedOwner) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SuspendedOwner.
+func (in *SuspendedOwner) DeepCopy() *SuspendedOwner {
+	if in == nil {
+		return nil
+	}
+	out := new(SuspendedOwner)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TimeRange) DeepCopyInto(out *TimeRange) {
 	*out = *in
@@ -360,3 +665,25 @@ func (in *TimeRangesWithZone) DeepCopy() *TimeRangesWithZone {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WakeOnRequest) DeepCopyInto(out *WakeOnRequest) {
+	*out = *in
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]ServiceReference, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WakeOnRequest.
+func (in *WakeOnRequest) DeepCopy() *WakeOnRequest {
+	if in == nil {
+		return nil
+	}
+	out := new(WakeOnRequest)
+	in.DeepCopyInto(out)
+	return out
+}
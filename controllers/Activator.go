@@ -0,0 +1,573 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	routedSelectorAnnotation = `hibernator.devtron.ai/routed-selector`
+	defaultWakeTimeout       = 2 * time.Minute
+	defaultSleepAfter        = 30 * time.Minute
+	activatorPollInterval    = time.Second
+	activatorEventBufferSize = 1024
+	activatorShutdownTimeout = 10 * time.Second
+)
+
+var (
+	serviceGVK   = schema.GroupVersionKind{Version: "v1", Kind: "Service"}
+	endpointsGVK = schema.GroupVersionKind{Version: "v1", Kind: "Endpoints"}
+)
+
+// Activator receives the requests to the services of hibernated hibernators. The selector of a routed service is
+// removed and its endpoints point at the activator, a request then wakes up the hibernator, waits for the service to
+// have ready endpoints of its own again and is proxied to them.
+type Activator struct {
+	Kubectl pkg.KubectlCmd
+	// IP and Port are the address at which the pods reach the activator, the endpoints of routed services point at it
+	IP   string
+	Port int32
+	log  logr.Logger
+	// pollInterval is the interval at which the endpoints of a waking service are checked
+	pollInterval time.Duration
+	lock         sync.Mutex
+	// routes is the services of the hibernators keyed by the hosts through which they are requested
+	routes map[string]activatorRoute
+	// services is the services of each hibernator
+	services map[types.NamespacedName][]pincherv1alpha1.ServiceReference
+	// requested is the hibernators requested to wake up and not yet reconciled
+	requested map[types.NamespacedName]bool
+	// pointed is the routed services whose endpoints this activator pointed at itself. It starts empty on every
+	// leader so that the endpoints still pointing at the pod of a previous leader are pointed at this one.
+	pointed map[string]bool
+	events  chan event.GenericEvent
+}
+
+type activatorRoute struct {
+	hibernator types.NamespacedName
+	service    pincherv1alpha1.ServiceReference
+	timeout    time.Duration
+}
+
+func NewActivator(kubectl pkg.KubectlCmd, ip string, port int32, log logr.Logger) *Activator {
+	return &Activator{
+		Kubectl:      kubectl,
+		IP:           ip,
+		Port:         port,
+		log:          log,
+		pollInterval: activatorPollInterval,
+		routes:       make(map[string]activatorRoute),
+		services:     make(map[types.NamespacedName][]pincherv1alpha1.ServiceReference),
+		requested:    make(map[types.NamespacedName]bool),
+		pointed:      make(map[string]bool),
+		events:       make(chan event.GenericEvent, activatorEventBufferSize),
+	}
+}
+
+// Source is the source of the reconcile requests of the hibernators requested to wake up
+func (a *Activator) Source() source.Source {
+	return &source.Channel{Source: a.events}
+}
+
+// Start serves the requests to the routed services until the context is done
+func (a *Activator) Start(ctx context.Context) error {
+	server := &http.Server{Addr: fmt.Sprintf(":%d", a.Port), Handler: a}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), activatorShutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// WakeRequested reports if a request to one of the services of the hibernator asked to wake it up and clears the
+// request
+func (a *Activator) WakeRequested(name types.NamespacedName) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	requested := a.requested[name]
+	delete(a.requested, name)
+	return requested
+}
+
+func (a *Activator) requestWake(name types.NamespacedName) {
+	a.lock.Lock()
+	if a.requested[name] {
+		a.lock.Unlock()
+		return
+	}
+	a.requested[name] = true
+	a.lock.Unlock()
+	hibernator := &pincherv1alpha1.Hibernator{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	// the hibernator is still woken up on its next timed run if the buffer is full
+	select {
+	case a.events <- event.GenericEvent{Object: hibernator}:
+	default:
+	}
+}
+
+// register indexes the services of the hibernator by host, replacing its previously indexed services
+func (a *Activator) register(hibernator *pincherv1alpha1.Hibernator) {
+	name := types.NamespacedName{Namespace: hibernator.Namespace, Name: hibernator.Name}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.remove(name)
+	if hibernator.Spec.WakeOnRequest == nil {
+		return
+	}
+	timeout := defaultWakeTimeout
+	if d, err := time.ParseDuration(hibernator.Spec.WakeOnRequest.Timeout); err == nil && d > 0 {
+		timeout = d
+	}
+	for _, service := range hibernator.Spec.WakeOnRequest.Services {
+		route := activatorRoute{hibernator: name, service: service, timeout: timeout}
+		for _, host := range serviceHosts(service) {
+			a.routes[host] = route
+		}
+	}
+	a.services[name] = hibernator.Spec.WakeOnRequest.Services
+}
+
+func (a *Activator) remove(name types.NamespacedName) {
+	for _, service := range a.services[name] {
+		for _, host := range serviceHosts(service) {
+			if a.routes[host].hibernator == name {
+				delete(a.routes, host)
+			}
+		}
+	}
+	delete(a.services, name)
+}
+
+// Release restores the services of a deleted hibernator which are still routed and drops them
+func (a *Activator) Release(ctx context.Context, name types.NamespacedName) {
+	a.lock.Lock()
+	services := a.services[name]
+	a.remove(name)
+	delete(a.requested, name)
+	for _, service := range services {
+		delete(a.pointed, service.Namespace+"/"+service.Name)
+	}
+	a.lock.Unlock()
+	for _, service := range services {
+		routed := pincherv1alpha1.RoutedService{Namespace: service.Namespace, Name: service.Name}
+		if err := a.restoreService(ctx, routed); err != nil {
+			a.log.Error(err, "failed to restore service of deleted hibernator", "service", service.Namespace+"/"+service.Name)
+		}
+	}
+}
+
+// serviceHosts returns the hosts through which the service is requested, its name is only unique within its namespace
+func serviceHosts(service pincherv1alpha1.ServiceReference) []string {
+	hosts := []string{
+		service.Name + "." + service.Namespace,
+		service.Name + "." + service.Namespace + ".svc",
+		service.Name + "." + service.Namespace + ".svc.cluster.local",
+	}
+	for _, host := range service.Hosts {
+		hosts = append(hosts, strings.ToLower(host))
+	}
+	return hosts
+}
+
+// getRoute returns the route of the host, a bare service name is matched when it is unique among the routed services
+func (a *Activator) getRoute(host string) (activatorRoute, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if route, ok := a.routes[host]; ok {
+		return route, true
+	}
+	var matched []activatorRoute
+	for _, services := range a.services {
+		for _, service := range services {
+			if service.Name == host {
+				matched = append(matched, a.routes[service.Name+"."+service.Namespace])
+			}
+		}
+	}
+	if len(matched) != 1 {
+		return activatorRoute{}, false
+	}
+	return matched[0], true
+}
+
+func (a *Activator) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	route, ok := a.getRoute(req.Host)
+	if !ok {
+		http.Error(w, fmt.Sprintf("no hibernated service is requested through %s", req.Host), http.StatusNotFound)
+		return
+	}
+	a.requestWake(route.hibernator)
+	ctx, cancel := context.WithTimeout(req.Context(), route.timeout)
+	defer cancel()
+	target, err := a.waitForEndpoint(ctx, route.service)
+	if err != nil {
+		a.log.Info("service not ready in time", "service", route.service.Namespace+"/"+route.service.Name, "error", err.Error())
+		w.Header().Set("Retry-After", "10")
+		http.Error(w, fmt.Sprintf("service %s/%s is waking up", route.service.Namespace, route.service.Name), http.StatusServiceUnavailable)
+		return
+	}
+	httputil.NewSingleHostReverseProxy(target).ServeHTTP(w, req)
+}
+
+// waitForEndpoint waits for the service to have a ready endpoint other than the activator and returns its URL
+func (a *Activator) waitForEndpoint(ctx context.Context, service pincherv1alpha1.ServiceReference) (*url.URL, error) {
+	ticker := time.NewTicker(a.pollInterval)
+	defer ticker.Stop()
+	for {
+		target, err := a.getEndpoint(ctx, service)
+		if err != nil || target != nil {
+			return target, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Activator) getEndpoint(ctx context.Context, service pincherv1alpha1.ServiceReference) (*url.URL, error) {
+	resp, err := a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: service.Name, Namespace: service.Namespace, GroupVersionKind: serviceGVK})
+	if err != nil {
+		return nil, err
+	}
+	portName, err := getServicePortName(resp.Manifest, service.Port)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: service.Name, Namespace: service.Namespace, GroupVersionKind: endpointsGVK})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	subsets, _, _ := unstructured.NestedSlice(resp.Manifest.Object, "subsets")
+	for _, subset := range subsets {
+		subsetMap, _ := subset.(map[string]interface{})
+		addresses, _, _ := unstructured.NestedSlice(subsetMap, "addresses")
+		ports, _, _ := unstructured.NestedSlice(subsetMap, "ports")
+		for _, port := range ports {
+			portMap, _ := port.(map[string]interface{})
+			name, _, _ := unstructured.NestedString(portMap, "name")
+			number, _, _ := unstructured.NestedInt64(portMap, "port")
+			if name != portName {
+				continue
+			}
+			for _, address := range addresses {
+				ip, _, _ := unstructured.NestedString(address.(map[string]interface{}), "ip")
+				if len(ip) != 0 && ip != a.IP {
+					return &url.URL{Scheme: "http", Host: net.JoinHostPort(ip, strconv.FormatInt(number, 10))}, nil
+				}
+			}
+		}
+	}
+	return nil, nil
+}
+
+// getServicePortName returns the name of the port of the service matching the name or number, the first port if not
+// set
+func getServicePortName(service unstructured.Unstructured, port string) (string, error) {
+	ports, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	for _, p := range ports {
+		portMap, _ := p.(map[string]interface{})
+		name, _, _ := unstructured.NestedString(portMap, "name")
+		number, _, _ := unstructured.NestedInt64(portMap, "port")
+		if len(port) == 0 || port == name || port == strconv.FormatInt(number, 10) {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("service %s/%s has no port %s", service.GetNamespace(), service.GetName(), port)
+}
+
+// Route routes the services of the hibernator to the activator while it is hibernated and restores them otherwise.
+// Services which could not be restored are kept in status to be retried in the next run. It reports if the routed
+// services changed.
+func (a *Activator) Route(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) bool {
+	a.register(hibernator)
+	wanted := make(map[string]bool)
+	var services []pincherv1alpha1.ServiceReference
+	if hibernator.Spec.WakeOnRequest != nil && hibernator.Status.Action == pincherv1alpha1.Hibernate {
+		services = hibernator.Spec.WakeOnRequest.Services
+	}
+	for _, service := range services {
+		wanted[service.Namespace+"/"+service.Name] = true
+	}
+
+	var routedServices []pincherv1alpha1.RoutedService
+	routed := make(map[string]bool)
+	for _, service := range hibernator.Status.RoutedServices {
+		key := service.Namespace + "/" + service.Name
+		if wanted[key] {
+			if err := a.pointEndpoints(ctx, service); err != nil {
+				a.log.Error(err, "failed to point endpoints at activator", "service", key)
+			}
+			routedServices = append(routedServices, service)
+			routed[key] = true
+			continue
+		}
+		if err := a.restoreService(ctx, service); err != nil {
+			a.log.Error(err, "failed to restore service", "service", key)
+			routedServices = append(routedServices, service)
+			continue
+		}
+		a.setPointed(key, false)
+	}
+	for _, service := range services {
+		key := service.Namespace + "/" + service.Name
+		if routed[key] {
+			continue
+		}
+		routedService, err := a.routeService(ctx, service)
+		if err != nil {
+			a.log.Error(err, "failed to route service", "service", key)
+			continue
+		}
+		a.setPointed(key, true)
+		routed[key] = true
+		routedServices = append(routedServices, routedService)
+	}
+	sort.Slice(routedServices, func(i, j int) bool {
+		return routedServices[i].Namespace+"/"+routedServices[i].Name < routedServices[j].Namespace+"/"+routedServices[j].Name
+	})
+	updated := len(routedServices) != len(hibernator.Status.RoutedServices)
+	for i := 0; !updated && i < len(routedServices); i++ {
+		updated = !reflect.DeepEqual(routedServices[i], hibernator.Status.RoutedServices[i])
+	}
+	hibernator.Status.RoutedServices = routedServices
+	return updated
+}
+
+// routeService removes the selector of the service, keeping it in an annotation, and points its endpoints at the
+// activator on every port of the service
+func (a *Activator) routeService(ctx context.Context, service pincherv1alpha1.ServiceReference) (pincherv1alpha1.RoutedService, error) {
+	routed := pincherv1alpha1.RoutedService{Namespace: service.Namespace, Name: service.Name}
+	resp, err := a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: service.Name, Namespace: service.Namespace, GroupVersionKind: serviceGVK})
+	if err != nil {
+		return routed, err
+	}
+	if resp.Manifest.GetName() == "" {
+		return routed, fmt.Errorf("service %s/%s not found", service.Namespace, service.Name)
+	}
+	selector, _, _ := unstructured.NestedStringMap(resp.Manifest.Object, "spec", "selector")
+	if len(selector) == 0 {
+		// a service routed before its selector was recorded in status
+		if err = json.Unmarshal([]byte(resp.Manifest.GetAnnotations()[routedSelectorAnnotation]), &selector); err != nil || len(selector) == 0 {
+			return routed, fmt.Errorf("service %s/%s has no selector", service.Namespace, service.Name)
+		}
+	}
+	routed.Selector = selector
+
+	selectorJSON, err := json.Marshal(selector)
+	if err != nil {
+		return routed, err
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{routedSelectorAnnotation: string(selectorJSON)}},
+		"spec":     map[string]interface{}{"selector": nil},
+	})
+	if err != nil {
+		return routed, err
+	}
+	_, err = a.Kubectl.PatchResource(ctx, &pkg.PatchRequest{
+		Name:             service.Name,
+		Namespace:        service.Namespace,
+		GroupVersionKind: serviceGVK,
+		Patch:            string(patch),
+		PatchType:        string(types.MergePatchType),
+	})
+	if err != nil {
+		return routed, err
+	}
+	return routed, a.setActivatorEndpoints(ctx, resp.Manifest)
+}
+
+// pointEndpoints points the endpoints of a service routed by a previous leader at this activator, once per service
+// as the endpoints of a routed service are left alone by the endpoints controller
+func (a *Activator) pointEndpoints(ctx context.Context, routed pincherv1alpha1.RoutedService) error {
+	key := routed.Namespace + "/" + routed.Name
+	if a.isPointed(key) {
+		return nil
+	}
+	resp, err := a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: routed.Name, Namespace: routed.Namespace, GroupVersionKind: serviceGVK})
+	if err != nil {
+		return err
+	}
+	if resp.Manifest.GetName() == "" {
+		return fmt.Errorf("service %s not found", key)
+	}
+	if err = a.setActivatorEndpoints(ctx, resp.Manifest); err != nil {
+		return err
+	}
+	a.setPointed(key, true)
+	return nil
+}
+
+func (a *Activator) isPointed(key string) bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.pointed[key]
+}
+
+func (a *Activator) setPointed(key string, pointed bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if pointed {
+		a.pointed[key] = true
+	} else {
+		delete(a.pointed, key)
+	}
+}
+
+// setActivatorEndpoints points the endpoints of the service at the activator, the endpoints of a service without a
+// selector being left to its owner by the endpoints controller
+func (a *Activator) setActivatorEndpoints(ctx context.Context, service unstructured.Unstructured) error {
+	var ports []interface{}
+	servicePorts, _, _ := unstructured.NestedSlice(service.Object, "spec", "ports")
+	for _, p := range servicePorts {
+		name, _, _ := unstructured.NestedString(p.(map[string]interface{}), "name")
+		ports = append(ports, map[string]interface{}{"name": name, "port": int64(a.Port), "protocol": "TCP"})
+	}
+	subsets := []interface{}{map[string]interface{}{
+		"addresses": []interface{}{map[string]interface{}{"ip": a.IP}},
+		"ports":     ports,
+	}}
+	resp, err := a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: service.GetName(), Namespace: service.GetNamespace(), GroupVersionKind: endpointsGVK})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && resp.Manifest.GetName() != "" {
+		patch, err := json.Marshal(map[string]interface{}{"subsets": subsets})
+		if err != nil {
+			return err
+		}
+		_, err = a.Kubectl.PatchResource(ctx, &pkg.PatchRequest{
+			Name:             service.GetName(),
+			Namespace:        service.GetNamespace(),
+			GroupVersionKind: endpointsGVK,
+			Patch:            string(patch),
+			PatchType:        string(types.MergePatchType),
+		})
+		return err
+	}
+	endpoints := unstructured.Unstructured{Object: map[string]interface{}{"subsets": subsets}}
+	endpoints.SetGroupVersionKind(endpointsGVK)
+	endpoints.SetName(service.GetName())
+	endpoints.SetNamespace(service.GetNamespace())
+	_, err = a.Kubectl.CreateResource(ctx, &pkg.CreateRequest{
+		Namespace:        service.GetNamespace(),
+		GroupVersionKind: endpointsGVK,
+		Manifest:         endpoints,
+	})
+	return err
+}
+
+// restoreService restores the selector of the service, from status or else from its annotation, the endpoints
+// controller then replaces the endpoints pointing at the activator
+func (a *Activator) restoreService(ctx context.Context, routed pincherv1alpha1.RoutedService) error {
+	selector := routed.Selector
+	if len(selector) == 0 {
+		resp, err := a.Kubectl.GetResource(ctx, &pkg.GetRequest{Name: routed.Name, Namespace: routed.Namespace, GroupVersionKind: serviceGVK})
+		if errors.IsNotFound(err) || (err == nil && resp.Manifest.GetName() == "") {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		annotation, ok := resp.Manifest.GetAnnotations()[routedSelectorAnnotation]
+		if !ok {
+			return nil
+		}
+		if err = json.Unmarshal([]byte(annotation), &selector); err != nil {
+			return err
+		}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]interface{}{routedSelectorAnnotation: nil}},
+		"spec":     map[string]interface{}{"selector": selector},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.Kubectl.PatchResource(ctx, &pkg.PatchRequest{
+		Name:             routed.Name,
+		Namespace:        routed.Namespace,
+		GroupVersionKind: serviceGVK,
+		Patch:            string(patch),
+		PatchType:        string(types.MergePatchType),
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// awakeOnRequest reports if the hibernator is kept awake by a request to one of its services, the wake up is cleared
+// once it expired
+func awakeOnRequest(hibernator *pincherv1alpha1.Hibernator, now time.Time) (awake bool, cleared bool) {
+	if _, ok := wakeOnRequestRemaining(hibernator, now); ok {
+		return true, false
+	}
+	cleared = hibernator.Status.WokenOnRequest != nil
+	hibernator.Status.WokenOnRequest = nil
+	return false, cleared
+}
+
+// wakeOnRequestRemaining returns how long the hibernator is still kept awake by a request to one of its services
+func wakeOnRequestRemaining(hibernator *pincherv1alpha1.Hibernator, now time.Time) (time.Duration, bool) {
+	if hibernator.Status.WokenOnRequest == nil || hibernator.Spec.WakeOnRequest == nil {
+		return 0, false
+	}
+	sleepAfter := defaultSleepAfter
+	if d, err := time.ParseDuration(hibernator.Spec.WakeOnRequest.SleepAfter); err == nil && d > 0 {
+		sleepAfter = d
+	}
+	remaining := hibernator.Status.WokenOnRequest.Add(sleepAfter).Sub(now)
+	return remaining, remaining > 0
+}
@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/go-logr/logr"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const activatorObjectsMock = `
+[
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "web", "namespace": "qa"}, "spec": {"selector": {"app": "web"}, "ports": [{"name": "http", "port": 80, "targetPort": 8080}]}},
+  {"apiVersion": "v1", "kind": "Endpoints", "metadata": {"name": "web", "namespace": "qa"}, "subsets": [{"addresses": [{"ip": "10.1.0.5"}], "ports": [{"name": "http", "port": 8080}]}]},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "external", "namespace": "qa"}, "spec": {"ports": [{"name": "http", "port": 80}]}}
+]`
+
+const activatorIP = "10.0.0.9"
+
+func getWakeOnRequestHibernator(services ...string) *pincherv1alpha1.Hibernator {
+	hibernator := &pincherv1alpha1.Hibernator{
+		ObjectMeta: metav1.ObjectMeta{Name: "qa", Namespace: "qa"},
+		Spec: pincherv1alpha1.HibernatorSpec{
+			Action:        pincherv1alpha1.Hibernate,
+			WakeOnRequest: &pincherv1alpha1.WakeOnRequest{},
+		},
+		Status: pincherv1alpha1.HibernatorStatus{Action: pincherv1alpha1.Hibernate},
+	}
+	for _, service := range services {
+		hibernator.Spec.WakeOnRequest.Services = append(hibernator.Spec.WakeOnRequest.Services, pincherv1alpha1.ServiceReference{Namespace: "qa", Name: service})
+	}
+	return hibernator
+}
+
+func getMockObject(kubectl pkg.KubectlCmd, kind, name string) unstructured.Unstructured {
+	resp, _ := kubectl.GetResource(context.Background(), &pkg.GetRequest{Name: name, Namespace: "qa", GroupVersionKind: metav1.SchemeGroupVersion.WithKind(kind)})
+	return resp.Manifest
+}
+
+func TestActivator_Route(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(activatorObjectsMock)
+	a := NewActivator(kubectl, activatorIP, 8090, logr.Discard())
+	hibernator := getWakeOnRequestHibernator("web", "external", "missing")
+
+	// services without a selector can't be routed
+	if updated := a.Route(context.Background(), hibernator); !updated {
+		t.Errorf("Route() = %v, want true", updated)
+	}
+	want := []pincherv1alpha1.RoutedService{{Namespace: "qa", Name: "web", Selector: map[string]string{"app": "web"}}}
+	if !reflect.DeepEqual(hibernator.Status.RoutedServices, want) {
+		t.Errorf("Route() routed services = %v, want %v", hibernator.Status.RoutedServices, want)
+	}
+	service := getMockObject(kubectl, "Service", "web")
+	if selector, found, _ := unstructured.NestedMap(service.Object, "spec", "selector"); found {
+		t.Errorf("Route() service selector = %v, want none", selector)
+	}
+	if annotation := service.GetAnnotations()[routedSelectorAnnotation]; annotation != `{"app":"web"}` {
+		t.Errorf("Route() routed selector annotation = %s", annotation)
+	}
+	endpoints := getMockObject(kubectl, "Endpoints", "web")
+	wantSubsets := []interface{}{map[string]interface{}{
+		"addresses": []interface{}{map[string]interface{}{"ip": activatorIP}},
+		"ports":     []interface{}{map[string]interface{}{"name": "http", "port": int64(8090), "protocol": "TCP"}},
+	}}
+	if subsets, _, _ := unstructured.NestedSlice(endpoints.Object, "subsets"); !reflect.DeepEqual(subsets, wantSubsets) {
+		t.Errorf("Route() endpoints = %v, want %v", subsets, wantSubsets)
+	}
+
+	if updated := a.Route(context.Background(), hibernator); updated {
+		t.Errorf("Route() = %v, want false when already routed", updated)
+	}
+
+	// woken up
+	hibernator.Status.Action = pincherv1alpha1.UnHibernate
+	if updated := a.Route(context.Background(), hibernator); !updated || hibernator.Status.RoutedServices != nil {
+		t.Errorf("Route() = %v, routed services = %v, want restored", updated, hibernator.Status.RoutedServices)
+	}
+	service = getMockObject(kubectl, "Service", "web")
+	if selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector"); !reflect.DeepEqual(selector, map[string]string{"app": "web"}) {
+		t.Errorf("Route() restored selector = %v", selector)
+	}
+	if _, ok := service.GetAnnotations()[routedSelectorAnnotation]; ok {
+		t.Errorf("Route() routed selector annotation not removed")
+	}
+}
+
+func TestActivator_RouteAfterLeaderChange(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(activatorObjectsMock)
+	hibernator := getWakeOnRequestHibernator("web")
+	NewActivator(kubectl, "10.0.0.9", 8090, logr.Discard()).Route(context.Background(), hibernator)
+
+	// the new leader points the endpoints of the services routed by the previous one at itself
+	a := NewActivator(kubectl, activatorIP, 8090, logr.Discard())
+	if updated := a.Route(context.Background(), hibernator); updated {
+		t.Errorf("Route() = %v, want false when already routed", updated)
+	}
+	endpoints := getMockObject(kubectl, "Endpoints", "web")
+	subsets, _, _ := unstructured.NestedSlice(endpoints.Object, "subsets")
+	if len(subsets) != 1 {
+		t.Fatalf("Route() endpoints = %v, want one subset", subsets)
+	}
+	addresses, _, _ := unstructured.NestedSlice(subsets[0].(map[string]interface{}), "addresses")
+	want := []interface{}{map[string]interface{}{"ip": activatorIP}}
+	if !reflect.DeepEqual(addresses, want) {
+		t.Errorf("Route() endpoint addresses = %v, want %v", addresses, want)
+	}
+}
+
+func TestActivator_Release(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(activatorObjectsMock)
+	a := NewActivator(kubectl, activatorIP, 8090, logr.Discard())
+	hibernator := getWakeOnRequestHibernator("web")
+	a.Route(context.Background(), hibernator)
+
+	a.Release(context.Background(), types.NamespacedName{Namespace: "qa", Name: "qa"})
+	service := getMockObject(kubectl, "Service", "web")
+	if selector, _, _ := unstructured.NestedStringMap(service.Object, "spec", "selector"); !reflect.DeepEqual(selector, map[string]string{"app": "web"}) {
+		t.Errorf("Release() restored selector = %v", selector)
+	}
+	if _, ok := a.getRoute("web.qa"); ok {
+		t.Errorf("Release() route of web.qa not removed")
+	}
+}
+
+func TestActivator_ServeHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "awake "+r.URL.Path)
+	}))
+	defer backend.Close()
+	backendIP, backendPort, _ := net.SplitHostPort(backend.Listener.Addr().String())
+
+	tests := []struct {
+		name     string
+		host     string
+		timeout  string
+		wake     bool
+		wantCode int
+		wantBody string
+	}{
+		{name: "woken up and proxied", host: "web.qa.svc.cluster.local:80", timeout: "5s", wake: true, wantCode: http.StatusOK, wantBody: "awake /status"},
+		{name: "service name", host: "web", timeout: "5s", wake: true, wantCode: http.StatusOK, wantBody: "awake /status"},
+		{name: "ingress host", host: "web.example.com", timeout: "5s", wake: true, wantCode: http.StatusOK, wantBody: "awake /status"},
+		{name: "not ready in time", host: "web.qa", timeout: "50ms", wantCode: http.StatusServiceUnavailable},
+		{name: "unknown host", host: "api.qa", timeout: "5s", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(activatorObjectsMock)
+			a := NewActivator(kubectl, activatorIP, 8090, logr.Discard())
+			a.pollInterval = 10 * time.Millisecond
+			hibernator := getWakeOnRequestHibernator("web")
+			hibernator.Spec.WakeOnRequest.Services[0].Hosts = []string{"Web.Example.com"}
+			hibernator.Spec.WakeOnRequest.Timeout = tt.timeout
+			a.Route(context.Background(), hibernator)
+
+			name := types.NamespacedName{Namespace: "qa", Name: "qa"}
+			done := make(chan struct{})
+			defer close(done)
+			if tt.wake {
+				// the hibernator wakes up the service once requested
+				go func() {
+					for !a.WakeRequested(name) {
+						select {
+						case <-done:
+							return
+						case <-time.After(5 * time.Millisecond):
+						}
+					}
+					hibernator.Status.Action = pincherv1alpha1.UnHibernate
+					a.Route(context.Background(), hibernator)
+					kubectl.PatchResource(context.Background(), &pkg.PatchRequest{
+						Name:             "web",
+						Namespace:        "qa",
+						GroupVersionKind: endpointsGVK,
+						Patch:            fmt.Sprintf(`{"subsets": [{"addresses": [{"ip": "%s"}], "ports": [{"name": "http", "port": %s}]}]}`, backendIP, backendPort),
+						PatchType:        string(types.MergePatchType),
+					})
+				}()
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "http://"+tt.host+"/status", nil)
+			recorder := httptest.NewRecorder()
+			a.ServeHTTP(recorder, request)
+			if recorder.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %d, want %d", recorder.Code, tt.wantCode)
+			}
+			if body, _ := io.ReadAll(recorder.Body); len(tt.wantBody) != 0 && string(body) != tt.wantBody {
+				t.Errorf("ServeHTTP() body = %s, want %s", body, tt.wantBody)
+			}
+			if tt.wantCode == http.StatusServiceUnavailable && !a.WakeRequested(name) {
+				t.Errorf("WakeRequested() = false, want true")
+			}
+		})
+	}
+}
+
+func Test_awakeOnRequest(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		wokenAgo    time.Duration
+		sleepAfter  string
+		wantAwake   bool
+		wantCleared bool
+	}{
+		{name: "not woken up"},
+		{name: "within default sleep after", wokenAgo: 10 * time.Minute, wantAwake: true},
+		{name: "after default sleep after", wokenAgo: 31 * time.Minute, wantCleared: true},
+		{name: "within sleep after", wokenAgo: 50 * time.Minute, sleepAfter: "1h", wantAwake: true},
+		{name: "after sleep after", wokenAgo: 6 * time.Minute, sleepAfter: "5m", wantCleared: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hibernator := getWakeOnRequestHibernator("web")
+			hibernator.Spec.WakeOnRequest.SleepAfter = tt.sleepAfter
+			if tt.wokenAgo != 0 {
+				hibernator.Status.WokenOnRequest = &metav1.Time{Time: now.Add(-tt.wokenAgo)}
+			}
+			awake, cleared := awakeOnRequest(hibernator, now)
+			if awake != tt.wantAwake || cleared != tt.wantCleared {
+				t.Errorf("awakeOnRequest() = %v, %v, want %v, %v", awake, cleared, tt.wantAwake, tt.wantCleared)
+			}
+			if (hibernator.Status.WokenOnRequest != nil) != tt.wantAwake {
+				t.Errorf("awakeOnRequest() woken on request = %v", hibernator.Status.WokenOnRequest)
+			}
+		})
+	}
+}
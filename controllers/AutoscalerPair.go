@@ -0,0 +1,430 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+)
+
+const (
+	autoscalerKind               = "HorizontalPodAutoscaler"
+	scaledObjectKind             = "ScaledObject"
+	verticalAutoscalerKind       = "VerticalPodAutoscaler"
+	kedaPausedReplicasAnnotation = `autoscaling.keda.sh/paused-replicas`
+	autoscalerReplicasPatch      = `{"spec": {"minReplicas": %d, "maxReplicas": %d}}`
+	pauseScaledObjectPatch       = `{"metadata": {"annotations": {"%s": "%d"}}}`
+	resumeScaledObjectPatch      = `{"metadata": {"annotations": {"%s": null}}}`
+	verticalAutoscalerModePatch  = `{"spec": {"updatePolicy": {"updateMode": %s}}}`
+)
+
+var (
+	autoscalerGVR         = schema.GroupVersionResource{Group: "autoscaling", Version: "v2", Resource: "horizontalpodautoscalers"}
+	scaledObjectGVR       = schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"}
+	verticalAutoscalerGVR = schema.GroupVersionResource{Group: "autoscaling.k8s.io", Version: "v1", Resource: "verticalpodautoscalers"}
+	autoscalerGVRs        = map[string]schema.GroupVersionResource{
+		autoscalerKind:         autoscalerGVR,
+		scaledObjectKind:       scaledObjectGVR,
+		verticalAutoscalerKind: verticalAutoscalerGVR,
+	}
+)
+
+// objectAutoscalers are the autoscalers of an object
+type objectAutoscalers struct {
+	// autoscaler is the HorizontalPodAutoscaler or KEDA ScaledObject scaling the object
+	autoscaler         *unstructured.Unstructured
+	verticalAutoscaler *unstructured.Unstructured
+}
+
+// pairAutoscalers replaces horizontal autoscalers with their scale target, as an autoscaler can not be scaled to 0 but
+// stops scaling once its target is scaled to 0, and returns the autoscalers of the objects keyed by their resource
+// key. Autoscalers of objects not selected through their autoscaler are looked up only if listAutoscalers is set. A
+// KEDA ScaledObject is preferred over the HorizontalPodAutoscaler it creates. Autoscalers whose target is not found
+// are kept as they are.
+func (r *ResourceActionImpl) pairAutoscalers(ctx context.Context, objects []unstructured.Unstructured, listAutoscalers bool) ([]unstructured.Unstructured, map[string]objectAutoscalers) {
+	var paired []unstructured.Unstructured
+	autoscalers := make(map[string]objectAutoscalers)
+	seen := make(map[string]bool)
+	add := func(object unstructured.Unstructured) {
+		if key := getResourceKey(object); !seen[key] {
+			seen[key] = true
+			paired = append(paired, object)
+		}
+	}
+	for _, object := range objects {
+		if object.GetKind() != autoscalerKind && object.GetKind() != scaledObjectKind {
+			add(object)
+			continue
+		}
+		target, ok := r.getScaleTarget(ctx, object)
+		if !ok {
+			add(object)
+			continue
+		}
+		key := getResourceKey(target)
+		if existing := autoscalers[key].autoscaler; existing == nil || existing.GetKind() == autoscalerKind {
+			autoscaler := object
+			autoscalers[key] = objectAutoscalers{autoscaler: &autoscaler}
+		}
+		add(target)
+	}
+	if !listAutoscalers {
+		return paired, autoscalers
+	}
+
+	namespaceAutoscalers := make(map[string][]unstructured.Unstructured)
+	for _, object := range paired {
+		kind := object.GetKind()
+		if kind == autoscalerKind || kind == scaledObjectKind {
+			continue
+		}
+		if _, ok := namespaceAutoscalers[object.GetNamespace()]; !ok {
+			namespaceAutoscalers[object.GetNamespace()] = r.listAutoscalers(ctx, object.GetNamespace())
+		}
+		key := getResourceKey(object)
+		pair := autoscalers[key]
+		for i, autoscaler := range namespaceAutoscalers[object.GetNamespace()] {
+			if !isScaleTarget(autoscaler, object) {
+				continue
+			}
+			switch autoscaler.GetKind() {
+			case verticalAutoscalerKind:
+				pair.verticalAutoscaler = &namespaceAutoscalers[object.GetNamespace()][i]
+			case scaledObjectKind:
+				pair.autoscaler = &namespaceAutoscalers[object.GetNamespace()][i]
+			default:
+				if pair.autoscaler == nil {
+					pair.autoscaler = &namespaceAutoscalers[object.GetNamespace()][i]
+				}
+			}
+		}
+		if pair.autoscaler != nil || pair.verticalAutoscaler != nil {
+			autoscalers[key] = pair
+		}
+	}
+	return paired, autoscalers
+}
+
+// getScaleTargetRef returns the object scaled by a HorizontalPodAutoscaler, ScaledObject or VerticalPodAutoscaler
+func getScaleTargetRef(autoscaler unstructured.Unstructured) (gvk schema.GroupVersionKind, name string, ok bool) {
+	field := "scaleTargetRef"
+	if autoscaler.GetKind() == verticalAutoscalerKind {
+		field = "targetRef"
+	}
+	apiVersion, _, _ := unstructured.NestedString(autoscaler.Object, "spec", field, "apiVersion")
+	kind, _, _ := unstructured.NestedString(autoscaler.Object, "spec", field, "kind")
+	name, _, _ = unstructured.NestedString(autoscaler.Object, "spec", field, "name")
+	// scaled objects target deployments unless specified otherwise
+	if autoscaler.GetKind() == scaledObjectKind && len(kind) == 0 {
+		apiVersion, kind = "apps/v1", "Deployment"
+	}
+	groupVersion, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil || len(kind) == 0 || len(name) == 0 {
+		return schema.GroupVersionKind{}, "", false
+	}
+	return groupVersion.WithKind(kind), name, true
+}
+
+func (r *ResourceActionImpl) getScaleTarget(ctx context.Context, autoscaler unstructured.Unstructured) (unstructured.Unstructured, bool) {
+	gvk, name, ok := getScaleTargetRef(autoscaler)
+	if !ok {
+		return unstructured.Unstructured{}, false
+	}
+	resp, err := r.Kubectl.GetResource(ctx, &pkg.GetRequest{
+		Name:             name,
+		Namespace:        autoscaler.GetNamespace(),
+		GroupVersionKind: gvk,
+	})
+	if err != nil || resp.Manifest.GetName() == "" {
+		return unstructured.Unstructured{}, false
+	}
+	return resp.Manifest, true
+}
+
+// listAutoscalers lists the horizontal pod autoscalers, scaled objects and vertical pod autoscalers of the namespace
+func (r *ResourceActionImpl) listAutoscalers(ctx context.Context, namespace string) []unstructured.Unstructured {
+	var autoscalers []unstructured.Unstructured
+	for kind, gvr := range autoscalerGVRs {
+		resp, err := r.Kubectl.ListResources(ctx, &pkg.ListRequest{
+			Namespace:            namespace,
+			GroupVersionResource: gvr,
+			ListOptions:          metav1.ListOptions{},
+		})
+		if err != nil {
+			continue
+		}
+		for _, manifest := range resp.Manifests {
+			if manifest.GetKind() == kind {
+				autoscalers = append(autoscalers, manifest)
+			}
+		}
+	}
+	return autoscalers
+}
+
+// isScaleTarget checks if object is the target of autoscaler, the version is ignored as the same object is served in
+// more than one version
+func isScaleTarget(autoscaler, object unstructured.Unstructured) bool {
+	gvk, name, ok := getScaleTargetRef(autoscaler)
+	return ok && gvk.Group == object.GroupVersionKind().Group && gvk.Kind == object.GetKind() && name == object.GetName()
+}
+
+func findPairedAutoscaler(hibernator *pincherv1alpha1.Hibernator, matches func(pincherv1alpha1.PairedAutoscaler) bool) (pincherv1alpha1.PairedAutoscaler, bool) {
+	for _, pairedAutoscaler := range hibernator.Status.PairedAutoscalers {
+		if matches(pairedAutoscaler) {
+			return pairedAutoscaler, true
+		}
+	}
+	return pincherv1alpha1.PairedAutoscaler{}, false
+}
+
+func removePairedAutoscaler(hibernator *pincherv1alpha1.Hibernator, resourceKey string) {
+	var remaining []pincherv1alpha1.PairedAutoscaler
+	for _, pairedAutoscaler := range hibernator.Status.PairedAutoscalers {
+		if pairedAutoscaler.ResourceKey != resourceKey {
+			remaining = append(remaining, pairedAutoscaler)
+		}
+	}
+	hibernator.Status.PairedAutoscalers = remaining
+}
+
+// pairAutoscaler records the min and max replicas of the autoscaler of a scaled down target. The autoscaler is left
+// as it is when the target is scaled to 0, otherwise both min and max replicas are set to the target replicas so
+// that the autoscaler does not scale the target back.
+func (r *ResourceActionImpl) pairAutoscaler(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, autoscaler unstructured.Unstructured, targetKey string, targetReplicaCount int) pincherv1alpha1.ImpactedObject {
+	key := getResourceKey(autoscaler)
+	impactedObject := pincherv1alpha1.ImpactedObject{
+		ResourceKey: key,
+		Message:     "autoscaler of " + targetKey,
+		Status:      "success",
+	}
+	if pairedAutoscaler, found := findPairedAutoscaler(hibernator, func(p pincherv1alpha1.PairedAutoscaler) bool { return p.ResourceKey == key }); found {
+		impactedObject.OriginalCount = pairedAutoscaler.MinReplicas
+	} else {
+		to, err := autoscaler.MarshalJSON()
+		if err != nil {
+			impactedObject.Status = "error"
+			impactedObject.Message = err.Error()
+			return impactedObject
+		}
+		// minReplicas defaults to 1
+		minReplicas := 1
+		if count := gjson.Get(string(to), "spec.minReplicas"); count.Exists() {
+			minReplicas = int(count.Int())
+		}
+		hibernator.Status.PairedAutoscalers = append(hibernator.Status.PairedAutoscalers, pincherv1alpha1.PairedAutoscaler{
+			ResourceKey: key,
+			TargetKey:   targetKey,
+			MinReplicas: minReplicas,
+			MaxReplicas: int(gjson.Get(string(to), "spec.maxReplicas").Int()),
+		})
+		impactedObject.OriginalCount = minReplicas
+	}
+	if targetReplicaCount == 0 {
+		return impactedObject
+	}
+
+	request := &pkg.PatchRequest{
+		Name:             autoscaler.GetName(),
+		Namespace:        autoscaler.GetNamespace(),
+		GroupVersionKind: autoscaler.GroupVersionKind(),
+		Patch:            fmt.Sprintf(autoscalerReplicasPatch, targetReplicaCount, targetReplicaCount),
+		PatchType:        string(types.MergePatchType),
+	}
+	if _, err := r.Kubectl.PatchResource(ctx, request); err != nil {
+		impactedObject.Status = "error"
+		impactedObject.Message = err.Error()
+	}
+	return impactedObject
+}
+
+// pauseScaledObject scales the target of a KEDA ScaledObject through the paused-replicas annotation, as KEDA would
+// otherwise revert the replicas through the HorizontalPodAutoscaler it manages. Scaled objects paused outside the
+// hibernator are left untouched.
+func (r *ResourceActionImpl) pauseScaledObject(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, scaledObject, target unstructured.Unstructured, targetReplicaCount int) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
+	key := getResourceKey(scaledObject)
+	pausedReplicas, paused := scaledObject.GetAnnotations()[kedaPausedReplicasAnnotation]
+	_, recorded := findPairedAutoscaler(hibernator, func(p pincherv1alpha1.PairedAutoscaler) bool { return p.ResourceKey == key })
+	if paused && !recorded {
+		return nil, []pincherv1alpha1.ExcludedObject{newExcludedObject(target, pincherv1alpha1.OptedOut, "scaled object "+key+" paused outside hibernator")}
+	}
+	if paused && pausedReplicas == strconv.Itoa(targetReplicaCount) {
+		return nil, []pincherv1alpha1.ExcludedObject{newExcludedObject(target, pincherv1alpha1.AlreadyAtTarget, "")}
+	}
+
+	to, err := target.MarshalJSON()
+	if err != nil {
+		return nil, []pincherv1alpha1.ExcludedObject{newExcludedObject(target, pincherv1alpha1.ExclusionError, err.Error())}
+	}
+	impactedObject := pincherv1alpha1.ImpactedObject{
+		ResourceKey:   getResourceKey(target),
+		OriginalCount: int(gjson.Get(string(to), replicaField(target)).Int()),
+		Message:       "paused through scaled object " + key,
+		Status:        "success",
+	}
+	request := &pkg.PatchRequest{
+		Name:             scaledObject.GetName(),
+		Namespace:        scaledObject.GetNamespace(),
+		GroupVersionKind: scaledObject.GroupVersionKind(),
+		Patch:            fmt.Sprintf(pauseScaledObjectPatch, kedaPausedReplicasAnnotation, targetReplicaCount),
+		PatchType:        string(types.MergePatchType),
+	}
+	if _, err = r.Kubectl.PatchResource(ctx, request); err != nil {
+		impactedObject.Status = "error"
+		impactedObject.Message = err.Error()
+	} else if !recorded {
+		so, _ := scaledObject.MarshalJSON()
+		hibernator.Status.PairedAutoscalers = append(hibernator.Status.PairedAutoscalers, pincherv1alpha1.PairedAutoscaler{
+			ResourceKey: key,
+			TargetKey:   impactedObject.ResourceKey,
+			MinReplicas: int(gjson.GetBytes(so, "spec.minReplicaCount").Int()),
+			MaxReplicas: int(gjson.GetBytes(so, "spec.maxReplicaCount").Int()),
+		})
+	}
+	return []pincherv1alpha1.ImpactedObject{impactedObject}, nil
+}
+
+// getPausedScaledObject returns the scaled object paused by the hibernator to scale down target
+func getPausedScaledObject(hibernator *pincherv1alpha1.Hibernator, targetKey string) (pincherv1alpha1.PairedAutoscaler, bool) {
+	return findPairedAutoscaler(hibernator, func(p pincherv1alpha1.PairedAutoscaler) bool {
+		_, _, _, kind, _ := componentsOfResourceKey(p.ResourceKey)
+		return p.TargetKey == targetKey && kind == scaledObjectKind
+	})
+}
+
+// resumeScaledObject removes the paused-replicas annotation so that KEDA scales the target again
+func (r *ResourceActionImpl) resumeScaledObject(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, scaledObject pincherv1alpha1.PairedAutoscaler) pincherv1alpha1.ImpactedObject {
+	namespace, group, version, kind, name := componentsOfResourceKey(scaledObject.ResourceKey)
+	request := &pkg.PatchRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: schema.GroupVersionKind{Group: group, Version: version, Kind: kind},
+		Patch:            fmt.Sprintf(resumeScaledObjectPatch, kedaPausedReplicasAnnotation),
+		PatchType:        string(types.MergePatchType),
+	}
+	_, err := r.Kubectl.PatchResource(ctx, request)
+
+	impactedObject := pincherv1alpha1.ImpactedObject{
+		ResourceKey: scaledObject.TargetKey,
+		Message:     "resumed through scaled object " + scaledObject.ResourceKey,
+		Status:      "success",
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		impactedObject.Status = "error"
+		impactedObject.Message = err.Error()
+		return impactedObject
+	}
+	removePairedAutoscaler(hibernator, scaledObject.ResourceKey)
+	return impactedObject
+}
+
+// pairVerticalAutoscaler switches off a vertical pod autoscaler in Auto mode while its target is hibernated, so that
+// it does not evict pods while the target is woken up
+func (r *ResourceActionImpl) pairVerticalAutoscaler(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, verticalAutoscaler unstructured.Unstructured, targetKey string) []pincherv1alpha1.ImpactedObject {
+	key := getResourceKey(verticalAutoscaler)
+	updateMode, _, _ := unstructured.NestedString(verticalAutoscaler.Object, "spec", "updatePolicy", "updateMode")
+	// update mode defaults to Auto
+	if len(updateMode) != 0 && updateMode != "Auto" {
+		return nil
+	}
+	if _, found := findPairedAutoscaler(hibernator, func(p pincherv1alpha1.PairedAutoscaler) bool { return p.ResourceKey == key }); found {
+		return nil
+	}
+
+	request := &pkg.PatchRequest{
+		Name:             verticalAutoscaler.GetName(),
+		Namespace:        verticalAutoscaler.GetNamespace(),
+		GroupVersionKind: verticalAutoscaler.GroupVersionKind(),
+		Patch:            fmt.Sprintf(verticalAutoscalerModePatch, strconv.Quote("Off")),
+		PatchType:        string(types.MergePatchType),
+	}
+	_, err := r.Kubectl.PatchResource(ctx, request)
+
+	impactedObject := pincherv1alpha1.ImpactedObject{
+		ResourceKey: key,
+		Message:     "vertical autoscaler of " + targetKey + " switched off",
+		Status:      "success",
+	}
+	if err != nil {
+		impactedObject.Status = "error"
+		impactedObject.Message = err.Error()
+	} else {
+		hibernator.Status.PairedAutoscalers = append(hibernator.Status.PairedAutoscalers, pincherv1alpha1.PairedAutoscaler{
+			ResourceKey: key,
+			TargetKey:   targetKey,
+			UpdateMode:  updateMode,
+		})
+	}
+	return []pincherv1alpha1.ImpactedObject{impactedObject}
+}
+
+// restorePairedAutoscalers restores the horizontal and vertical autoscalers whose target is awake, which is done
+// after the target is scaled up so that the autoscaler resumes from the original replica count. Scaled objects are
+// resumed along with their target.
+func (r *ResourceActionImpl) restorePairedAutoscalers(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, awake map[string]bool) []pincherv1alpha1.ImpactedObject {
+	impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
+	var remaining []pincherv1alpha1.PairedAutoscaler
+	for _, pairedAutoscaler := range hibernator.Status.PairedAutoscalers {
+		namespace, group, version, kind, name := componentsOfResourceKey(pairedAutoscaler.ResourceKey)
+		if !awake[pairedAutoscaler.TargetKey] || kind == scaledObjectKind {
+			remaining = append(remaining, pairedAutoscaler)
+			continue
+		}
+		patch := fmt.Sprintf(autoscalerReplicasPatch, pairedAutoscaler.MinReplicas, pairedAutoscaler.MaxReplicas)
+		message := "autoscaler of " + pairedAutoscaler.TargetKey
+		if kind == verticalAutoscalerKind {
+			updateMode := "null"
+			if len(pairedAutoscaler.UpdateMode) != 0 {
+				updateMode = strconv.Quote(pairedAutoscaler.UpdateMode)
+			}
+			patch = fmt.Sprintf(verticalAutoscalerModePatch, updateMode)
+			message = "vertical autoscaler of " + pairedAutoscaler.TargetKey + " restored"
+		}
+		request := &pkg.PatchRequest{
+			Name:             name,
+			Namespace:        namespace,
+			GroupVersionKind: schema.GroupVersionKind{Group: group, Version: version, Kind: kind},
+			Patch:            patch,
+			PatchType:        string(types.MergePatchType),
+		}
+		_, err := r.Kubectl.PatchResource(ctx, request)
+
+		impactedObject := pincherv1alpha1.ImpactedObject{
+			ResourceKey:   pairedAutoscaler.ResourceKey,
+			OriginalCount: pairedAutoscaler.MinReplicas,
+			Message:       message,
+			Status:        "success",
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			impactedObject.Status = "error"
+			impactedObject.Message = err.Error()
+			remaining = append(remaining, pairedAutoscaler)
+		}
+		impactedObjects = append(impactedObjects, impactedObject)
+	}
+	hibernator.Status.PairedAutoscalers = remaining
+	return impactedObjects
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	"reflect"
+	"testing"
+)
+
+func TestResourceActionImpl_autoscalerPair(t *testing.T) {
+	targetReplicas := []int{1}
+	tests := []struct {
+		name                  string
+		spec                  pincherv1alpha1.HibernatorSpec
+		kind                  string
+		wantReplicas          int64
+		wantAutoscalerMin     int64
+		wantAutoscalerMax     int64
+		wantPairedAutoscalers []pincherv1alpha1.PairedAutoscaler
+	}{
+		{
+			name:              "hibernate deployment leaves autoscaler as it is",
+			spec:              pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate},
+			kind:              "Deployment",
+			wantReplicas:      0,
+			wantAutoscalerMin: 2,
+			wantAutoscalerMax: 5,
+			wantPairedAutoscalers: []pincherv1alpha1.PairedAutoscaler{
+				{ResourceKey: "/hpa/autoscaling/v2/HorizontalPodAutoscaler/api", TargetKey: "/hpa/apps/v1/Deployment/api", MinReplicas: 2, MaxReplicas: 5},
+			},
+		},
+		{
+			name:              "scale autoscaler selects its target and pins the autoscaler",
+			spec:              pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Scale, TargetReplicas: &targetReplicas},
+			kind:              "HorizontalPodAutoscaler",
+			wantReplicas:      1,
+			wantAutoscalerMin: 1,
+			wantAutoscalerMax: 1,
+			wantPairedAutoscalers: []pincherv1alpha1.PairedAutoscaler{
+				{ResourceKey: "/hpa/autoscaling/v2/HorizontalPodAutoscaler/api", TargetKey: "/hpa/apps/v1/Deployment/api", MinReplicas: 2, MaxReplicas: 5},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(autoscaler_objects_mock)
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{Spec: tt.spec}
+			check := func(stage string, wantReplicas, wantMin, wantMax int64) {
+				deployment, _ := getMockObjects(kubectl, "hpa", "Deployment", "api")[0].MarshalJSON()
+				autoscaler, _ := getMockObjects(kubectl, "hpa", autoscalerKind, "api")[0].MarshalJSON()
+				replicas := gjson.Get(string(deployment), "spec.replicas").Int()
+				min, max := gjson.Get(string(autoscaler), "spec.minReplicas").Int(), gjson.Get(string(autoscaler), "spec.maxReplicas").Int()
+				if replicas != wantReplicas || min != wantMin || max != wantMax {
+					t.Errorf("%s replicas = %d, autoscaler = %d-%d, want %d, %d-%d", stage, replicas, min, max, wantReplicas, wantMin, wantMax)
+				}
+			}
+
+			impacted, _ := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "hpa", tt.kind, "api"))
+			if len(impacted) != 2 {
+				t.Errorf("ScaleActionFactory() impacted = %v, want target and autoscaler", impacted)
+			}
+			check("ScaleActionFactory()", tt.wantReplicas, tt.wantAutoscalerMin, tt.wantAutoscalerMax)
+			if !reflect.DeepEqual(hibernator.Status.PairedAutoscalers, tt.wantPairedAutoscalers) {
+				t.Errorf("ScaleActionFactory() pairedAutoscalers = %v, want %v", hibernator.Status.PairedAutoscalers, tt.wantPairedAutoscalers)
+			}
+
+			impacted, _ = r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "hpa", tt.kind, "api"))
+			if len(impacted) != 2 || impacted[0].ResourceKey != "/hpa/apps/v1/Deployment/api" {
+				t.Errorf("ResetScaleActionFactory() impacted = %v, want target followed by autoscaler", impacted)
+			}
+			check("ResetScaleActionFactory()", 3, 2, 5)
+			if len(hibernator.Status.PairedAutoscalers) != 0 {
+				t.Errorf("ResetScaleActionFactory() pairedAutoscalers = %v, want empty", hibernator.Status.PairedAutoscalers)
+			}
+		})
+	}
+}
+
+func TestResourceActionImpl_scaledObjectAndVerticalAutoscaler(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(keda_objects_mock)
+	r := &ResourceActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+	}
+	hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate}}
+	get := func(kind string) string {
+		j, _ := getMockObjects(kubectl, "keda", kind, "worker")[0].MarshalJSON()
+		return string(j)
+	}
+
+	impacted, excluded := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "keda", "Deployment", "worker"))
+	gotImpacted := make(map[string]string)
+	for _, object := range impacted {
+		gotImpacted[object.ResourceKey] = object.Message
+	}
+	wantImpacted := map[string]string{
+		"/keda/apps/v1/Deployment/worker":                          "paused through scaled object /keda/keda.sh/v1alpha1/ScaledObject/worker",
+		"/keda/autoscaling.k8s.io/v1/VerticalPodAutoscaler/worker": "vertical autoscaler of /keda/apps/v1/Deployment/worker switched off",
+	}
+	if !reflect.DeepEqual(gotImpacted, wantImpacted) || len(excluded) != 0 {
+		t.Errorf("ScaleActionFactory() impacted = %v, excluded = %v, want %v", gotImpacted, excluded, wantImpacted)
+	}
+	if paused := gjson.Get(get(scaledObjectKind), "metadata.annotations").Map()[kedaPausedReplicasAnnotation].Str; paused != "0" {
+		t.Errorf("ScaleActionFactory() paused replicas = %q, want 0", paused)
+	}
+	if replicas := gjson.Get(get("Deployment"), "spec.replicas").Int(); replicas != 2 {
+		t.Errorf("ScaleActionFactory() replicas = %d, want 2 as KEDA scales the target", replicas)
+	}
+	if mode := gjson.Get(get(verticalAutoscalerKind), "spec.updatePolicy.updateMode").Str; mode != "Off" {
+		t.Errorf("ScaleActionFactory() update mode = %q, want Off", mode)
+	}
+
+	// the next run finds the scaled object already paused
+	_, excluded = r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "keda", "Deployment", "worker"))
+	if len(excluded) != 1 || excluded[0].Reason != pincherv1alpha1.AlreadyAtTarget {
+		t.Errorf("ScaleActionFactory() excluded = %v, want already at target", excluded)
+	}
+
+	impacted, _ = r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "keda", "Deployment", "worker"))
+	if len(impacted) != 2 {
+		t.Errorf("ResetScaleActionFactory() impacted = %v, want target and vertical autoscaler", impacted)
+	}
+	if gjson.Get(get(scaledObjectKind), "metadata.annotations").Map()[kedaPausedReplicasAnnotation].Exists() {
+		t.Errorf("ResetScaleActionFactory() paused replicas annotation not removed")
+	}
+	if mode := gjson.Get(get(verticalAutoscalerKind), "spec.updatePolicy.updateMode"); mode.Exists() {
+		t.Errorf("ResetScaleActionFactory() update mode = %s, want unset", mode.Raw)
+	}
+	if len(hibernator.Status.PairedAutoscalers) != 0 {
+		t.Errorf("ResetScaleActionFactory() pairedAutoscalers = %v, want empty", hibernator.Status.PairedAutoscalers)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sync"
+	"time"
+)
+
+const driftEventBufferSize = 1024
+
+// DriftWatch maps changes to the replica count of hibernated objects, as seen by the informers of the selected
+// resources, back to the hibernators which scaled them and requests their reconciliation
+type DriftWatch struct {
+	lock sync.Mutex
+	// scaled is the replica count each hibernator scaled the object to keyed by resource key
+	scaled map[string]map[types.NamespacedName]int
+	// hibernated is the resource keys of the objects scaled by each hibernator
+	hibernated map[types.NamespacedName][]string
+	// drifted is the hibernators requested to be reconciled because of a change to one of their objects
+	drifted map[types.NamespacedName]bool
+	events  chan event.GenericEvent
+}
+
+func NewDriftWatch() *DriftWatch {
+	return &DriftWatch{
+		scaled:     make(map[string]map[types.NamespacedName]int),
+		hibernated: make(map[types.NamespacedName][]string),
+		drifted:    make(map[types.NamespacedName]bool),
+		events:     make(chan event.GenericEvent, driftEventBufferSize),
+	}
+}
+
+// Source is the source of the reconcile requests of the hibernators whose objects drifted
+func (d *DriftWatch) Source() source.Source {
+	return &source.Channel{Source: d.events}
+}
+
+// Update indexes the objects scaled by the hibernator from its status, replacing the previously indexed objects
+func (d *DriftWatch) Update(hibernator *pincherv1alpha1.Hibernator) {
+	name := types.NamespacedName{Namespace: hibernator.Namespace, Name: hibernator.Name}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.remove(name)
+	var keys []string
+	for resourceKey, replicas := range hibernator.Status.ScaledReplicas {
+		// changes to objects with a recorded override are already handled
+		if _, ok := getManualOverride(hibernator, resourceKey); ok {
+			continue
+		}
+		if d.scaled[resourceKey] == nil {
+			d.scaled[resourceKey] = make(map[types.NamespacedName]int)
+		}
+		d.scaled[resourceKey][name] = replicas
+		keys = append(keys, resourceKey)
+	}
+	if len(keys) > 0 {
+		d.hibernated[name] = keys
+	}
+}
+
+// Release drops the objects indexed for the hibernator
+func (d *DriftWatch) Release(name types.NamespacedName) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.remove(name)
+	delete(d.drifted, name)
+}
+
+func (d *DriftWatch) remove(name types.NamespacedName) {
+	for _, resourceKey := range d.hibernated[name] {
+		delete(d.scaled[resourceKey], name)
+		if len(d.scaled[resourceKey]) == 0 {
+			delete(d.scaled, resourceKey)
+		}
+	}
+	delete(d.hibernated, name)
+}
+
+// Drifted reports if the reconciliation of the hibernator was requested because one of its objects drifted and
+// clears the request
+func (d *DriftWatch) Drifted(name types.NamespacedName) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	drifted := d.drifted[name]
+	delete(d.drifted, name)
+	return drifted
+}
+
+func (d *DriftWatch) OnAdd(obj interface{}) {
+	d.check(obj)
+}
+
+func (d *DriftWatch) OnUpdate(oldObj, newObj interface{}) {
+	d.check(newObj)
+}
+
+func (d *DriftWatch) OnDelete(obj interface{}) {
+}
+
+// check requests the reconciliation of the hibernators which scaled the object to a replica count other than its own
+func (d *DriftWatch) check(obj interface{}) {
+	object, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return
+	}
+	d.lock.Lock()
+	hibernators := d.scaled[getResourceKey(*object)]
+	if len(hibernators) == 0 {
+		d.lock.Unlock()
+		return
+	}
+	to, err := object.MarshalJSON()
+	if err != nil {
+		d.lock.Unlock()
+		return
+	}
+	replicas := gjson.Get(string(to), replicaField(*object))
+	var drifted []types.NamespacedName
+	for name, scaled := range hibernators {
+		if replicas.Exists() && int(replicas.Int()) != scaled && !d.drifted[name] {
+			d.drifted[name] = true
+			drifted = append(drifted, name)
+		}
+	}
+	d.lock.Unlock()
+
+	for _, name := range drifted {
+		hibernator := &pincherv1alpha1.Hibernator{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+		// the hibernator is still reconciled on its next timed run if the buffer is full
+		select {
+		case d.events <- event.GenericEvent{Object: hibernator}:
+		default:
+		}
+	}
+}
+
+// handleDrift applies the drift policy of the hibernator to an object whose replica count differs from the count it
+// was scaled to. The object is excluded from the run when the change is respected or only recorded.
+func (r *ResourceActionImpl) handleDrift(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, obj unstructured.Unstructured, replicas int) (pincherv1alpha1.ExcludedObject, bool) {
+	resourceKey := getResourceKey(obj)
+	if hibernator.Spec.DriftPolicy == pincherv1alpha1.RespectManualWake || hibernator.Spec.DriftPolicy == pincherv1alpha1.AlertOnly {
+		if override, ok := getManualOverride(hibernator, resourceKey); ok {
+			return newExcludedObject(obj, pincherv1alpha1.ManuallyChanged, fmt.Sprintf("changed to %d replicas outside of the hibernator", override.Replicas)), true
+		}
+	}
+	scaled, ok := hibernator.Status.ScaledReplicas[resourceKey]
+	if !ok || scaled == replicas {
+		return pincherv1alpha1.ExcludedObject{}, false
+	}
+
+	switch hibernator.Spec.DriftPolicy {
+	case pincherv1alpha1.RespectManualWake:
+		// the object is released so that waking up leaves it at the replica count it was changed to
+		request := &pkg.PatchRequest{
+			Name:             obj.GetName(),
+			Namespace:        obj.GetNamespace(),
+			GroupVersionKind: obj.GroupVersionKind(),
+			Patch:            fmt.Sprintf(removeAnnotationMergePatch, replicaAnnotation),
+			PatchType:        string(types.MergePatchType),
+		}
+		if _, err := r.Kubectl.PatchResource(ctx, request); err != nil {
+			return newExcludedObject(obj, pincherv1alpha1.ExclusionError, "failed to release manually changed object: "+err.Error()), true
+		}
+		delete(hibernator.Status.OriginalReplicas, resourceKey)
+		delete(hibernator.Status.ScaledReplicas, resourceKey)
+	case pincherv1alpha1.AlertOnly:
+	default:
+		return pincherv1alpha1.ExcludedObject{}, false
+	}
+	recordManualOverride(hibernator, resourceKey, replicas)
+	return newExcludedObject(obj, pincherv1alpha1.ManuallyChanged, fmt.Sprintf("changed to %d replicas outside of the hibernator", replicas)), true
+}
+
+// getManualOverride returns the manual override recorded for the object
+func getManualOverride(hibernator *pincherv1alpha1.Hibernator, resourceKey string) (pincherv1alpha1.ManualOverride, bool) {
+	for _, override := range hibernator.Status.ManualOverrides {
+		if override.ResourceKey == resourceKey {
+			return override, true
+		}
+	}
+	return pincherv1alpha1.ManualOverride{}, false
+}
+
+// recordManualOverride records the change of the replica count of a hibernated object made outside of the hibernator
+func recordManualOverride(hibernator *pincherv1alpha1.Hibernator, resourceKey string, replicas int) {
+	for i, override := range hibernator.Status.ManualOverrides {
+		if override.ResourceKey == resourceKey {
+			hibernator.Status.ManualOverrides[i].Replicas = replicas
+			return
+		}
+	}
+	hibernator.Status.ManualOverrides = append(hibernator.Status.ManualOverrides, pincherv1alpha1.ManualOverride{
+		ResourceKey: resourceKey,
+		Replicas:    replicas,
+		DetectedAt:  metav1.Time{Time: time.Now()},
+	})
+}
+
+// clearManualOverrides drops the manual overrides once the objects are woken up and reports if there were any
+func clearManualOverrides(hibernator *pincherv1alpha1.Hibernator) bool {
+	cleared := len(hibernator.Status.ManualOverrides) > 0
+	hibernator.Status.ManualOverrides = nil
+	return cleared
+}
+
+func storeScaledReplicaCount(hibernator *pincherv1alpha1.Hibernator, resourceKey string, replicaCount int) {
+	if hibernator.Status.ScaledReplicas == nil {
+		hibernator.Status.ScaledReplicas = make(map[string]int)
+	}
+	hibernator.Status.ScaledReplicas[resourceKey] = replicaCount
+}
@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const driftObjectsMock = `
+[
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev", "annotations": {"hibernator.devtron.ai/replicas": "3"}}, "spec": {"replicas": 2}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker", "namespace": "dev", "annotations": {"hibernator.devtron.ai/replicas": "1"}}, "spec": {"replicas": 0}}
+]`
+
+func getDriftHibernator(policy pincherv1alpha1.DriftPolicy) *pincherv1alpha1.Hibernator {
+	return &pincherv1alpha1.Hibernator{
+		ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "dev"},
+		Spec:       pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate, DriftPolicy: policy},
+		Status: pincherv1alpha1.HibernatorStatus{
+			OriginalReplicas: map[string]int{"/dev/apps/v1/Deployment/web": 3, "/dev/apps/v1/Deployment/worker": 1},
+			ScaledReplicas:   map[string]int{"/dev/apps/v1/Deployment/web": 0, "/dev/apps/v1/Deployment/worker": 0},
+		},
+	}
+}
+
+func TestDriftWatch_check(t *testing.T) {
+	name := types.NamespacedName{Namespace: "dev", Name: "nightly"}
+	tests := []struct {
+		name        string
+		object      string
+		overridden  bool
+		wantDrifted bool
+	}{
+		{
+			name:        "replicas changed",
+			object:      `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev"}, "spec": {"replicas": 2}}`,
+			wantDrifted: true,
+		},
+		{
+			name:   "at scaled replicas",
+			object: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker", "namespace": "dev"}, "spec": {"replicas": 0}}`,
+		},
+		{
+			name:   "not hibernated",
+			object: `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api", "namespace": "dev"}, "spec": {"replicas": 2}}`,
+		},
+		{
+			name:       "override already recorded",
+			object:     `{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev"}, "spec": {"replicas": 2}}`,
+			overridden: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDriftWatch()
+			hibernator := getDriftHibernator(pincherv1alpha1.Enforce)
+			if tt.overridden {
+				recordManualOverride(hibernator, "/dev/apps/v1/Deployment/web", 2)
+			}
+			d.Update(hibernator)
+			object := &unstructured.Unstructured{}
+			if err := object.UnmarshalJSON([]byte(tt.object)); err != nil {
+				t.Fatal(err)
+			}
+			d.OnUpdate(object, object)
+			if got := len(d.events) == 1; got != tt.wantDrifted {
+				t.Errorf("check() event sent = %v, want %v", got, tt.wantDrifted)
+			}
+			if got := d.Drifted(name); got != tt.wantDrifted {
+				t.Errorf("Drifted() = %v, want %v", got, tt.wantDrifted)
+			}
+			if d.Drifted(name) {
+				t.Errorf("Drifted() not cleared")
+			}
+		})
+	}
+}
+
+func TestDriftWatch_informerEvents(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(driftObjectsMock)
+	resourceCache := pkg.NewCachedKubectl(kubectl)
+	d := NewDriftWatch()
+	resourceCache.AddEventHandler(d)
+	resourceCache.Retain("dev/nightly", []schema.GroupVersionResource{{Group: "apps", Version: "v1", Resource: "deployments"}})
+	defer resourceCache.Release("dev/nightly")
+	hibernator := getDriftHibernator(pincherv1alpha1.Enforce)
+	hibernator.Status.ScaledReplicas = map[string]int{"/dev/apps/v1/Deployment/worker": 0}
+	d.Update(hibernator)
+
+	_, err := kubectl.PatchResource(context.Background(), &pkg.PatchRequest{
+		Name:             "worker",
+		Namespace:        "dev",
+		GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Patch:            `{"spec": {"replicas": 1}}`,
+		PatchType:        string(types.MergePatchType),
+	})
+	if err != nil {
+		t.Fatalf("PatchResource() error = %v", err)
+	}
+	select {
+	case e := <-d.events:
+		if e.Object.GetName() != "nightly" || e.Object.GetNamespace() != "dev" {
+			t.Errorf("event object = %s/%s, want dev/nightly", e.Object.GetNamespace(), e.Object.GetName())
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("no event for the drifted object")
+	}
+}
+
+func TestResourceActionImpl_driftPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        pincherv1alpha1.DriftPolicy
+		wantImpacted  int
+		wantExcluded  string
+		wantReplicas  int64
+		wantOverrides int
+		wantOriginal  bool
+		wantWoken     int64
+	}{
+		{name: "enforce", policy: pincherv1alpha1.Enforce, wantImpacted: 1, wantReplicas: 0, wantOriginal: true, wantWoken: 3},
+		{name: "default", wantImpacted: 1, wantReplicas: 0, wantOriginal: true, wantWoken: 3},
+		{name: "respect manual wake", policy: pincherv1alpha1.RespectManualWake, wantExcluded: pincherv1alpha1.ManuallyChanged, wantReplicas: 2, wantOverrides: 1, wantWoken: 2},
+		{name: "alert only", policy: pincherv1alpha1.AlertOnly, wantExcluded: pincherv1alpha1.ManuallyChanged, wantReplicas: 2, wantOverrides: 1, wantOriginal: true, wantWoken: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(driftObjectsMock)
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := getDriftHibernator(tt.policy)
+
+			impacted, excluded := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", "web"))
+			if len(impacted) != tt.wantImpacted || (len(impacted) == 1 && !strings.HasPrefix(impacted[0].Message, "reverted")) {
+				t.Errorf("ScaleActionFactory() impacted = %v, want %d reverted", impacted, tt.wantImpacted)
+			}
+			if tt.wantImpacted == 1 && hibernator.Status.ScaledReplicas["/dev/apps/v1/Deployment/web"] != 0 {
+				t.Errorf("ScaleActionFactory() scaledReplicas = %v", hibernator.Status.ScaledReplicas)
+			}
+			gotExcluded := ""
+			if len(excluded) == 1 {
+				gotExcluded = excluded[0].Reason
+			}
+			if gotExcluded != tt.wantExcluded {
+				t.Errorf("ScaleActionFactory() excluded = %v, want %s", excluded, tt.wantExcluded)
+			}
+			j, _ := getMockObjects(kubectl, "dev", "Deployment", "web")[0].MarshalJSON()
+			if replicas := gjson.Get(string(j), "spec.replicas").Int(); replicas != tt.wantReplicas {
+				t.Errorf("ScaleActionFactory() replicas = %d, want %d", replicas, tt.wantReplicas)
+			}
+			if len(hibernator.Status.ManualOverrides) != tt.wantOverrides {
+				t.Errorf("ScaleActionFactory() manualOverrides = %v, want %d", hibernator.Status.ManualOverrides, tt.wantOverrides)
+			}
+			if _, ok := hibernator.Status.OriginalReplicas["/dev/apps/v1/Deployment/web"]; ok != tt.wantOriginal {
+				t.Errorf("ScaleActionFactory() originalReplicas = %v, want stored %v", hibernator.Status.OriginalReplicas, tt.wantOriginal)
+			}
+
+			// the override is kept on the next runs while hibernating
+			_, excluded = r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", "web"))
+			if tt.wantOverrides > 0 && (len(excluded) != 1 || excluded[0].Reason != pincherv1alpha1.ManuallyChanged) {
+				t.Errorf("ScaleActionFactory() excluded on re-sync = %v, want %s", excluded, pincherv1alpha1.ManuallyChanged)
+			}
+
+			r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "dev", "Deployment", "web"))
+			j, _ = getMockObjects(kubectl, "dev", "Deployment", "web")[0].MarshalJSON()
+			if replicas := gjson.Get(string(j), "spec.replicas").Int(); replicas != tt.wantWoken {
+				t.Errorf("ResetScaleActionFactory() replicas = %d, want %d", replicas, tt.wantWoken)
+			}
+			if _, ok := hibernator.Status.ScaledReplicas["/dev/apps/v1/Deployment/web"]; ok {
+				t.Errorf("ResetScaleActionFactory() scaledReplicas = %v, want web dropped", hibernator.Status.ScaledReplicas)
+			}
+			if clearManualOverrides(hibernator) != (tt.wantOverrides > 0) || !reflect.DeepEqual(hibernator.Status.ManualOverrides, []pincherv1alpha1.ManualOverride(nil)) {
+				t.Errorf("clearManualOverrides() manualOverrides = %v", hibernator.Status.ManualOverrides)
+			}
+		})
+	}
+}
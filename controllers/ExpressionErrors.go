@@ -0,0 +1,101 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sort"
+)
+
+// expressionFailure is an object for which a field selector expression failed to compile or evaluate to a bool
+type expressionFailure struct {
+	object unstructured.Unstructured
+	err    error
+}
+
+// getExpressionErrorPolicy returns the policy of the hibernator, defaulting to fail-closed with the delete action
+// as a failing exclusion would otherwise let the objects it was meant to protect be deleted
+func getExpressionErrorPolicy(hibernator *pincherv1alpha1.Hibernator) pincherv1alpha1.ExpressionErrorPolicy {
+	if len(hibernator.Spec.ExpressionErrorPolicy) != 0 {
+		return hibernator.Spec.ExpressionErrorPolicy
+	}
+	if hibernator.Spec.Action == pincherv1alpha1.Delete {
+		return pincherv1alpha1.FailClosed
+	}
+	return pincherv1alpha1.IgnoreExpressionErrors
+}
+
+// excludeFailedObjects moves the included objects for which an exclusion expression failed to the excluded objects
+func excludeFailedObjects(included []unstructured.Unstructured, excluded []pincherv1alpha1.ExcludedObject, failures []expressionFailure) ([]unstructured.Unstructured, []pincherv1alpha1.ExcludedObject) {
+	if len(failures) == 0 {
+		return included, excluded
+	}
+	failed := make(map[string]error)
+	for _, failure := range failures {
+		failed[getResourceKey(failure.object)] = failure.err
+	}
+	var remaining []unstructured.Unstructured
+	for _, object := range included {
+		if err, ok := failed[getResourceKey(object)]; ok {
+			excluded = append(excluded, newExcludedObject(object, pincherv1alpha1.ExclusionError, "exclusion failed: "+err.Error()))
+			continue
+		}
+		remaining = append(remaining, object)
+	}
+	return remaining, excluded
+}
+
+// excludeAllObjects moves all the included objects to the excluded objects with the message
+func excludeAllObjects(included []unstructured.Unstructured, excluded []pincherv1alpha1.ExcludedObject, message string) ([]unstructured.Unstructured, []pincherv1alpha1.ExcludedObject) {
+	for _, object := range included {
+		excluded = append(excluded, newExcludedObject(object, pincherv1alpha1.ExclusionError, message))
+	}
+	return nil, excluded
+}
+
+// getExpressionErrors groups the failures by expression, counting each object once
+func getExpressionErrors(failures []expressionFailure) []pincherv1alpha1.ExpressionError {
+	byExpression := make(map[string]*pincherv1alpha1.ExpressionError)
+	seen := make(map[string]bool)
+	var expressions []string
+	for _, failure := range failures {
+		expression, message := "", failure.err.Error()
+		var expressionErr *pkg.ExpressionError
+		if errors.As(failure.err, &expressionErr) {
+			expression, message = expressionErr.Expression, expressionErr.Err.Error()
+		}
+		key := expression + " " + getResourceKey(failure.object)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if byExpression[expression] == nil {
+			byExpression[expression] = &pincherv1alpha1.ExpressionError{Expression: expression, Error: message}
+			expressions = append(expressions, expression)
+		}
+		byExpression[expression].Objects++
+	}
+	sort.Strings(expressions)
+	var expressionErrors []pincherv1alpha1.ExpressionError
+	for _, expression := range expressions {
+		expressionErrors = append(expressionErrors, *byExpression[expression])
+	}
+	return expressionErrors
+}
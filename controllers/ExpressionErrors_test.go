@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"testing"
+)
+
+func Test_getExpressionErrorPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		action pincherv1alpha1.Action
+		policy pincherv1alpha1.ExpressionErrorPolicy
+		want   pincherv1alpha1.ExpressionErrorPolicy
+	}{
+		{name: "delete defaults to fail closed", action: pincherv1alpha1.Delete, want: pincherv1alpha1.FailClosed},
+		{name: "hibernate defaults to ignore", action: pincherv1alpha1.Hibernate, want: pincherv1alpha1.IgnoreExpressionErrors},
+		{name: "ignored for delete", action: pincherv1alpha1.Delete, policy: pincherv1alpha1.IgnoreExpressionErrors, want: pincherv1alpha1.IgnoreExpressionErrors},
+		{name: "fail closed for hibernate", action: pincherv1alpha1.Hibernate, policy: pincherv1alpha1.FailClosed, want: pincherv1alpha1.FailClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: tt.action, ExpressionErrorPolicy: tt.policy}}
+			if got := getExpressionErrorPolicy(hibernator); got != tt.want {
+				t.Errorf("getExpressionErrorPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getExpressionErrors(t *testing.T) {
+	object := func(name string) unstructured.Unstructured {
+		o := unstructured.Unstructured{}
+		o.SetAPIVersion("apps/v1")
+		o.SetKind("Deployment")
+		o.SetNamespace("dev")
+		o.SetName(name)
+		return o
+	}
+	failures := []expressionFailure{
+		{object: object("web"), err: &pkg.ExpressionError{Expression: "{{spec.replicas}} > 1", Err: errors.New("mismatched types")}},
+		{object: object("api"), err: &pkg.ExpressionError{Expression: "{{spec.replicas}} > 1", Err: errors.New("type error")}},
+		// the same object failing through another selector
+		{object: object("web"), err: &pkg.ExpressionError{Expression: "{{spec.replicas}} > 1", Err: errors.New("mismatched types")}},
+		{object: object("web"), err: &pkg.ExpressionError{Expression: "Age() > 0", Err: errors.New("invalid time")}},
+	}
+	want := []pincherv1alpha1.ExpressionError{
+		{Expression: "Age() > 0", Error: "invalid time", Objects: 1},
+		{Expression: "{{spec.replicas}} > 1", Error: "mismatched types", Objects: 2},
+	}
+	if got := getExpressionErrors(failures); !reflect.DeepEqual(got, want) {
+		t.Errorf("getExpressionErrors() = %v, want %v", got, want)
+	}
+}
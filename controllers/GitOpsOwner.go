@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+)
+
+const (
+	argoTrackingIdAnnotation        = `argocd.argoproj.io/tracking-id`
+	argoInstanceLabel               = `app.kubernetes.io/instance`
+	fluxKustomizationNameLabel      = `kustomize.toolkit.fluxcd.io/name`
+	fluxKustomizationNamespaceLabel = `kustomize.toolkit.fluxcd.io/namespace`
+	fluxHelmReleaseNameLabel        = `helm.toolkit.fluxcd.io/name`
+	fluxHelmReleaseNamespaceLabel   = `helm.toolkit.fluxcd.io/namespace`
+	defaultArgoCDNamespace          = `argocd`
+	suspendArgoApplicationPatch     = `{"spec": {"syncPolicy": {"automated": null}}}`
+	restoreArgoApplicationPatch     = `{"spec": {"syncPolicy": {"automated": %s}}}`
+	suspendFluxPatch                = `{"spec": {"suspend": true}}`
+	restoreFluxPatch                = `{"spec": {"suspend": %s}}`
+)
+
+// the kinds of the GitOps owners are looked up in the version preferred by the cluster, eg v2beta1, v2beta2 or v2 for
+// Flux helm releases depending on the version of Flux
+var (
+	argoApplicationGVK   = schema.GroupVersionKind{Group: "argoproj.io", Kind: "Application"}
+	fluxKustomizationGVK = schema.GroupVersionKind{Group: "kustomize.toolkit.fluxcd.io", Kind: "Kustomization"}
+	fluxHelmReleaseGVK   = schema.GroupVersionKind{Group: "helm.toolkit.fluxcd.io", Kind: "HelmRelease"}
+)
+
+// getGitOpsOwner returns the Argo CD Application, Flux HelmRelease or Flux Kustomization managing obj as found from
+// its tracking labels and annotations
+func getGitOpsOwner(obj unstructured.Unstructured, argoCDNamespace string) (gvk schema.GroupVersionKind, namespace, name string, ok bool) {
+	labels := obj.GetLabels()
+	if name := labels[fluxHelmReleaseNameLabel]; name != "" {
+		return fluxHelmReleaseGVK, labels[fluxHelmReleaseNamespaceLabel], name, true
+	}
+	if name := labels[fluxKustomizationNameLabel]; name != "" {
+		return fluxKustomizationGVK, labels[fluxKustomizationNamespaceLabel], name, true
+	}
+	if argoCDNamespace == "" {
+		argoCDNamespace = defaultArgoCDNamespace
+	}
+	if trackingId := obj.GetAnnotations()[argoTrackingIdAnnotation]; trackingId != "" {
+		// tracking id is of the form <application>:<group>/<kind>:<namespace>/<name>, application is prefixed by
+		// <namespace>_ when it is not in the Argo CD namespace
+		application := strings.SplitN(trackingId, ":", 2)[0]
+		if components := strings.SplitN(application, "_", 2); len(components) == 2 {
+			return argoApplicationGVK, components[0], components[1], true
+		}
+		return argoApplicationGVK, argoCDNamespace, application, true
+	}
+	if name := labels[argoInstanceLabel]; name != "" {
+		return argoApplicationGVK, argoCDNamespace, name, true
+	}
+	return schema.GroupVersionKind{}, "", "", false
+}
+
+// isArgoDestination reports if obj is deployed by the Argo CD application. Objects carrying a tracking id are, those
+// found through app.kubernetes.io/instance must be in the destination namespace of the application as the label is
+// set by Helm charts and others as well.
+func isArgoDestination(application unstructured.Unstructured, obj unstructured.Unstructured) bool {
+	if obj.GetAnnotations()[argoTrackingIdAnnotation] != "" {
+		return true
+	}
+	namespace, _, _ := unstructured.NestedString(application.Object, "spec", "destination", "namespace")
+	return namespace != "" && namespace == obj.GetNamespace()
+}
+
+// suspendGitOpsOwner suspends auto sync or reconciliation of the GitOps owner of obj and records its original settings
+// in status. Owners already suspended, either by the hibernator or otherwise, are left untouched.
+func (r *ResourceActionImpl) suspendGitOpsOwner(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, obj unstructured.Unstructured) error {
+	if !hibernator.Spec.GitOps.SuspendOwners {
+		return nil
+	}
+	gvk, namespace, name, ok := getGitOpsOwner(obj, hibernator.Spec.GitOps.ArgoCDNamespace)
+	if !ok {
+		return nil
+	}
+	resp, err := r.Kubectl.GetResource(ctx, &pkg.GetRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: gvk,
+	})
+	// labels such as app.kubernetes.io/instance are not always set by a GitOps tool, which may not even be installed
+	if errors.IsNotFound(err) || meta.IsNoMatchError(err) || (err == nil && resp.Manifest.GetName() == "") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	owner := resp.Manifest
+	if gvk.Kind == argoApplicationGVK.Kind && !isArgoDestination(owner, obj) {
+		return nil
+	}
+	ownerKey := getResourceKey(owner)
+	for _, suspendedOwner := range hibernator.Status.SuspendedOwners {
+		if suspendedOwner.ResourceKey == ownerKey {
+			return nil
+		}
+	}
+
+	to, err := owner.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	patch := suspendFluxPatch
+	setting := gjson.Get(string(to), "spec.suspend")
+	if gvk.Kind == argoApplicationGVK.Kind {
+		patch = suspendArgoApplicationPatch
+		setting = gjson.Get(string(to), "spec.syncPolicy.automated")
+		if !setting.Exists() {
+			return nil
+		}
+	} else if setting.Bool() {
+		return nil
+	}
+	originalSettings := setting.Raw
+	if !setting.Exists() {
+		originalSettings = "null"
+	}
+
+	request := &pkg.PatchRequest{
+		Name:             owner.GetName(),
+		Namespace:        owner.GetNamespace(),
+		GroupVersionKind: owner.GroupVersionKind(),
+		Patch:            patch,
+		PatchType:        string(types.MergePatchType),
+	}
+	if _, err = r.Kubectl.PatchResource(ctx, request); err != nil {
+		return err
+	}
+	hibernator.Status.SuspendedOwners = append(hibernator.Status.SuspendedOwners, pincherv1alpha1.SuspendedOwner{
+		ResourceKey:      ownerKey,
+		OriginalSettings: originalSettings,
+		Status:           "success",
+	})
+	return nil
+}
+
+// ResumeGitOpsOwners restores the original settings of the GitOps owners suspended during hibernation. Owners which
+// could not be restored are kept in status to be retried in the next run.
+func (r *ResourceActionImpl) ResumeGitOpsOwners(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) []pincherv1alpha1.SuspendedOwner {
+	resumedOwners := make([]pincherv1alpha1.SuspendedOwner, 0)
+	var failedOwners []pincherv1alpha1.SuspendedOwner
+	for _, owner := range hibernator.Status.SuspendedOwners {
+		namespace, group, version, kind, name := componentsOfResourceKey(owner.ResourceKey)
+		patch := fmt.Sprintf(restoreFluxPatch, owner.OriginalSettings)
+		if kind == argoApplicationGVK.Kind {
+			patch = fmt.Sprintf(restoreArgoApplicationPatch, owner.OriginalSettings)
+		}
+		request := &pkg.PatchRequest{
+			Name:             name,
+			Namespace:        namespace,
+			GroupVersionKind: schema.GroupVersionKind{Group: group, Version: version, Kind: kind},
+			Patch:            patch,
+			PatchType:        string(types.MergePatchType),
+		}
+		_, err := r.Kubectl.PatchResource(ctx, request)
+
+		owner.Status = "success"
+		owner.Message = ""
+		if err != nil && !errors.IsNotFound(err) {
+			owner.Status = "error"
+			owner.Message = err.Error()
+			failedOwners = append(failedOwners, owner)
+		}
+		resumedOwners = append(resumedOwners, owner)
+	}
+	hibernator.Status.SuspendedOwners = failedOwners
+	return resumedOwners
+}
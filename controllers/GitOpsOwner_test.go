@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"reflect"
+	"testing"
+)
+
+func TestResourceActionImpl_suspendAndResumeGitOpsOwners(t *testing.T) {
+	tests := []struct {
+		name                string
+		suspendOwners       bool
+		namespace           string
+		objects             []string
+		unservedKinds       []string
+		wantSuspendedOwners map[string]string
+		wantArgoAutomated   bool
+		wantFluxSuspend     bool
+	}{
+		{
+			name:          "suspend argo application and flux kustomization",
+			suspendOwners: true,
+			namespace:     "dev",
+			objects:       []string{"payments-api", "payments-worker", "catalog", "helm-chart"},
+			wantSuspendedOwners: map[string]string{
+				"/argocd/argoproj.io/v1alpha1/Application/payments":              `{"prune":true,"selfHeal":true}`,
+				"/flux-system/kustomize.toolkit.fluxcd.io/v1/Kustomization/apps": "null",
+			},
+			wantArgoAutomated: false,
+			wantFluxSuspend:   true,
+		},
+		{
+			name:                "gitops integration disabled",
+			suspendOwners:       false,
+			namespace:           "dev",
+			objects:             []string{"payments-api", "catalog"},
+			wantSuspendedOwners: map[string]string{},
+			wantArgoAutomated:   true,
+			wantFluxSuspend:     false,
+		},
+		{
+			name:                "instance label outside the application destination",
+			suspendOwners:       true,
+			namespace:           "qa",
+			objects:             []string{"payments-worker"},
+			wantSuspendedOwners: map[string]string{},
+			wantArgoAutomated:   true,
+			wantFluxSuspend:     false,
+		},
+		{
+			name:                "gitops tools not installed",
+			suspendOwners:       true,
+			namespace:           "dev",
+			objects:             []string{"payments-api", "catalog", "helm-chart"},
+			unservedKinds:       []string{"Application", "Kustomization", "HelmRelease"},
+			wantSuspendedOwners: map[string]string{},
+			wantArgoAutomated:   true,
+			wantFluxSuspend:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(gitops_objects_mock)
+			unserved := make(map[string]bool)
+			for _, kind := range tt.unservedKinds {
+				unserved[kind] = true
+			}
+			r := &ResourceActionImpl{
+				Kubectl:     &unservedKubectl{KubectlCmd: kubectl, kinds: unserved},
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{
+				Action: pincherv1alpha1.Hibernate,
+				GitOps: pincherv1alpha1.GitOps{SuspendOwners: tt.suspendOwners},
+			}}
+			impacted, _ := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, tt.namespace, "Deployment", tt.objects...))
+			if len(impacted) != len(tt.objects) {
+				t.Errorf("ScaleActionFactory() impacted = %v, want %d objects", impacted, len(tt.objects))
+			}
+			gotSuspendedOwners := make(map[string]string)
+			for _, owner := range hibernator.Status.SuspendedOwners {
+				gotSuspendedOwners[owner.ResourceKey] = owner.OriginalSettings
+			}
+			if !reflect.DeepEqual(gotSuspendedOwners, tt.wantSuspendedOwners) {
+				t.Errorf("ScaleActionFactory() suspendedOwners = %v, want %v", gotSuspendedOwners, tt.wantSuspendedOwners)
+			}
+			application, _ := getMockObjects(kubectl, "argocd", "Application", "payments")[0].MarshalJSON()
+			if automated := gjson.Get(string(application), "spec.syncPolicy.automated").Exists(); automated != tt.wantArgoAutomated {
+				t.Errorf("ScaleActionFactory() application automated = %v, want %v", automated, tt.wantArgoAutomated)
+			}
+			kustomization, _ := getMockObjects(kubectl, "flux-system", "Kustomization", "apps")[0].MarshalJSON()
+			if suspend := gjson.Get(string(kustomization), "spec.suspend").Bool(); suspend != tt.wantFluxSuspend {
+				t.Errorf("ScaleActionFactory() kustomization suspend = %v, want %v", suspend, tt.wantFluxSuspend)
+			}
+
+			resumed := r.ResumeGitOpsOwners(context.Background(), hibernator)
+			if len(resumed) != len(tt.wantSuspendedOwners) || len(hibernator.Status.SuspendedOwners) != 0 {
+				t.Errorf("ResumeGitOpsOwners() resumed = %v, remaining = %v", resumed, hibernator.Status.SuspendedOwners)
+			}
+			application, _ = getMockObjects(kubectl, "argocd", "Application", "payments")[0].MarshalJSON()
+			if automated := gjson.Get(string(application), "spec.syncPolicy.automated.selfHeal").Bool(); !automated {
+				t.Errorf("ResumeGitOpsOwners() application automated not restored: %s", application)
+			}
+			kustomization, _ = getMockObjects(kubectl, "flux-system", "Kustomization", "apps")[0].MarshalJSON()
+			if suspend := gjson.Get(string(kustomization), "spec.suspend"); suspend.Exists() {
+				t.Errorf("ResumeGitOpsOwners() kustomization suspend = %v, want removed", suspend.Raw)
+			}
+		})
+	}
+}
+
+// unservedKubectl fails to get the objects of the kinds as a cluster without their custom resource definitions does
+type unservedKubectl struct {
+	pkg.KubectlCmd
+	kinds map[string]bool
+}
+
+func (k *unservedKubectl) GetResource(ctx context.Context, r *pkg.GetRequest) (*pkg.ManifestResponse, error) {
+	if k.kinds[r.GroupVersionKind.Kind] {
+		return nil, &meta.NoKindMatchError{GroupKind: r.GroupVersionKind.GroupKind()}
+	}
+	return k.KubectlCmd.GetResource(ctx, r)
+}
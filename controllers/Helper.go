@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 func getResourceKey(obj unstructured.Unstructured) string {
@@ -42,3 +44,64 @@ func getKey(hibernator *v1alpha1.Hibernator) string {
 func getNamespacedName(hibernator *v1alpha1.Hibernator) string {
 	return fmt.Sprintf("/%s/%s", hibernator.Namespace, hibernator.Name)
 }
+
+// escapeJSONPointer escapes a key to be used as a segment of JSON patch path
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
+
+// isOptedOut checks if the object has opted out of hibernation through the exclude annotation
+func isOptedOut(obj unstructured.Unstructured) bool {
+	optedOut, err := strconv.ParseBool(strings.TrimSpace(obj.GetAnnotations()[excludeAnnotation]))
+	return err == nil && optedOut
+}
+
+// getIntAnnotation returns the integer value of the annotation, ok is false if it is missing or not an integer
+func getIntAnnotation(obj unstructured.Unstructured, annotation string) (value int, ok bool) {
+	raw, found := obj.GetAnnotations()[annotation]
+	if !found {
+		return 0, false
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// getHelmRelease returns the Helm release managing obj as found from the annotations set by Helm, falling back to the
+// app.kubernetes.io/instance label
+func getHelmRelease(obj unstructured.Unstructured) (namespace, name string, ok bool) {
+	annotations := obj.GetAnnotations()
+	if name := annotations[helmReleaseNameAnnotation]; name != "" {
+		namespace := annotations[helmReleaseNamespaceAnnotation]
+		if namespace == "" {
+			namespace = obj.GetNamespace()
+		}
+		return namespace, name, true
+	}
+	if name := obj.GetLabels()[argoInstanceLabel]; name != "" {
+		return obj.GetNamespace(), name, true
+	}
+	return "", "", false
+}
+
+// runBounded calls work for every index in [0, count) with at most parallelism calls running at a time, parallelism
+// defaults to defaultParallelism
+func runBounded(parallelism, count int, work func(i int)) {
+	if parallelism <= 0 {
+		parallelism = defaultParallelism
+	}
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallelism)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			work(i)
+		}(i)
+	}
+	wg.Wait()
+}
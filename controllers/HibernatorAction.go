@@ -17,27 +17,34 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"fmt"
 	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/devtron-labs/winter-soldier/pkg"
 	"github.com/go-logr/logr"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"sort"
+	"strings"
 	//"sigs.k8s.io/controller-runtime/pkg/log"
 	"time"
 )
 
 type HibernatorAction interface {
-	hibernate(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool)
-	delete(hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool)
-	scale(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool)
-	executeRules(hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject)
+	hibernate(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool)
+	delete(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool)
+	scale(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool)
+	executeRules(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject)
 }
 
-func NewHibernatorActionImpl(kubectl pkg.KubectlCmd, historyUtil History, resourceAction ResourceAction, resourceSelector ResourceSelector, log logr.Logger) HibernatorAction {
+func NewHibernatorActionImpl(kubectl pkg.KubectlCmd, historyUtil History, resourceAction ResourceAction, resourceSelector ResourceSelector, idleDetector IdleDetector, log logr.Logger) HibernatorAction {
 	return &HibernatorActionImpl{
 		Kubectl:          kubectl,
 		historyUtil:      historyUtil,
 		resourceAction:   resourceAction,
 		resourceSelector: resourceSelector,
+		idleDetector:     idleDetector,
 		log:              log,
 	}
 }
@@ -47,40 +54,54 @@ type HibernatorActionImpl struct {
 	historyUtil      History
 	resourceAction   ResourceAction
 	resourceSelector ResourceSelector
-	log              logr.Logger
+	// idleDetector finds the objects to hibernate for being idle, it is optional
+	idleDetector IdleDetector
+	log          logr.Logger
 }
 
-func (r *HibernatorActionImpl) unHibernate(hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool) {
+func (r *HibernatorActionImpl) unHibernate(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool) {
 
 	reSync := hibernator.Status.Action == hibernator.Spec.Action
 
 	hibernator.Status.Action = pincherv1alpha1.UnHibernate
+	idleCleared := clearIdleState(hibernator)
 
-	impactedObjects, excludedObjects := r.executeRules(hibernator, r.resourceAction.ResetScaleActionFactory(hibernator), reSync)
+	impactedObjects, excludedObjects := r.executeRules(ctx, hibernator, r.resourceAction.ResetScaleActionFactory(ctx, hibernator), reSync)
+	gitOpsOwners := r.resourceAction.ResumeGitOpsOwners(ctx, hibernator)
+	locksUpdated := r.resourceAction.UnlockNamespaces(ctx, hibernator)
+	overridesCleared := clearManualOverrides(hibernator)
 
-	if len(impactedObjects) > 0 {
+	if len(impactedObjects) > 0 || len(gitOpsOwners) > 0 {
 		history := pincherv1alpha1.RevisionHistory{
 			Time:            metav1.Time{Time: time.Now()},
 			ID:              r.historyUtil.getNewRevisionID(hibernator.Status.History),
 			Action:          pincherv1alpha1.UnHibernate,
 			ImpactedObjects: impactedObjects,
 			ExcludedObjects: excludedObjects,
+			SuspendedOwners: gitOpsOwners,
 		}
 		hibernator.Status.History = r.historyUtil.addToHistory(history, hibernator.Status.History, reSync)
 	}
+	countsUpdated := r.updateExcludedCounts(hibernator, excludedObjects)
 
-	return hibernator, len(impactedObjects) > 0
+	return hibernator, len(impactedObjects) > 0 || len(gitOpsOwners) > 0 || countsUpdated || locksUpdated || overridesCleared || idleCleared
 }
 
-func (r *HibernatorActionImpl) hibernate(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool) {
+func (r *HibernatorActionImpl) hibernate(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool) {
 
 	impactedObjects, excludedObjects := make([]pincherv1alpha1.ImpactedObject, 0), make([]pincherv1alpha1.ExcludedObject, 0)
-	reSync := false
+	var gitOpsOwners []pincherv1alpha1.SuspendedOwner
+	var selections []ruleSelection
+	reSync, locksUpdated, overridesCleared, idleUpdated, detectIdle := false, false, false, false, false
 
 	shouldHibernate := timeGap.WithinRange
 	if hibernator.Spec.UnHibernate {
 		shouldHibernate = false
 	}
+	awake, wakeCleared := awakeOnRequest(hibernator, time.Now())
+	if awake {
+		shouldHibernate = false
+	}
 	if hibernator.Spec.Hibernate {
 		shouldHibernate = true
 	}
@@ -88,20 +109,35 @@ func (r *HibernatorActionImpl) hibernate(hibernator *pincherv1alpha1.Hibernator,
 	if shouldHibernate {
 		reSync = hibernator.Status.Action == pincherv1alpha1.Hibernate || hibernator.Status.Action == pincherv1alpha1.Sleep
 		hibernator.Status.Action = pincherv1alpha1.Hibernate
-		impactedObjects, excludedObjects = r.executeRules(hibernator, r.resourceAction.ScaleActionFactory(hibernator, timeGap), reSync)
+		impactedObjects, excludedObjects, gitOpsOwners = r.executeSuspendingOwners(ctx, hibernator, r.resourceAction.ScaleActionFactory(ctx, hibernator, timeGap), reSync)
+		var wholeNamespaces map[string]bool
+		if hibernator.Spec.LockNamespaces {
+			wholeNamespaces = r.resourceSelector.getWholeNamespaces(ctx, hibernator.Spec.Selectors)
+		}
+		locksUpdated = r.resourceAction.LockNamespaces(ctx, hibernator, impactedObjects, excludedObjects, wholeNamespaces)
+		// the objects hibernated for being idle are woken up at the end of the time range along with the others
+		idleUpdated = clearIdleState(hibernator)
 	} else {
+		detectIdle = hibernator.Spec.Idle != nil && !hibernator.Spec.UnHibernate && !awake && r.idleDetector != nil
+		if !detectIdle {
+			idleUpdated = clearIdleState(hibernator)
+		}
 		reSync = hibernator.Status.Action == pincherv1alpha1.UnHibernate
 		hibernator.Status.Action = pincherv1alpha1.UnHibernate
-		impactedObjects, excludedObjects = r.executeRules(hibernator, r.resourceAction.ResetScaleActionFactory(hibernator), reSync)
+		impactedObjects, excludedObjects, selections = r.selectAndExecuteRules(ctx, hibernator, r.resourceAction.ResetScaleActionFactory(ctx, hibernator), reSync)
+		gitOpsOwners = r.resourceAction.ResumeGitOpsOwners(ctx, hibernator)
+		locksUpdated = r.resourceAction.UnlockNamespaces(ctx, hibernator)
+		overridesCleared = clearManualOverrides(hibernator)
 	}
 
-	if len(impactedObjects) > 0 {
+	if len(impactedObjects) > 0 || len(gitOpsOwners) > 0 {
 		history := pincherv1alpha1.RevisionHistory{
 			Time:            metav1.Time{Time: time.Now()},
 			ID:              r.historyUtil.getNewRevisionID(hibernator.Status.History),
 			Action:          pincherv1alpha1.Hibernate,
 			ImpactedObjects: impactedObjects,
 			ExcludedObjects: excludedObjects,
+			SuspendedOwners: gitOpsOwners,
 		}
 		if !shouldHibernate {
 			history.Action = pincherv1alpha1.UnHibernate
@@ -109,18 +145,86 @@ func (r *HibernatorActionImpl) hibernate(hibernator *pincherv1alpha1.Hibernator,
 		hibernator.Status.History = r.historyUtil.addToHistory(history, hibernator.Status.History, reSync)
 	}
 
+	if detectIdle {
+		var idleExcludedObjects []pincherv1alpha1.ExcludedObject
+		idleUpdated, idleExcludedObjects = r.hibernateIdle(ctx, hibernator, timeGap, selections)
+		excludedObjects = uniqueExcludedObjects(append(excludedObjects, idleExcludedObjects...))
+	}
+
+	countsUpdated := r.updateExcludedCounts(hibernator, excludedObjects)
+
 	r.log.Info("hibernate Operation - current run within range : next scheduled run at : Impacted Objects : excluded Objects", "withinRange", timeGap.WithinRange, "timeGap", timeGap.TimeGapInSeconds, "impactedObject", impactedObjects, "excludedObject", excludedObjects)
 
-	return hibernator, len(impactedObjects) > 0
+	return hibernator, len(impactedObjects) > 0 || len(gitOpsOwners) > 0 || countsUpdated || locksUpdated || overridesCleared || idleUpdated || wakeCleared
 }
 
-func (r *HibernatorActionImpl) delete(hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool) {
+// hibernateIdle hibernates the objects which stayed idle for the idle duration among the objects selected by the
+// rules earlier in the run, recording them in a history entry of their own, and reports if the idle state of the
+// objects changed. The objects are not selected again, so that the status of the selection is left as is and the
+// objects kept awake are not woken up twice.
+func (r *HibernatorActionImpl) hibernateIdle(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap, selections []ruleSelection) (bool, []pincherv1alpha1.ExcludedObject) {
+	idleHibernated := len(hibernator.Status.IdleHibernated)
+	previousIdleSince := make(map[string]metav1.Time, len(hibernator.Status.IdleSince))
+	for resourceKey, since := range hibernator.Status.IdleSince {
+		previousIdleSince[resourceKey] = since
+	}
+	suspendedCount := len(hibernator.Status.SuspendedOwners)
+	scale := r.resourceAction.ScaleActionFactory(ctx, hibernator, timeGap)
+	impactedObjects, excludedObjects := make([]pincherv1alpha1.ImpactedObject, 0), make([]pincherv1alpha1.ExcludedObject, 0)
+	var conditionFailures []expressionFailure
+	for _, selection := range selections {
+		if ctx.Err() != nil {
+			break
+		}
+		idleObjects, idleExcludedObjects, failures := r.idleDetector.getIdleObjects(ctx, hibernator, selection.included)
+		conditionFailures = append(conditionFailures, failures...)
+		idleImpactedObjects, scaleExcludedObjects := scale(idleObjects)
+		markIdleHibernated(hibernator, idleImpactedObjects, idleObjects)
+		for i := range idleImpactedObjects {
+			idleImpactedObjects[i].RuleIndex = selection.ruleIndex
+			idleImpactedObjects[i].ResolvedFrom = selection.resolvedFrom[idleImpactedObjects[i].ResourceKey]
+			idleImpactedObjects[i].HelmRelease = selection.helmReleaseMembers[idleImpactedObjects[i].ResourceKey]
+		}
+		impactedObjects = append(impactedObjects, idleImpactedObjects...)
+		excludedObjects = append(append(excludedObjects, idleExcludedObjects...), scaleExcludedObjects...)
+	}
+	excludedObjects = uniqueExcludedObjects(excludedObjects)
+	var gitOpsOwners []pincherv1alpha1.SuspendedOwner
+	gitOpsOwners = append(gitOpsOwners, hibernator.Status.SuspendedOwners[suspendedCount:]...)
+	// the failures of the idle condition are recorded along with those of the field selectors of the selection
+	conditionErrors := getExpressionErrors(conditionFailures)
+	for _, expressionError := range conditionErrors {
+		r.log.Info("idle condition failed", "expression", expressionError.Expression, "objects", expressionError.Objects, "error", expressionError.Error)
+	}
+	hibernator.Status.ExpressionErrors = append(hibernator.Status.ExpressionErrors, conditionErrors...)
+
+	if len(impactedObjects) > 0 || len(gitOpsOwners) > 0 {
+		for i := range impactedObjects {
+			if len(impactedObjects[i].Message) == 0 {
+				impactedObjects[i].Message = "hibernated as idle for " + hibernator.Spec.Idle.Duration
+			}
+		}
+		history := pincherv1alpha1.RevisionHistory{
+			Time:            metav1.Time{Time: time.Now()},
+			ID:              r.historyUtil.getNewRevisionID(hibernator.Status.History),
+			Action:          pincherv1alpha1.Hibernate,
+			ImpactedObjects: impactedObjects,
+			ExcludedObjects: excludedObjects,
+			SuspendedOwners: gitOpsOwners,
+		}
+		hibernator.Status.History = r.historyUtil.addToHistory(history, hibernator.Status.History, false)
+	}
+	updated := len(impactedObjects) > 0 || len(gitOpsOwners) > 0 || idleHibernated != len(hibernator.Status.IdleHibernated) || !equalIdleSince(previousIdleSince, hibernator.Status.IdleSince)
+	return updated, excludedObjects
+}
+
+func (r *HibernatorActionImpl) delete(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) (*pincherv1alpha1.Hibernator, bool) {
 
 	reSync := hibernator.Spec.Action == hibernator.Status.Action
 
 	hibernator.Status.Action = pincherv1alpha1.Delete
 
-	impactedObjects, excludedObjects := r.executeRules(hibernator, r.resourceAction.DeleteAction, reSync)
+	impactedObjects, excludedObjects := r.executeRules(ctx, hibernator, r.resourceAction.DeleteActionFactory(ctx, hibernator), reSync)
 
 	if len(impactedObjects) > 0 {
 		history := pincherv1alpha1.RevisionHistory{
@@ -133,59 +237,266 @@ func (r *HibernatorActionImpl) delete(hibernator *pincherv1alpha1.Hibernator) (*
 		hibernator.Status.History = r.historyUtil.addToHistory(history, hibernator.Status.History, reSync)
 	}
 
+	countsUpdated := r.updateExcludedCounts(hibernator, excludedObjects)
+
 	r.log.Info("delete Operation - current run within range : next scheduled run at : Impacted Objects : excluded Objects", "impactedObjects", impactedObjects, "excludedObjects", excludedObjects)
-	return hibernator, len(impactedObjects) > 0
+	return hibernator, len(impactedObjects) > 0 || countsUpdated
 }
 
-func (r *HibernatorActionImpl) scale(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool) {
+func (r *HibernatorActionImpl) scale(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) (*pincherv1alpha1.Hibernator, bool) {
 
 	reSync := hibernator.Spec.Action == hibernator.Status.Action
 
 	hibernator.Status.Action = pincherv1alpha1.Scale
 
 	impactedObjects, excludedObjects := make([]pincherv1alpha1.ImpactedObject, 0), make([]pincherv1alpha1.ExcludedObject, 0)
+	var gitOpsOwners []pincherv1alpha1.SuspendedOwner
+	overridesCleared := false
 	if timeGap.WithinRange {
-		impactedObjects, excludedObjects = r.executeRules(hibernator, r.resourceAction.ScaleActionFactory(hibernator, timeGap), reSync)
+		impactedObjects, excludedObjects, gitOpsOwners = r.executeSuspendingOwners(ctx, hibernator, r.resourceAction.ScaleActionFactory(ctx, hibernator, timeGap), reSync)
 	} else {
-		impactedObjects, excludedObjects = r.executeRules(hibernator, r.resourceAction.ResetScaleActionFactory(hibernator), reSync)
+		impactedObjects, excludedObjects = r.executeRules(ctx, hibernator, r.resourceAction.ResetScaleActionFactory(ctx, hibernator), reSync)
+		gitOpsOwners = r.resourceAction.ResumeGitOpsOwners(ctx, hibernator)
+		overridesCleared = clearManualOverrides(hibernator)
 	}
 
-	if len(impactedObjects) > 0 {
+	if len(impactedObjects) > 0 || len(gitOpsOwners) > 0 {
 		history := pincherv1alpha1.RevisionHistory{
 			Time:            metav1.Time{Time: time.Now()},
 			ID:              r.historyUtil.getNewRevisionID(hibernator.Status.History),
 			Action:          pincherv1alpha1.Scale,
 			ImpactedObjects: impactedObjects,
 			ExcludedObjects: excludedObjects,
+			SuspendedOwners: gitOpsOwners,
 		}
 		hibernator.Status.History = r.historyUtil.addToHistory(history, hibernator.Status.History, reSync)
 	}
 
+	countsUpdated := r.updateExcludedCounts(hibernator, excludedObjects)
+
 	r.log.Info("Scale Operation - current run within range : next scheduled run at : Impacted Objects : excluded Objects", "withinRange", timeGap.WithinRange, "timeGap", timeGap.TimeGapInSeconds, "impactedObjects", impactedObjects, "excludedObjects", excludedObjects)
 
-	return hibernator, len(impactedObjects) > 0
+	return hibernator, len(impactedObjects) > 0 || len(gitOpsOwners) > 0 || countsUpdated || overridesCleared
 }
 
-func (r *HibernatorActionImpl) executeRules(hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
+// ruleSelection is the objects of a rule acted upon in a run, kept to act upon them again later in the run
+type ruleSelection struct {
+	ruleIndex int
+	included  []unstructured.Unstructured
+	// resolvedFrom is the resource key of the matched object keyed by resource key of the owner it was resolved to
+	resolvedFrom map[string]string
+	// helmReleaseMembers is the helm release of the objects selected through helm release selectors in the run
+	helmReleaseMembers map[string]string
+}
+
+// executeRules accumulates the outcome of all the rules. An object matched by more than one rule is acted upon only
+// by the first rule including it and is attributed to that rule through ImpactedObject.RuleIndex.
+func (r *HibernatorActionImpl) executeRules(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
+	impactedObjects, excludedObjects, _ := r.selectAndExecuteRules(ctx, hibernator, execute, reSync)
+	return impactedObjects, excludedObjects
+}
+
+// selectAndExecuteRules executes the rules as executeRules does and also returns the objects acted upon by each rule
+func (r *HibernatorActionImpl) selectAndExecuteRules(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject, []ruleSelection) {
 	//log := r.Log.WithValues("hibernator", r.getNamespacedName(hibernator))
 
 	impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
 	excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
+	selectionExcludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
+	executed, woken := make(map[string]bool), make(map[string]bool)
+	helmReleaseMembers := make(map[string]string)
+	var expressionFailures []expressionFailure
+	var selectionErrors []pincherv1alpha1.SelectionError
+	var selections []ruleSelection
+	failClosed := getExpressionErrorPolicy(hibernator) == pincherv1alpha1.FailClosed
+
+	for ruleIndex, rule := range hibernator.Spec.Selectors {
+		// the rules not yet executed when the reconcile is cancelled are left for the next run
+		if ctx.Err() != nil {
+			break
+		}
+		inclusions, resolvedFrom, inclusionFailures, inclusionErr := r.resourceSelector.getMatchingObjects(ctx, rule.Inclusions)
+		if inclusionErr != nil {
+			selectionErrors = append(selectionErrors, pincherv1alpha1.SelectionError{RuleIndex: ruleIndex, Error: inclusionErr.Error()})
+		}
+		addHelmReleaseMembers(helmReleaseMembers, rule.Inclusions, inclusions)
+		exclusions, _, exclusionFailures, exclusionErr := r.resourceSelector.getMatchingObjects(ctx, rule.Exclusions)
+		if exclusionErr != nil {
+			selectionErrors = append(selectionErrors, pincherv1alpha1.SelectionError{RuleIndex: ruleIndex, Exclusion: true, Error: exclusionErr.Error()})
+		}
+		included, excluded, keptAwake := r.resourceSelector.getIncludedExcludedObjects(ctx, inclusions, exclusions)
+		if failClosed {
+			included, excluded = excludeFailedObjects(included, excluded, exclusionFailures)
+			// the objects the failing exclusions were meant to protect are unknown, none of the rule is acted upon
+			if exclusionErr != nil {
+				included, excluded = excludeAllObjects(included, excluded, "exclusion failed: "+exclusionErr.Error())
+			}
+		}
+		expressionFailures = append(append(expressionFailures, inclusionFailures...), exclusionFailures...)
+		included = filterNotExecuted(included, executed)
+		keptAwake = filterNotExecuted(keptAwake, woken)
+
+		ruleImpactedObjects, ruleExcludedObjects := execute(included)
+		selections = append(selections, ruleSelection{ruleIndex: ruleIndex, included: included, resolvedFrom: resolvedFrom, helmReleaseMembers: helmReleaseMembers})
+		selectionExcludedObjects = append(selectionExcludedObjects, excluded...)
+
+		if len(keptAwake) > 0 && hibernator.Status.Action != pincherv1alpha1.Delete {
+			wokenObjects, _ := r.resourceAction.ResetScaleActionFactory(ctx, hibernator)(keptAwake)
+			for i := range wokenObjects {
+				wokenObjects[i].Message = "woken up as hibernation is opted out through annotation"
+			}
+			ruleImpactedObjects = append(ruleImpactedObjects, wokenObjects...)
+		}
 
-	for _, rule := range hibernator.Spec.Selectors {
-		inclusions := r.resourceSelector.getMatchingObjects(rule.Inclusions)
-		exclusions := r.resourceSelector.getMatchingObjects(rule.Exclusions)
-		included, excluded := r.resourceSelector.getIncludedExcludedObjects(inclusions, exclusions)
+		for i := range ruleImpactedObjects {
+			ruleImpactedObjects[i].RuleIndex = ruleIndex
+			ruleImpactedObjects[i].ResolvedFrom = resolvedFrom[ruleImpactedObjects[i].ResourceKey]
+		}
+		impactedObjects = append(impactedObjects, ruleImpactedObjects...)
+		excludedObjects = append(excludedObjects, ruleExcludedObjects...)
+	}
 
-		impactedObjects, excludedObjects = execute(included)
+	// objects excluded while selecting for one rule but acted upon by another rule are not reported as excluded
+	for _, excludedObject := range selectionExcludedObjects {
+		if !executed[excludedObject.ResourceKey] {
+			excludedObjects = append(excludedObjects, excludedObject)
+		}
+	}
+	excludedObjects = uniqueExcludedObjects(excludedObjects)
+	updateHelmReleases(hibernator, helmReleaseMembers, impactedObjects, excludedObjects)
+	hibernator.Status.ExpressionErrors = getExpressionErrors(expressionFailures)
+	for _, expressionError := range hibernator.Status.ExpressionErrors {
+		r.log.Info("field selector expression failed", "expression", expressionError.Expression, "objects", expressionError.Objects, "error", expressionError.Error)
+	}
+	hibernator.Status.SelectionErrors = selectionErrors
+	for _, selectionError := range selectionErrors {
+		r.log.Info("selector failed", "rule", selectionError.RuleIndex, "exclusion", selectionError.Exclusion, "error", selectionError.Error)
+	}
+	return impactedObjects, excludedObjects, selections
+}
 
-		for _, ex := range excluded {
-			excludedObject := pincherv1alpha1.ExcludedObject{
-				ResourceKey: getResourceKey(ex),
+// addHelmReleaseMembers maps the resource key of objects selected through helm release selectors to their release
+func addHelmReleaseMembers(members map[string]string, selectors []pincherv1alpha1.Selector, objects []unstructured.Unstructured) {
+	releases := make(map[string]bool)
+	for _, selector := range selectors {
+		for _, release := range strings.Split(selector.ObjectSelector.HelmRelease, ",") {
+			if release = strings.TrimSpace(release); len(release) > 0 {
+				releases[release] = true
 			}
-			excludedObjects = append(excludedObjects, excludedObject)
 		}
 	}
+	if len(releases) == 0 {
+		return
+	}
+	for _, object := range objects {
+		namespace, name, ok := getHelmRelease(object)
+		key := getResourceKey(object)
+		// releases are given either by name or as <namespace>/<name>
+		if !ok || !(releases[name] || releases[namespace+"/"+name]) || len(members[key]) > 0 {
+			continue
+		}
+		members[key] = namespace + "/" + name
+	}
+}
 
-	return impactedObjects, excludedObjects
+// updateHelmReleases attributes impacted objects to the helm release they were selected through and sets the
+// progress of each release, counting objects acted upon or already at target as done
+func updateHelmReleases(hibernator *pincherv1alpha1.Hibernator, members map[string]string, impactedObjects []pincherv1alpha1.ImpactedObject, excludedObjects []pincherv1alpha1.ExcludedObject) {
+	if len(members) == 0 {
+		hibernator.Status.HelmReleases = nil
+		return
+	}
+	progress := make(map[string]*pincherv1alpha1.HelmReleaseStatus)
+	var releases []string
+	for _, release := range members {
+		if progress[release] == nil {
+			progress[release] = &pincherv1alpha1.HelmReleaseStatus{Release: release}
+			releases = append(releases, release)
+		}
+		progress[release].Total++
+	}
+	excludedReasons := make(map[string]string)
+	for _, excludedObject := range excludedObjects {
+		excludedReasons[excludedObject.ResourceKey] = excludedObject.Reason
+		if release, ok := members[excludedObject.ResourceKey]; ok && excludedObject.Reason == pincherv1alpha1.AlreadyAtTarget {
+			progress[release].Done++
+		}
+	}
+	for i, impactedObject := range impactedObjects {
+		release, ok := members[impactedObject.ResourceKey]
+		if !ok {
+			continue
+		}
+		impactedObjects[i].HelmRelease = release
+		// objects woken up as they opted out are impacted as well as excluded
+		if impactedObject.Status == "success" && len(excludedReasons[impactedObject.ResourceKey]) == 0 {
+			progress[release].Done++
+		}
+	}
+
+	action := "scaled"
+	switch hibernator.Status.Action {
+	case pincherv1alpha1.Hibernate, pincherv1alpha1.Sleep:
+		action = "scaled down"
+	case pincherv1alpha1.UnHibernate:
+		action = "woken up"
+	case pincherv1alpha1.Delete:
+		action = "deleted"
+	}
+	sort.Strings(releases)
+	hibernator.Status.HelmReleases = make([]pincherv1alpha1.HelmReleaseStatus, 0, len(releases))
+	for _, release := range releases {
+		status := progress[release]
+		status.Message = fmt.Sprintf("release %s: %d/%d %s", release[strings.Index(release, "/")+1:], status.Done, status.Total, action)
+		hibernator.Status.HelmReleases = append(hibernator.Status.HelmReleases, *status)
+	}
+}
+
+// executeSuspendingOwners executes the rules and returns the GitOps owners suspended while executing them
+func (r *HibernatorActionImpl) executeSuspendingOwners(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, execute Execute, reSync bool) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject, []pincherv1alpha1.SuspendedOwner) {
+	suspendedCount := len(hibernator.Status.SuspendedOwners)
+	impactedObjects, excludedObjects := r.executeRules(ctx, hibernator, execute, reSync)
+	var suspendedOwners []pincherv1alpha1.SuspendedOwner
+	suspendedOwners = append(suspendedOwners, hibernator.Status.SuspendedOwners[suspendedCount:]...)
+	return impactedObjects, excludedObjects, suspendedOwners
+}
+
+// filterNotExecuted returns objects not already executed and marks them as executed
+func filterNotExecuted(objects []unstructured.Unstructured, executed map[string]bool) []unstructured.Unstructured {
+	var result []unstructured.Unstructured
+	for _, object := range objects {
+		key := getResourceKey(object)
+		if executed[key] {
+			continue
+		}
+		executed[key] = true
+		result = append(result, object)
+	}
+	return result
+}
+
+func uniqueExcludedObjects(excludedObjects []pincherv1alpha1.ExcludedObject) []pincherv1alpha1.ExcludedObject {
+	seen := make(map[string]bool)
+	result := make([]pincherv1alpha1.ExcludedObject, 0, len(excludedObjects))
+	for _, excludedObject := range excludedObjects {
+		if seen[excludedObject.ResourceKey] {
+			continue
+		}
+		seen[excludedObject.ResourceKey] = true
+		result = append(result, excludedObject)
+	}
+	return result
+}
+
+// updateExcludedCounts sets the number of excluded objects per reason in status and reports if they changed
+func (r *HibernatorActionImpl) updateExcludedCounts(hibernator *pincherv1alpha1.Hibernator, excludedObjects []pincherv1alpha1.ExcludedObject) bool {
+	excludedCounts := make(map[string]int)
+	for _, excludedObject := range excludedObjects {
+		excludedCounts[excludedObject.Reason]++
+	}
+	if reflect.DeepEqual(excludedCounts, hibernator.Status.ExcludedCounts) || (len(excludedCounts) == 0 && len(hibernator.Status.ExcludedCounts) == 0) {
+		return false
+	}
+	hibernator.Status.ExcludedCounts = excludedCounts
+	return true
 }
@@ -20,18 +20,33 @@ import (
 	"context"
 	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/go-logr/logr"
 	"github.com/tidwall/gjson"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"reflect"
 	"strings"
 	"testing"
 )
 
 func TestHibernatorActionImpl_unHibernate(t *testing.T) {
-	kubectl := pkg.NewKubectlMock(pkg.DeploymentObjectsMock)
+	// a deployment hibernated from 2 replicas, so that the run is recorded in the history along with its exclusions
+	hibernated := `{
+  "apiVersion": "apps/v1",
+  "kind": "Deployment",
+  "metadata": {
+    "name": "redis",
+    "namespace": "pras",
+    "labels": {"action": "delete"},
+    "annotations": {"hibernator.devtron.ai/replicas": "2"}
+  },
+  "spec": {"replicas": 0}
+}`
+	kubectl := pkg.NewKubectlMock(strings.TrimSuffix(strings.TrimSpace(pkg.DeploymentObjectsMock), "]") + "," + hibernated + "]")
 
 	type Response struct {
 		impactedObjects []pincherv1alpha1.ImpactedObject
 		excludedObjects []pincherv1alpha1.ExcludedObject
+		excludedCounts  map[string]int
 	}
 
 	type fields struct {
@@ -52,7 +67,7 @@ func TestHibernatorActionImpl_unHibernate(t *testing.T) {
 	}{
 		{
 			name: "base case",
-			args: args{hibernator: &pkg.HibernateTest},
+			args: args{hibernator: pkg.HibernateTest.DeepCopy()},
 			fields: fields{
 				Kubectl:     kubectl,
 				historyUtil: &HistoryImpl{},
@@ -66,13 +81,18 @@ func TestHibernatorActionImpl_unHibernate(t *testing.T) {
 					factory: pkg.NewMockFactory,
 				},
 			},
-			want1: false,
+			want1: true,
 			want: Response{
 				impactedObjects: []pincherv1alpha1.ImpactedObject{
-					{ResourceKey: "/pras/apps/v1/Deployment/rss-site", OriginalCount: 2, Status: "success"},
+					{ResourceKey: "/pras/apps/v1/Deployment/redis", OriginalCount: 2, Status: "success"},
 				},
 				excludedObjects: []pincherv1alpha1.ExcludedObject{
-					{ResourceKey: "/pras/extensions/v1beta1/Deployment/nginx-deployment", Reason: "error determining original count"},
+					{ResourceKey: "/pras/apps/v1/Deployment/rss-site", Reason: pincherv1alpha1.ExcludedByRule},
+					{ResourceKey: "/pras/extensions/v1beta1/Deployment/nginx-deployment", Reason: pincherv1alpha1.MissingOriginalCount},
+				},
+				excludedCounts: map[string]int{
+					pincherv1alpha1.ExcludedByRule:       1,
+					pincherv1alpha1.MissingOriginalCount: 1,
 				},
 			},
 		},
@@ -84,39 +104,40 @@ func TestHibernatorActionImpl_unHibernate(t *testing.T) {
 				historyUtil:      tt.fields.historyUtil,
 				resourceAction:   tt.fields.resourceAction,
 				resourceSelector: tt.fields.resourceSelector,
+				log:              logr.Discard(),
 			}
-			got, got1 := r.unHibernate(tt.args.hibernator)
+			got, got1 := r.unHibernate(context.Background(), tt.args.hibernator)
 			if got1 != tt.want1 {
 				t.Errorf("hibernate() got = %t, want %t", got1, tt.want1)
 			}
-			if tt.want1 == false {
-				return
+			if !reflect.DeepEqual(got.Status.ExcludedCounts, tt.want.excludedCounts) {
+				t.Errorf("unHibernate() excludedCounts = %v, want %v", got.Status.ExcludedCounts, tt.want.excludedCounts)
 			}
-			if got.Status.History != nil {
-				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
+			if len(got.Status.History) == 0 {
+				t.Fatalf("unHibernate() no history recorded, want %v", tt.want)
 			}
 			if len(got.Status.History[0].ImpactedObjects) != len(tt.want.impactedObjects) {
-				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
+				t.Errorf("unHibernate() impactedObjects = %v, want %v", got.Status.History[0].ImpactedObjects, tt.want.impactedObjects)
 			}
 			if len(got.Status.History[0].ExcludedObjects) != len(tt.want.excludedObjects) {
-				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
+				t.Errorf("unHibernate() excludedObjects = %v, want %v", got.Status.History[0].ExcludedObjects, tt.want.excludedObjects)
 			}
 			impactedObjects := make(map[string]int, 0)
 			for _, object := range tt.want.impactedObjects {
 				impactedObjects[object.ResourceKey] = object.OriginalCount
 			}
-			excludedObjects := make(map[string]bool, 0)
+			excludedObjects := make(map[string]string, 0)
 			for _, object := range tt.want.excludedObjects {
-				excludedObjects[object.ResourceKey] = true
+				excludedObjects[object.ResourceKey] = object.Reason
 			}
 			for _, object := range got.Status.History[0].ImpactedObjects {
 				if v, ok := impactedObjects[object.ResourceKey]; !ok || v != object.OriginalCount {
-					t.Errorf("hibernate() got = %v, want %v", got, tt.want)
+					t.Errorf("unHibernate() unexpected impacted object %s with count %d", object.ResourceKey, object.OriginalCount)
 				}
 			}
 			for _, object := range got.Status.History[0].ExcludedObjects {
-				if ok := excludedObjects[object.ResourceKey]; !ok {
-					t.Errorf("hibernate() got = %v, want %v", got, tt.want)
+				if reason, ok := excludedObjects[object.ResourceKey]; !ok || reason != object.Reason {
+					t.Errorf("unHibernate() excluded object %s with reason %s, want %q", object.ResourceKey, object.Reason, reason)
 				}
 			}
 			for _, object := range got.Status.History[0].ImpactedObjects {
@@ -134,13 +155,13 @@ func TestHibernatorActionImpl_unHibernate(t *testing.T) {
 					t.Errorf("hibernate() error fetching to check %s", object.ResourceKey)
 				}
 				j, _ := o.Manifest.MarshalJSON()
-				replicaCount := gjson.Get(string(j), "specs.replicas")
-				if replicaCount.Int() == 0 {
-					t.Errorf("hibernate() replica count 0 for %s", object.ResourceKey)
+				replicaCount := gjson.Get(string(j), "spec.replicas")
+				if int(replicaCount.Int()) != impactedObjects[object.ResourceKey] {
+					t.Errorf("unHibernate() replica count %d for %s, want %d", replicaCount.Int(), object.ResourceKey, impactedObjects[object.ResourceKey])
 				}
 				ann := o.Manifest.GetAnnotations()
-				if ann["hibernator.devtron.ai/replicas"] != "0" {
-					t.Errorf("hibernate() annotations not set to 0 for %s", object.ResourceKey)
+				if _, ok := ann["hibernator.devtron.ai/replicas"]; ok {
+					t.Errorf("unHibernate() annotation not removed for %s", object.ResourceKey)
 				}
 			}
 			if got1 != tt.want1 {
@@ -178,7 +199,7 @@ func TestHibernatorActionImpl_hibernate(t *testing.T) {
 		{
 			name: "base case",
 			args: args{
-				hibernator: &pkg.HibernateTest,
+				hibernator: pkg.HibernateTest.DeepCopy(),
 				timeGap:    pincherv1alpha1.NearestTimeGap{WithinRange: true},
 			},
 			fields: fields{
@@ -211,8 +232,9 @@ func TestHibernatorActionImpl_hibernate(t *testing.T) {
 				historyUtil:      tt.fields.historyUtil,
 				resourceAction:   tt.fields.resourceAction,
 				resourceSelector: tt.fields.resourceSelector,
+				log:              logr.Discard(),
 			}
-			got, got1 := r.hibernate(tt.args.hibernator, tt.args.timeGap)
+			got, got1 := r.hibernate(context.Background(), tt.args.hibernator, tt.args.timeGap)
 			if len(got.Status.History) != 1 {
 				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
 			}
@@ -300,7 +322,7 @@ func TestHibernatorActionImpl_delete(t *testing.T) {
 	}{
 		{
 			name: "base case",
-			args: args{hibernator: &pkg.HibernateTest},
+			args: args{hibernator: pkg.HibernateTest.DeepCopy()},
 			fields: fields{
 				Kubectl:     kubectl,
 				historyUtil: &HistoryImpl{},
@@ -331,8 +353,9 @@ func TestHibernatorActionImpl_delete(t *testing.T) {
 				historyUtil:      tt.fields.historyUtil,
 				resourceAction:   tt.fields.resourceAction,
 				resourceSelector: tt.fields.resourceSelector,
+				log:              logr.Discard(),
 			}
-			got, got1 := r.delete(tt.args.hibernator)
+			got, got1 := r.delete(context.Background(), tt.args.hibernator)
 			if len(got.Status.History) != 1 {
 				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
 			}
@@ -384,3 +407,269 @@ func TestHibernatorActionImpl_delete(t *testing.T) {
 		})
 	}
 }
+
+func TestHibernatorActionImpl_executeRules(t *testing.T) {
+	byLabel := pincherv1alpha1.Selector{
+		ObjectSelector:    pincherv1alpha1.ObjectSelector{Labels: []string{"action=delete"}, Type: "deployment"},
+		NamespaceSelector: pincherv1alpha1.NamespaceSelector{Name: "pras"},
+	}
+	byName := func(name string) pincherv1alpha1.Selector {
+		return pincherv1alpha1.Selector{
+			ObjectSelector:    pincherv1alpha1.ObjectSelector{Name: name, Type: "deployment"},
+			NamespaceSelector: pincherv1alpha1.NamespaceSelector{Name: "pras"},
+		}
+	}
+	failing := byName("")
+	failing.ObjectSelector.FieldSelector = []pincherv1alpha1.FieldExpression{{Expression: "{{spec.replicas}} > {{metadata.name}}"}}
+	brokenCel := byName("")
+	brokenCel.ObjectSelector.FieldSelector = []pincherv1alpha1.FieldExpression{{Expression: "self.spec.replicas > ", Language: pincherv1alpha1.CELLanguage}}
+	invalid := byName("/nginx-(/")
+	tests := []struct {
+		name                 string
+		rules                []pincherv1alpha1.Rule
+		policy               pincherv1alpha1.ExpressionErrorPolicy
+		cancelled            bool
+		impactedPerRule      map[int]int
+		excluded             map[string]string
+		wantExpressionErrors int
+		wantSelectionErrors  []pincherv1alpha1.SelectionError
+	}{
+		{
+			name: "overlapping rules",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byLabel}},
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx,nginx-deployment")}},
+			},
+			impactedPerRule: map[int]int{0: 1, 1: 1},
+			excluded: map[string]string{
+				"/pras/apps/v1/Deployment/rss-site": pincherv1alpha1.AlreadyAtTarget,
+			},
+		},
+		{
+			name: "excluded by one rule and included by another",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{byName("nginx")}},
+				{Inclusions: []pincherv1alpha1.Selector{byName("rss-site")}},
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}},
+			},
+			impactedPerRule: map[int]int{2: 1},
+			excluded: map[string]string{
+				"/pras/apps/v1/Deployment/rss-site": pincherv1alpha1.AlreadyAtTarget,
+			},
+		},
+		{
+			name: "cancelled before executing the rules",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byLabel}},
+			},
+			cancelled:       true,
+			impactedPerRule: map[int]int{},
+			excluded:        map[string]string{},
+		},
+		{
+			name: "failing exclusion ignored",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{failing}},
+			},
+			policy:               pincherv1alpha1.IgnoreExpressionErrors,
+			impactedPerRule:      map[int]int{0: 1},
+			excluded:             map[string]string{},
+			wantExpressionErrors: 1,
+		},
+		{
+			name: "failing exclusion failing closed",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{failing}},
+			},
+			policy:          pincherv1alpha1.FailClosed,
+			impactedPerRule: map[int]int{},
+			excluded: map[string]string{
+				"/pras/apps/v1/Deployment/nginx": pincherv1alpha1.ExclusionError,
+			},
+			wantExpressionErrors: 1,
+		},
+		{
+			name: "failing inclusion",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{failing}},
+			},
+			policy:               pincherv1alpha1.FailClosed,
+			impactedPerRule:      map[int]int{},
+			excluded:             map[string]string{},
+			wantExpressionErrors: 1,
+		},
+		{
+			name: "invalid exclusion ignored",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{byName("rss-site"), invalid}},
+			},
+			policy:              pincherv1alpha1.IgnoreExpressionErrors,
+			impactedPerRule:     map[int]int{0: 1},
+			excluded:            map[string]string{},
+			wantSelectionErrors: []pincherv1alpha1.SelectionError{{RuleIndex: 0, Exclusion: true}},
+		},
+		{
+			name: "invalid exclusion failing closed",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("rss-site")}},
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{invalid}},
+			},
+			policy:          pincherv1alpha1.FailClosed,
+			impactedPerRule: map[int]int{},
+			excluded: map[string]string{
+				"/pras/apps/v1/Deployment/rss-site": pincherv1alpha1.AlreadyAtTarget,
+				"/pras/apps/v1/Deployment/nginx":    pincherv1alpha1.ExclusionError,
+			},
+			wantSelectionErrors: []pincherv1alpha1.SelectionError{{RuleIndex: 1, Exclusion: true}},
+		},
+		{
+			name: "exclusion with a CEL expression which can't compile failing closed",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{byName("nginx")}, Exclusions: []pincherv1alpha1.Selector{brokenCel}},
+			},
+			policy:          pincherv1alpha1.FailClosed,
+			impactedPerRule: map[int]int{},
+			excluded: map[string]string{
+				"/pras/apps/v1/Deployment/nginx": pincherv1alpha1.ExclusionError,
+			},
+			wantSelectionErrors: []pincherv1alpha1.SelectionError{{RuleIndex: 0, Exclusion: true}},
+		},
+		{
+			name: "inclusion with a CEL expression which can't compile matching no object",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{brokenCel}},
+			},
+			policy:              pincherv1alpha1.IgnoreExpressionErrors,
+			impactedPerRule:     map[int]int{},
+			excluded:            map[string]string{},
+			wantSelectionErrors: []pincherv1alpha1.SelectionError{{RuleIndex: 0}},
+		},
+		{
+			name: "invalid inclusion",
+			rules: []pincherv1alpha1.Rule{
+				{Inclusions: []pincherv1alpha1.Selector{invalid, byName("nginx")}},
+			},
+			policy:              pincherv1alpha1.FailClosed,
+			impactedPerRule:     map[int]int{0: 1},
+			excluded:            map[string]string{},
+			wantSelectionErrors: []pincherv1alpha1.SelectionError{{RuleIndex: 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(pkg.DeploymentObjectsMock)
+			r := &HibernatorActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+				resourceAction: &ResourceActionImpl{
+					Kubectl:     kubectl,
+					historyUtil: &HistoryImpl{},
+				},
+				resourceSelector: &ResourceSelectorImpl{
+					Kubectl: kubectl,
+					Mapper:  pkg.NewMockMapperFactory(),
+					factory: pkg.NewMockFactory,
+				},
+				log: logr.Discard(),
+			}
+			hibernator := &pincherv1alpha1.Hibernator{
+				Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate, Selectors: tt.rules, ExpressionErrorPolicy: tt.policy},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancelled {
+				cancel()
+			}
+			defer cancel()
+			execute := r.resourceAction.ScaleActionFactory(ctx, hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})
+			impactedObjects, excludedObjects := r.executeRules(ctx, hibernator, execute, false)
+			impactedPerRule := make(map[int]int)
+			seen := make(map[string]bool)
+			for _, object := range impactedObjects {
+				if seen[object.ResourceKey] {
+					t.Errorf("executeRules() duplicate impacted object %s", object.ResourceKey)
+				}
+				seen[object.ResourceKey] = true
+				impactedPerRule[object.RuleIndex]++
+			}
+			if !reflect.DeepEqual(impactedPerRule, tt.impactedPerRule) {
+				t.Errorf("executeRules() impacted per rule = %v, want %v", impactedPerRule, tt.impactedPerRule)
+			}
+			excluded := make(map[string]string)
+			for _, object := range excludedObjects {
+				excluded[object.ResourceKey] = object.Reason
+			}
+			if !reflect.DeepEqual(excluded, tt.excluded) {
+				t.Errorf("executeRules() excluded = %v, want %v", excluded, tt.excluded)
+			}
+			if len(hibernator.Status.ExpressionErrors) != tt.wantExpressionErrors {
+				t.Errorf("executeRules() expressionErrors = %v, want %d", hibernator.Status.ExpressionErrors, tt.wantExpressionErrors)
+			}
+			var selectionErrors []pincherv1alpha1.SelectionError
+			for _, selectionError := range hibernator.Status.SelectionErrors {
+				if len(selectionError.Error) == 0 {
+					t.Errorf("executeRules() selection error of rule %d without message", selectionError.RuleIndex)
+				}
+				selectionErrors = append(selectionErrors, pincherv1alpha1.SelectionError{RuleIndex: selectionError.RuleIndex, Exclusion: selectionError.Exclusion})
+			}
+			if !reflect.DeepEqual(selectionErrors, tt.wantSelectionErrors) {
+				t.Errorf("executeRules() selectionErrors = %v, want %v", hibernator.Status.SelectionErrors, tt.wantSelectionErrors)
+			}
+		})
+	}
+}
+
+func TestHibernatorActionImpl_executeRulesHelmRelease(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(helm_release_objects_mock)
+	r := &HibernatorActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+		resourceAction: &ResourceActionImpl{
+			Kubectl:     kubectl,
+			historyUtil: &HistoryImpl{},
+		},
+		resourceSelector: &ResourceSelectorImpl{
+			Kubectl: kubectl,
+			Mapper:  pkg.NewMockMapperFactory(),
+			factory: pkg.NewMockFactory,
+		},
+		log: logr.Discard(),
+	}
+	hibernator := &pincherv1alpha1.Hibernator{
+		Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate, Selectors: []pincherv1alpha1.Rule{{
+			Inclusions: []pincherv1alpha1.Selector{{
+				ObjectSelector:    pincherv1alpha1.ObjectSelector{HelmRelease: "foo"},
+				NamespaceSelector: pincherv1alpha1.NamespaceSelector{Name: "shop"},
+			}},
+		}}},
+		Status: pincherv1alpha1.HibernatorStatus{Action: pincherv1alpha1.Hibernate},
+	}
+	execute := r.resourceAction.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})
+	impactedObjects, excludedObjects := r.executeRules(context.Background(), hibernator, execute, false)
+
+	impacted := make(map[string]string)
+	for _, object := range impactedObjects {
+		impacted[object.ResourceKey] = object.HelmRelease
+	}
+	wantImpacted := map[string]string{
+		"/shop/apps/v1/Deployment/web": "shop/foo",
+		"/shop/apps/v1/Deployment/api": "shop/foo",
+	}
+	if !reflect.DeepEqual(impacted, wantImpacted) {
+		t.Errorf("executeRules() impacted = %v, want %v", impacted, wantImpacted)
+	}
+	excluded := make(map[string]string)
+	for _, object := range excludedObjects {
+		excluded[object.ResourceKey] = object.Reason
+	}
+	wantExcluded := map[string]string{
+		"/shop/apps/v1/Deployment/cache":  pincherv1alpha1.AlreadyAtTarget,
+		"/shop/apps/v1/Deployment/worker": pincherv1alpha1.OptedOut,
+	}
+	if !reflect.DeepEqual(excluded, wantExcluded) {
+		t.Errorf("executeRules() excluded = %v, want %v", excluded, wantExcluded)
+	}
+	wantReleases := []pincherv1alpha1.HelmReleaseStatus{{Release: "shop/foo", Total: 4, Done: 3, Message: "release foo: 3/4 scaled down"}}
+	if !reflect.DeepEqual(hibernator.Status.HelmReleases, wantReleases) {
+		t.Errorf("executeRules() helmReleases = %v, want %v", hibernator.Status.HelmReleases, wantReleases)
+	}
+}
@@ -38,3 +38,694 @@ const hibernator_mock_1 = `
         }
       }
 `
+
+const keep_awake_objects_mock = `
+[
+  {
+    "apiVersion": "v1",
+    "kind": "Namespace",
+    "metadata": {
+      "name": "qa",
+      "annotations": {
+        "hibernator.devtron.ai/keep-awake-until": "2999-01-01T00:00:00Z"
+      }
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Namespace",
+    "metadata": {
+      "name": "dev"
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "late-night",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/keep-awake-until": "2999-01-01T00:00:00Z"
+      }
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "expired",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/keep-awake-until": "2000-01-01T00:00:00Z"
+      }
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "plain",
+      "namespace": "dev"
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "qa-app",
+      "namespace": "qa"
+    },
+    "spec": {
+      "replicas": 2
+    }
+  }
+]`
+
+const annotation_override_objects_mock = `
+[
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "opted-out",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/exclude": "true"
+      }
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "keep-one",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/target-replicas": "1"
+      }
+    },
+    "spec": {
+      "replicas": 3
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "frontend",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/replicas": "2"
+      }
+    },
+    "spec": {
+      "replicas": 0
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "database",
+      "namespace": "dev",
+      "annotations": {
+        "hibernator.devtron.ai/replicas": "1",
+        "hibernator.devtron.ai/wake-priority": "10"
+      }
+    },
+    "spec": {
+      "replicas": 0
+    }
+  }
+]`
+
+const gitops_objects_mock = `
+[
+  {
+    "apiVersion": "argoproj.io/v1alpha1",
+    "kind": "Application",
+    "metadata": {
+      "name": "payments",
+      "namespace": "argocd"
+    },
+    "spec": {
+      "destination": {
+        "namespace": "dev"
+      },
+      "syncPolicy": {
+        "automated": {
+          "prune": true,
+          "selfHeal": true
+        }
+      }
+    }
+  },
+  {
+    "apiVersion": "kustomize.toolkit.fluxcd.io/v1",
+    "kind": "Kustomization",
+    "metadata": {
+      "name": "apps",
+      "namespace": "flux-system"
+    },
+    "spec": {
+      "interval": "10m"
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "payments-api",
+      "namespace": "dev",
+      "annotations": {
+        "argocd.argoproj.io/tracking-id": "payments:apps/Deployment:dev/payments-api"
+      }
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "payments-worker",
+      "namespace": "dev",
+      "labels": {
+        "app.kubernetes.io/instance": "payments"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "catalog",
+      "namespace": "dev",
+      "labels": {
+        "kustomize.toolkit.fluxcd.io/name": "apps",
+        "kustomize.toolkit.fluxcd.io/namespace": "flux-system"
+      }
+    },
+    "spec": {
+      "replicas": 3
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "payments-worker",
+      "namespace": "qa",
+      "labels": {
+        "app.kubernetes.io/instance": "payments"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "helm-chart",
+      "namespace": "dev",
+      "labels": {
+        "app.kubernetes.io/instance": "helm-chart"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  }
+]`
+
+const helm_release_objects_mock = `
+[
+  {
+    "apiVersion": "v1",
+    "kind": "Secret",
+    "metadata": {
+      "name": "sh.helm.release.v1.foo.v3",
+      "namespace": "shop",
+      "labels": {
+        "name": "foo",
+        "owner": "helm",
+        "status": "deployed",
+        "version": "3"
+      }
+    },
+    "type": "helm.sh/release.v1"
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Secret",
+    "metadata": {
+      "name": "sh.helm.release.v1.foo.v1",
+      "namespace": "analytics",
+      "labels": {
+        "name": "foo",
+        "owner": "helm",
+        "status": "deployed",
+        "version": "1"
+      }
+    },
+    "type": "helm.sh/release.v1"
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "reporting",
+      "namespace": "shop",
+      "annotations": {
+        "meta.helm.sh/release-name": "foo",
+        "meta.helm.sh/release-namespace": "analytics"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "web",
+      "namespace": "shop",
+      "annotations": {
+        "meta.helm.sh/release-name": "foo",
+        "meta.helm.sh/release-namespace": "shop"
+      }
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "api",
+      "namespace": "shop",
+      "labels": {
+        "app.kubernetes.io/instance": "foo"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "cache",
+      "namespace": "shop",
+      "annotations": {
+        "meta.helm.sh/release-name": "foo",
+        "meta.helm.sh/release-namespace": "shop"
+      }
+    },
+    "spec": {
+      "replicas": 0
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "worker",
+      "namespace": "shop",
+      "annotations": {
+        "meta.helm.sh/release-name": "foo",
+        "meta.helm.sh/release-namespace": "shop",
+        "hibernator.devtron.ai/exclude": "true"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "other",
+      "namespace": "shop",
+      "annotations": {
+        "meta.helm.sh/release-name": "bar",
+        "meta.helm.sh/release-namespace": "shop"
+      }
+    },
+    "spec": {
+      "replicas": 1
+    }
+  }
+]`
+
+const owner_reference_objects_mock = `
+[
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "web",
+      "namespace": "apps",
+      "uid": "d-1"
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "ReplicaSet",
+    "metadata": {
+      "name": "web-5d8f",
+      "namespace": "apps",
+      "uid": "rs-1",
+      "ownerReferences": [
+        {"apiVersion": "apps/v1", "kind": "Deployment", "name": "web", "uid": "d-1", "controller": true}
+      ]
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Pod",
+    "metadata": {
+      "name": "web-5d8f-abc",
+      "namespace": "apps",
+      "labels": {
+        "app": "web"
+      },
+      "ownerReferences": [
+        {"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-5d8f", "uid": "rs-1", "controller": true}
+      ]
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Pod",
+    "metadata": {
+      "name": "web-5d8f-def",
+      "namespace": "apps",
+      "labels": {
+        "app": "web"
+      },
+      "ownerReferences": [
+        {"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-5d8f", "uid": "rs-1", "controller": true}
+      ]
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Pod",
+    "metadata": {
+      "name": "debug",
+      "namespace": "apps",
+      "labels": {
+        "app": "web"
+      }
+    }
+  }
+]`
+
+const autoscaler_objects_mock = `
+[
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "api",
+      "namespace": "hpa"
+    },
+    "spec": {
+      "replicas": 3
+    }
+  },
+  {
+    "apiVersion": "autoscaling/v2",
+    "kind": "HorizontalPodAutoscaler",
+    "metadata": {
+      "name": "api",
+      "namespace": "hpa"
+    },
+    "spec": {
+      "minReplicas": 2,
+      "maxReplicas": 5,
+      "scaleTargetRef": {
+        "apiVersion": "apps/v1",
+        "kind": "Deployment",
+        "name": "api"
+      }
+    }
+  }
+]`
+
+const keda_objects_mock = `
+[
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "worker",
+      "namespace": "keda"
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "keda.sh/v1alpha1",
+    "kind": "ScaledObject",
+    "metadata": {
+      "name": "worker",
+      "namespace": "keda"
+    },
+    "spec": {
+      "minReplicaCount": 1,
+      "maxReplicaCount": 10,
+      "scaleTargetRef": {
+        "name": "worker"
+      }
+    }
+  },
+  {
+    "apiVersion": "autoscaling/v2",
+    "kind": "HorizontalPodAutoscaler",
+    "metadata": {
+      "name": "keda-hpa-worker",
+      "namespace": "keda"
+    },
+    "spec": {
+      "minReplicas": 1,
+      "maxReplicas": 10,
+      "scaleTargetRef": {
+        "apiVersion": "apps/v1",
+        "kind": "Deployment",
+        "name": "worker"
+      }
+    }
+  },
+  {
+    "apiVersion": "autoscaling.k8s.io/v1",
+    "kind": "VerticalPodAutoscaler",
+    "metadata": {
+      "name": "worker",
+      "namespace": "keda"
+    },
+    "spec": {
+      "targetRef": {
+        "apiVersion": "apps/v1",
+        "kind": "Deployment",
+        "name": "worker"
+      }
+    }
+  }
+]`
+
+const lockdown_objects_mock = `
+[
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "api",
+      "namespace": "dev"
+    },
+    "spec": {
+      "replicas": 2
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "worker",
+      "namespace": "dev"
+    },
+    "spec": {
+      "replicas": 1
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "gateway",
+      "namespace": "edge",
+      "annotations": {
+        "hibernator.devtron.ai/target-replicas": "1"
+      }
+    },
+    "spec": {
+      "replicas": 3
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "ResourceQuota",
+    "metadata": {
+      "name": "compute",
+      "namespace": "dev"
+    },
+    "spec": {
+      "hard": {
+        "pods": "20"
+      }
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "ResourceQuota",
+    "metadata": {
+      "name": "hibernator-lockdown-nightly",
+      "namespace": "qa"
+    },
+    "spec": {
+      "hard": {
+        "pods": "10"
+      }
+    }
+  }
+]`
+
+const label_selector_objects_mock = `
+[
+  {
+    "apiVersion": "v1",
+    "kind": "Namespace",
+    "metadata": {
+      "name": "dev",
+      "labels": {"env": "dev", "team": "payments"},
+      "annotations": {"owner": "platform"}
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Namespace",
+    "metadata": {
+      "name": "qa",
+      "labels": {"env": "qa"}
+    }
+  },
+  {
+    "apiVersion": "v1",
+    "kind": "Namespace",
+    "metadata": {
+      "name": "prod",
+      "labels": {"env": "prod", "team": "payments"}
+    }
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "web",
+      "namespace": "dev",
+      "labels": {"app": "web", "tier": "frontend"},
+      "annotations": {"hibernate": "true"}
+    },
+    "spec": {"replicas": 2}
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "api",
+      "namespace": "dev",
+      "labels": {"app": "api", "tier": "backend"}
+    },
+    "spec": {"replicas": 2}
+  },
+  {
+    "apiVersion": "apps/v1",
+    "kind": "Deployment",
+    "metadata": {
+      "name": "web",
+      "namespace": "qa",
+      "labels": {"app": "web", "tier": "frontend"}
+    },
+    "spec": {"replicas": 1}
+  }
+]
+`
+
+const name_pattern_objects_mock = `
+[
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "qa-1"}},
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "qa-2", "labels": {"keep": "true"}}},
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "pr-1234-api"}},
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "feature-login-preview"}},
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "prod"}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web-v1", "namespace": "qa-1"}, "spec": {"replicas": 1}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web-v2", "namespace": "qa-1"}, "spec": {"replicas": 1}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api", "namespace": "qa-1"}, "spec": {"replicas": 1}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web-v1", "namespace": "qa-2"}, "spec": {"replicas": 1}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web-v1", "namespace": "prod"}, "spec": {"replicas": 1}}
+]
+`
+
+const cluster_scoped_objects_mock = `
+[
+  {"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv-released", "labels": {"team": "dev"}}, "status": {"phase": "Released"}},
+  {"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv-bound", "labels": {"team": "dev"}}, "status": {"phase": "Bound"}},
+  {"apiVersion": "v1", "kind": "PersistentVolume", "metadata": {"name": "pv-other", "labels": {"team": "qa"}}, "status": {"phase": "Released"}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev", "labels": {"team": "dev"}}, "spec": {"replicas": 1}}
+]
+`
+
+const related_objects_mock = `
+[
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "dev", "labels": {"env": "dev"}}},
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "qa", "labels": {"env": "qa"}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev"}, "spec": {"replicas": 1}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "qa"}, "spec": {"replicas": 1}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data", "namespace": "storage"}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "orphan", "namespace": "storage"}},
+  {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "db-0", "namespace": "storage"}, "spec": {"volumes": [{"name": "data", "persistentVolumeClaim": {"claimName": "data"}}]}},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "web", "namespace": "network"}},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "idle", "namespace": "network"}},
+  {"apiVersion": "v1", "kind": "Endpoints", "metadata": {"name": "web", "namespace": "network"}, "subsets": [{"addresses": [{"ip": "10.0.0.1"}]}]}
+]
+`
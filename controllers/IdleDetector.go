@@ -0,0 +1,198 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"time"
+)
+
+const defaultIdleWindow = 5 * time.Minute
+
+type IdleDetector interface {
+	getIdleObjects(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, included []unstructured.Unstructured) ([]unstructured.Unstructured, []pincherv1alpha1.ExcludedObject, []expressionFailure)
+}
+
+func NewIdleDetectorImpl(kubectl pkg.KubectlCmd, client *http.Client) IdleDetector {
+	return &IdleDetectorImpl{
+		Kubectl: kubectl,
+		client:  client,
+	}
+}
+
+type IdleDetectorImpl struct {
+	Kubectl pkg.KubectlCmd
+	client  *http.Client
+}
+
+// getIdleObjects returns the running objects whose metrics met the idle condition for the idle duration, keeping
+// track of the time from which each object has been idle in the status of the hibernator. Objects already
+// hibernated for being idle are left out. Objects for which the idle condition fails are excluded and returned along
+// with their failures.
+func (r *IdleDetectorImpl) getIdleObjects(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, included []unstructured.Unstructured) ([]unstructured.Unstructured, []pincherv1alpha1.ExcludedObject, []expressionFailure) {
+	excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
+	idle := hibernator.Spec.Idle
+	duration, err := pkg.ParseDuration(idle.Duration)
+	if err == nil && duration < 0 {
+		err = fmt.Errorf("%s is negative", idle.Duration)
+	}
+	if err != nil {
+		for _, inc := range included {
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, "invalid idle duration: "+err.Error()))
+		}
+		return nil, excludedObjects, nil
+	}
+	source, err := r.getMetricsSource(idle)
+	if err != nil {
+		for _, inc := range included {
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, err.Error()))
+		}
+		return nil, excludedObjects, nil
+	}
+
+	now := time.Now()
+	var idleObjects []unstructured.Unstructured
+	var failures []expressionFailure
+	for _, inc := range included {
+		resourceKey := getResourceKey(inc)
+		to, err := inc.MarshalJSON()
+		if err != nil {
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, err.Error()))
+			continue
+		}
+		replicaCount := gjson.Get(string(to), replicaField(inc))
+		if _, ok := hibernator.Status.IdleHibernated[resourceKey]; ok {
+			// an object woken up outside of the hibernator is woken up for good on the next run
+			if replicaCount.Int() != int64(hibernator.Status.ScaledReplicas[resourceKey]) {
+				delete(hibernator.Status.IdleHibernated, resourceKey)
+			}
+			continue
+		}
+		// objects which are not running have no usage to go by
+		if !replicaCount.Exists() || replicaCount.Int() == 0 {
+			delete(hibernator.Status.IdleSince, resourceKey)
+			continue
+		}
+		metrics, err := source.GetMetrics(ctx, inc)
+		if err != nil {
+			delete(hibernator.Status.IdleSince, resourceKey)
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, "failed to get metrics: "+err.Error()))
+			continue
+		}
+		document, err := json.Marshal(metrics)
+		if err != nil {
+			delete(hibernator.Status.IdleSince, resourceKey)
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, err.Error()))
+			continue
+		}
+		matched, err := pkg.EvaluateExpression(idle.Condition, string(document))
+		if err != nil {
+			delete(hibernator.Status.IdleSince, resourceKey)
+			excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, "idle condition failed: "+err.Error()))
+			failures = append(failures, expressionFailure{object: inc, err: err})
+			continue
+		}
+		if !matched {
+			delete(hibernator.Status.IdleSince, resourceKey)
+			continue
+		}
+		since, ok := hibernator.Status.IdleSince[resourceKey]
+		if !ok {
+			since = metav1.Time{Time: now}
+			if hibernator.Status.IdleSince == nil {
+				hibernator.Status.IdleSince = make(map[string]metav1.Time)
+			}
+			hibernator.Status.IdleSince[resourceKey] = since
+		}
+		if now.Sub(since.Time) >= duration {
+			idleObjects = append(idleObjects, inc)
+		}
+	}
+	return idleObjects, excludedObjects, failures
+}
+
+func (r *IdleDetectorImpl) getMetricsSource(idle *pincherv1alpha1.Idle) (pkg.MetricsSource, error) {
+	switch idle.Source {
+	case pincherv1alpha1.PrometheusSource:
+		if len(idle.PrometheusURL) == 0 {
+			return nil, fmt.Errorf("prometheusURL is required with the prometheus source")
+		}
+		window := defaultIdleWindow
+		if len(idle.Window) != 0 {
+			var err error
+			if window, err = pkg.ParseDuration(idle.Window); err != nil {
+				return nil, fmt.Errorf("invalid idle window: %w", err)
+			}
+			if window <= 0 {
+				return nil, fmt.Errorf("invalid idle window: %s is not positive", idle.Window)
+			}
+		}
+		return pkg.NewPrometheusMetrics(idle.PrometheusURL, idle.Queries, window, r.client), nil
+	case pincherv1alpha1.MetricsServerSource:
+		return pkg.NewResourceMetrics(r.Kubectl), nil
+	default:
+		return nil, fmt.Errorf("unsupported metrics source %q", idle.Source)
+	}
+}
+
+// markIdleHibernated records the objects hibernated for being idle
+func markIdleHibernated(hibernator *pincherv1alpha1.Hibernator, impactedObjects []pincherv1alpha1.ImpactedObject, idleObjects []unstructured.Unstructured) {
+	idle := make(map[string]bool, len(idleObjects))
+	for _, idleObject := range idleObjects {
+		idle[getResourceKey(idleObject)] = true
+	}
+	for _, impactedObject := range impactedObjects {
+		if !idle[impactedObject.ResourceKey] || impactedObject.Status != "success" {
+			continue
+		}
+		if hibernator.Status.IdleHibernated == nil {
+			hibernator.Status.IdleHibernated = make(map[string]metav1.Time)
+		}
+		hibernator.Status.IdleHibernated[impactedObject.ResourceKey] = metav1.Time{Time: time.Now()}
+		delete(hibernator.Status.IdleSince, impactedObject.ResourceKey)
+	}
+}
+
+// clearIdleState forgets the idle objects, so that the objects hibernated for being idle are woken up along with the
+// other objects, and reports if there were any
+func clearIdleState(hibernator *pincherv1alpha1.Hibernator) bool {
+	cleared := len(hibernator.Status.IdleSince) > 0 || len(hibernator.Status.IdleHibernated) > 0
+	hibernator.Status.IdleSince = nil
+	hibernator.Status.IdleHibernated = nil
+	return cleared
+}
+
+func equalIdleSince(a, b map[string]metav1.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for resourceKey, since := range a {
+		other, ok := b[resourceKey]
+		if !ok || !other.Equal(&since) {
+			return false
+		}
+	}
+	return true
+}
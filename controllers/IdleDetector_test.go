@@ -0,0 +1,278 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/go-logr/logr"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+const idleObjectsMock = `
+[
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev"}, "spec": {"replicas": 2}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api", "namespace": "dev"}, "spec": {"replicas": 3}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "batch", "namespace": "dev"}, "spec": {"replicas": 0}}
+]`
+
+// newPrometheusStub serves the cpu usage of the pods of each deployment, web being idle
+func newPrometheusStub() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		value := "0.5"
+		if strings.Contains(query, `pod=~"web-.*"`) {
+			value = "0.01"
+		}
+		fmt.Fprintf(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000, "%s"]}]}}`, value)
+	}))
+}
+
+func getIdleHibernator(prometheusURL string) *pincherv1alpha1.Hibernator {
+	return &pincherv1alpha1.Hibernator{
+		ObjectMeta: metav1.ObjectMeta{Name: "idle", Namespace: "dev"},
+		Spec: pincherv1alpha1.HibernatorSpec{
+			Action: pincherv1alpha1.Hibernate,
+			Selectors: []pincherv1alpha1.Rule{{Inclusions: []pincherv1alpha1.Selector{{
+				ObjectSelector:    pincherv1alpha1.ObjectSelector{Type: "deployment"},
+				NamespaceSelector: pincherv1alpha1.NamespaceSelector{Name: "dev"},
+			}}}},
+			Idle: &pincherv1alpha1.Idle{
+				Source:        pincherv1alpha1.PrometheusSource,
+				PrometheusURL: prometheusURL,
+				Condition:     "{{cpu.p95}} < 0.05",
+				Duration:      "30m",
+			},
+		},
+		Status: pincherv1alpha1.HibernatorStatus{Action: pincherv1alpha1.UnHibernate},
+	}
+}
+
+func TestIdleDetectorImpl_getIdleObjects(t *testing.T) {
+	server := newPrometheusStub()
+	defer server.Close()
+	tests := []struct {
+		name          string
+		idle          func(idle *pincherv1alpha1.Idle)
+		idleSince     map[string]time.Duration
+		wantIdle      []string
+		wantIdleSince []string
+		wantExcluded  map[string]string
+		wantErrors    []pincherv1alpha1.ExpressionError
+	}{
+		{
+			name:          "idle for the first time",
+			wantIdleSince: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name:          "idle for the duration",
+			idleSince:     map[string]time.Duration{"/dev/apps/v1/Deployment/web": 31 * time.Minute},
+			wantIdle:      []string{"/dev/apps/v1/Deployment/web"},
+			wantIdleSince: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name:          "busy again",
+			idleSince:     map[string]time.Duration{"/dev/apps/v1/Deployment/api": 31 * time.Minute},
+			wantIdleSince: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name: "condition on a missing metric",
+			idle: func(idle *pincherv1alpha1.Idle) {
+				idle.Condition = "{{requests.rate}} < 1"
+			},
+			wantExcluded: map[string]string{"/dev/apps/v1/Deployment/web": pincherv1alpha1.ExclusionError, "/dev/apps/v1/Deployment/api": pincherv1alpha1.ExclusionError},
+			wantErrors:   []pincherv1alpha1.ExpressionError{{Expression: "{{requests.rate}} < 1", Error: "invalid operation: < (mismatched types <nil> and int) (1:6)\n | var0 < 1\n | .....^", Objects: 2}},
+		},
+		{
+			name: "custom query",
+			idle: func(idle *pincherv1alpha1.Idle) {
+				idle.Queries = map[string]string{"requests.rate": `sum(rate(http_requests_total{namespace="$namespace",pod=~"$name-.*"}[$window]))`}
+				idle.Condition = "{{requests.rate}} < 0.1"
+			},
+			wantIdleSince: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name: "duration and window in days",
+			idle: func(idle *pincherv1alpha1.Idle) {
+				idle.Duration = "1d"
+				idle.Window = "1d"
+			},
+			idleSince:     map[string]time.Duration{"/dev/apps/v1/Deployment/web": 25 * time.Hour},
+			wantIdle:      []string{"/dev/apps/v1/Deployment/web"},
+			wantIdleSince: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name: "invalid duration",
+			idle: func(idle *pincherv1alpha1.Idle) {
+				idle.Duration = "soon"
+			},
+			wantExcluded: map[string]string{"/dev/apps/v1/Deployment/web": pincherv1alpha1.ExclusionError, "/dev/apps/v1/Deployment/api": pincherv1alpha1.ExclusionError, "/dev/apps/v1/Deployment/batch": pincherv1alpha1.ExclusionError},
+		},
+		{
+			name: "unsupported source",
+			idle: func(idle *pincherv1alpha1.Idle) {
+				idle.Source = "datadog"
+			},
+			wantExcluded: map[string]string{"/dev/apps/v1/Deployment/web": pincherv1alpha1.ExclusionError, "/dev/apps/v1/Deployment/api": pincherv1alpha1.ExclusionError, "/dev/apps/v1/Deployment/batch": pincherv1alpha1.ExclusionError},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(idleObjectsMock)
+			r := NewIdleDetectorImpl(kubectl, server.Client())
+			hibernator := getIdleHibernator(server.URL)
+			if tt.idle != nil {
+				tt.idle(hibernator.Spec.Idle)
+			}
+			for resourceKey, ago := range tt.idleSince {
+				if hibernator.Status.IdleSince == nil {
+					hibernator.Status.IdleSince = make(map[string]metav1.Time)
+				}
+				hibernator.Status.IdleSince[resourceKey] = metav1.Time{Time: time.Now().Add(-ago)}
+			}
+			idleObjects, excludedObjects, failures := r.getIdleObjects(context.Background(), hibernator, getMockObjects(kubectl, "dev", "Deployment", "web", "api", "batch"))
+			var gotIdle []string
+			for _, object := range idleObjects {
+				gotIdle = append(gotIdle, getResourceKey(object))
+			}
+			if !reflect.DeepEqual(gotIdle, tt.wantIdle) {
+				t.Errorf("getIdleObjects() idle = %v, want %v", gotIdle, tt.wantIdle)
+			}
+			var gotIdleSince []string
+			for resourceKey := range hibernator.Status.IdleSince {
+				gotIdleSince = append(gotIdleSince, resourceKey)
+			}
+			sort.Strings(gotIdleSince)
+			if !reflect.DeepEqual(gotIdleSince, tt.wantIdleSince) {
+				t.Errorf("getIdleObjects() idleSince = %v, want %v", gotIdleSince, tt.wantIdleSince)
+			}
+			gotExcluded := make(map[string]string)
+			for _, object := range excludedObjects {
+				gotExcluded[object.ResourceKey] = object.Reason
+			}
+			if tt.wantExcluded == nil {
+				tt.wantExcluded = map[string]string{}
+			}
+			if !reflect.DeepEqual(gotExcluded, tt.wantExcluded) {
+				t.Errorf("getIdleObjects() excluded = %v, want %v", gotExcluded, tt.wantExcluded)
+			}
+			if gotErrors := getExpressionErrors(failures); !reflect.DeepEqual(gotErrors, tt.wantErrors) {
+				t.Errorf("getIdleObjects() expression errors = %v, want %v", gotErrors, tt.wantErrors)
+			}
+		})
+	}
+}
+
+// countingSelector counts the selections made through the wrapped selector
+type countingSelector struct {
+	ResourceSelector
+	selections int
+}
+
+func (s *countingSelector) getMatchingObjects(ctx context.Context, selectors []pincherv1alpha1.Selector) ([]unstructured.Unstructured, map[string]string, []expressionFailure, error) {
+	s.selections++
+	return s.ResourceSelector.getMatchingObjects(ctx, selectors)
+}
+
+func TestHibernatorActionImpl_hibernateIdle(t *testing.T) {
+	server := newPrometheusStub()
+	defer server.Close()
+	kubectl := pkg.NewKubectlMock(idleObjectsMock)
+	r := &HibernatorActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+		resourceAction: &ResourceActionImpl{
+			Kubectl:     kubectl,
+			historyUtil: &HistoryImpl{},
+		},
+		resourceSelector: &countingSelector{ResourceSelector: &ResourceSelectorImpl{
+			Kubectl: kubectl,
+			Mapper:  pkg.NewMockMapperFactory(),
+			factory: pkg.NewMockFactory,
+		}},
+		idleDetector: NewIdleDetectorImpl(kubectl, server.Client()),
+		log:          logr.Discard(),
+	}
+	hibernator := getIdleHibernator(server.URL)
+	replicas := func(name string) int64 {
+		j, _ := getMockObjects(kubectl, "dev", "Deployment", name)[0].MarshalJSON()
+		return gjson.Get(string(j), "spec.replicas").Int()
+	}
+	awake := pincherv1alpha1.NearestTimeGap{WithinRange: false}
+
+	// web is found idle but not yet for the duration
+	if _, updated := r.hibernate(context.Background(), hibernator, awake); !updated || len(hibernator.Status.IdleSince) != 1 || replicas("web") != 2 {
+		t.Fatalf("hibernate() updated = %v, idleSince = %v, web replicas = %d", updated, hibernator.Status.IdleSince, replicas("web"))
+	}
+
+	// web is hibernated once idle for the duration, among the objects selected to be woken up in the same run
+	hibernator.Status.IdleSince["/dev/apps/v1/Deployment/web"] = metav1.Time{Time: time.Now().Add(-31 * time.Minute)}
+	selector := r.resourceSelector.(*countingSelector)
+	selector.selections = 0
+	if _, updated := r.hibernate(context.Background(), hibernator, awake); !updated || replicas("web") != 0 || replicas("api") != 3 {
+		t.Fatalf("hibernate() updated = %v, web replicas = %d, api replicas = %d", updated, replicas("web"), replicas("api"))
+	}
+	if selector.selections != 2 {
+		t.Errorf("hibernate() selections = %d, want the inclusions and exclusions of the rule selected once", selector.selections)
+	}
+	if _, ok := hibernator.Status.IdleHibernated["/dev/apps/v1/Deployment/web"]; !ok || len(hibernator.Status.IdleSince) != 0 {
+		t.Errorf("hibernate() idleHibernated = %v, idleSince = %v", hibernator.Status.IdleHibernated, hibernator.Status.IdleSince)
+	}
+	latest := r.historyUtil.getLatestHistory(hibernator.Status.History)
+	if latest == nil || latest.Action != pincherv1alpha1.Hibernate || len(latest.ImpactedObjects) != 1 || latest.ImpactedObjects[0].Message != "hibernated as idle for 30m" {
+		t.Errorf("hibernate() latest history = %v", latest)
+	}
+
+	// web stays hibernated while awake
+	if r.hibernate(context.Background(), hibernator, awake); replicas("web") != 0 || hibernator.Status.ExcludedCounts[pincherv1alpha1.IdleHibernated] != 1 {
+		t.Errorf("hibernate() web replicas = %d, excludedCounts = %v", replicas("web"), hibernator.Status.ExcludedCounts)
+	}
+
+	// the idle state is forgotten in the time range and web is woken up at its end along with the others
+	r.hibernate(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})
+	if replicas("api") != 0 || hibernator.Status.IdleHibernated != nil {
+		t.Errorf("hibernate() api replicas = %d, idleHibernated = %v", replicas("api"), hibernator.Status.IdleHibernated)
+	}
+	r.hibernate(context.Background(), hibernator, awake)
+	if replicas("web") != 2 || replicas("api") != 3 {
+		t.Errorf("hibernate() web replicas = %d, api replicas = %d, want 2 and 3", replicas("web"), replicas("api"))
+	}
+
+	// the failures of the idle condition are recorded in status
+	hibernator.Spec.Idle.Condition = "{{requests.rate}} < 1"
+	if r.hibernate(context.Background(), hibernator, awake); len(hibernator.Status.ExpressionErrors) != 1 || hibernator.Status.ExpressionErrors[0].Expression != "{{requests.rate}} < 1" || hibernator.Status.ExpressionErrors[0].Objects != 2 {
+		t.Errorf("hibernate() expressionErrors = %v, want the idle condition failing for web and api", hibernator.Status.ExpressionErrors)
+	}
+	hibernator.Spec.Idle.Condition = "{{cpu.p95}} < 0.05"
+
+	// explicitly woken up objects are not detected as idle
+	hibernator.Spec.UnHibernate = true
+	if r.hibernate(context.Background(), hibernator, awake); hibernator.Status.IdleSince != nil {
+		t.Errorf("hibernate() idleSince = %v, want none when woken up", hibernator.Status.IdleSince)
+	}
+}
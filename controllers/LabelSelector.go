@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"strings"
+)
+
+// hasLabelSelector checks if the object selector selects objects by their labels
+func hasLabelSelector(objectSelector pincherv1alpha1.ObjectSelector) bool {
+	return len(objectSelector.Labels) != 0 || objectSelector.LabelSelector != nil
+}
+
+// hasNamespaceSelector checks if the namespace selector selects namespaces by their labels or annotations
+func hasNamespaceSelector(namespaceSelector pincherv1alpha1.NamespaceSelector) bool {
+	return len(namespaceSelector.Labels) != 0 || namespaceSelector.LabelSelector != nil || namespaceSelector.AnnotationSelector != nil
+}
+
+// getLabelSelector returns the selector requiring both the comma separated labels and the label selector
+func getLabelSelector(labelList []string, labelSelector *metav1.LabelSelector) (labels.Selector, error) {
+	selector, err := labels.Parse(strings.Join(labelList, ","))
+	if err != nil {
+		return nil, err
+	}
+	if labelSelector == nil {
+		return selector, nil
+	}
+	other, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	requirements, _ := other.Requirements()
+	return selector.Add(requirements...), nil
+}
+
+// filterByAnnotations returns the objects whose annotations match the annotation selector
+func filterByAnnotations(objects []unstructured.Unstructured, annotationSelector *metav1.LabelSelector) ([]unstructured.Unstructured, error) {
+	if annotationSelector == nil {
+		return objects, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(annotationSelector)
+	if err != nil {
+		return nil, err
+	}
+	var matched []unstructured.Unstructured
+	for _, object := range objects {
+		if selector.Matches(labels.Set(object.GetAnnotations())) {
+			matched = append(matched, object)
+		}
+	}
+	return matched, nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// namePattern is an entry of a comma separated list of names, either an exact name, a glob such as qa-* or a regular
+// expression enclosed in slashes such as /feature-.*-preview/ which has to match the whole name
+type namePattern struct {
+	name  string
+	glob  bool
+	regex *regexp.Regexp
+}
+
+func parseNamePatterns(names string) ([]namePattern, error) {
+	var patterns []namePattern
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if len(name) > 2 && strings.HasPrefix(name, "/") && strings.HasSuffix(name, "/") {
+			regex, err := regexp.Compile("^(?:" + name[1:len(name)-1] + ")$")
+			if err != nil {
+				return nil, err
+			}
+			patterns = append(patterns, namePattern{name: name, regex: regex})
+			continue
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, namePattern{name: name, glob: strings.ContainsAny(name, "*?[")})
+	}
+	return patterns, nil
+}
+
+// isLiteral checks if the pattern is an exact name
+func (p namePattern) isLiteral() bool {
+	return !p.glob && p.regex == nil
+}
+
+func (p namePattern) matches(name string) bool {
+	if p.regex != nil {
+		return p.regex.MatchString(name)
+	}
+	if p.glob {
+		matched, _ := path.Match(p.name, name)
+		return matched
+	}
+	return p.name == name
+}
+
+// hasWildcard checks if any of the comma separated names is a glob or a regular expression
+func hasWildcard(names string) bool {
+	patterns, err := parseNamePatterns(names)
+	if err != nil {
+		return true
+	}
+	for _, pattern := range patterns {
+		if !pattern.isLiteral() {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyName(patterns []namePattern, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+type namespaceCacheKey struct{}
+
+// namespaceCache keeps the namespaces listed during a reconcile keyed by label selector, so that every selector of a
+// hibernator resolves its namespaces from the same list
+type namespaceCache struct {
+	lock  sync.Mutex
+	lists map[string][]unstructured.Unstructured
+}
+
+// withNamespaceCache returns a context caching the namespaces listed through it
+func withNamespaceCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, namespaceCacheKey{}, &namespaceCache{lists: make(map[string][]unstructured.Unstructured)})
+}
+
+func getNamespaceCache(ctx context.Context) (*namespaceCache, bool) {
+	cache, ok := ctx.Value(namespaceCacheKey{}).(*namespaceCache)
+	return cache, ok
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_parseNamePatterns(t *testing.T) {
+	tests := []struct {
+		name        string
+		names       string
+		wantMatched []string
+		wantErr     bool
+		wildcard    bool
+	}{
+		{name: "exact names", names: "qa-1, prod", wantMatched: []string{"prod", "qa-1"}},
+		{name: "glob", names: "qa-*,pr-1234-*", wantMatched: []string{"pr-1234-api", "qa-1", "qa-2"}, wildcard: true},
+		{name: "single character glob", names: "qa-?", wantMatched: []string{"qa-1", "qa-2"}, wildcard: true},
+		{name: "regular expression", names: "/feature-.*-preview/", wantMatched: []string{"feature-login-preview"}, wildcard: true},
+		{name: "regular expression matches whole name", names: "/qa/", wildcard: true},
+		{name: "invalid glob", names: "qa-[", wantErr: true, wildcard: true},
+		{name: "invalid regular expression", names: "/qa-(/", wantErr: true, wildcard: true},
+	}
+	candidates := []string{"feature-login-preview", "pr-1234-api", "prod", "qa-1", "qa-2"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patterns, err := parseNamePatterns(tt.names)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNamePatterns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var matched []string
+			for _, candidate := range candidates {
+				if matchesAnyName(patterns, candidate) {
+					matched = append(matched, candidate)
+				}
+			}
+			if !reflect.DeepEqual(matched, tt.wantMatched) {
+				t.Errorf("matchesAnyName() matched = %v, want %v", matched, tt.wantMatched)
+			}
+			if got := hasWildcard(tt.names); got != tt.wildcard {
+				t.Errorf("hasWildcard() = %v, want %v", got, tt.wildcard)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_namePatterns(t *testing.T) {
+	tests := []struct {
+		name       string
+		inclusions []v1alpha1.Selector
+		exclusions []v1alpha1.Selector
+		want       []string
+	}{
+		{
+			name: "glob namespaces",
+			inclusions: []v1alpha1.Selector{{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", Name: "web-v1"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "qa-*"},
+			}},
+			want: []string{"/qa-1/apps/v1/Deployment/web-v1", "/qa-2/apps/v1/Deployment/web-v1"},
+		},
+		{
+			name: "glob names",
+			inclusions: []v1alpha1.Selector{{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", Name: "web-*"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "qa-1"},
+			}},
+			want: []string{"/qa-1/apps/v1/Deployment/web-v1", "/qa-1/apps/v1/Deployment/web-v2"},
+		},
+		{
+			name: "regular expression names and glob namespaces filtered by labels",
+			inclusions: []v1alpha1.Selector{{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", Name: "/web-v[0-9]+/"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "qa-*", Labels: []string{"keep=true"}},
+			}},
+			want: []string{"/qa-2/apps/v1/Deployment/web-v1"},
+		},
+		{
+			name: "excluded by patterns",
+			inclusions: []v1alpha1.Selector{{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "all"},
+			}},
+			exclusions: []v1alpha1.Selector{{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", Name: "web-*"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "/qa-[0-9]+/,prod"},
+			}},
+			want: []string{"/qa-1/apps/v1/Deployment/api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(name_pattern_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			ctx := withNamespaceCache(context.Background())
+			inclusions, _, _, _ := r.getMatchingObjects(ctx, tt.inclusions)
+			exclusions, _, _, _ := r.getMatchingObjects(ctx, tt.exclusions)
+			included, _, _ := r.getIncludedExcludedObjects(ctx, inclusions, exclusions)
+			var got []string
+			for _, object := range included {
+				got = append(got, getResourceKey(object))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMatchingObjects() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+type namespaceCountingKubectl struct {
+	pkg.KubectlCmd
+	lists int
+}
+
+func (k *namespaceCountingKubectl) ListResources(ctx context.Context, r *pkg.ListRequest) (*pkg.ListResponse, error) {
+	if r.Namespace == "" {
+		k.lists++
+	}
+	return k.KubectlCmd.ListResources(ctx, r)
+}
+
+func TestResourceSelectorImpl_listNamespacesOncePerReconcile(t *testing.T) {
+	kubectl := &namespaceCountingKubectl{KubectlCmd: pkg.NewKubectlMock(name_pattern_objects_mock)}
+	r := &ResourceSelectorImpl{
+		Kubectl: kubectl,
+		Mapper:  pkg.NewMockMapperFactory(),
+		factory: pkg.NewMockFactory,
+	}
+	selectors := []v1alpha1.Selector{
+		{ObjectSelector: v1alpha1.ObjectSelector{Type: "deployment"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "qa-*"}},
+		{ObjectSelector: v1alpha1.ObjectSelector{Type: "deployment"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "pr-*"}},
+		{ObjectSelector: v1alpha1.ObjectSelector{Type: "deployment"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "all"}},
+	}
+	r.getMatchingObjects(withNamespaceCache(context.Background()), selectors)
+	if kubectl.lists != 1 {
+		t.Errorf("getMatchingObjects() namespace lists = %d, want 1", kubectl.lists)
+	}
+	r.getMatchingObjects(withNamespaceCache(context.Background()), selectors)
+	if kubectl.lists != 2 {
+		t.Errorf("getMatchingObjects() namespace lists in next reconcile = %d, want 2", kubectl.lists)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/utils/pointer"
+	"sort"
+)
+
+const (
+	lockdownQuotaPrefix      = `hibernator-lockdown-`
+	hibernatorNameLabel      = `hibernator.devtron.ai/name`
+	hibernatorNamespaceLabel = `hibernator.devtron.ai/namespace`
+)
+
+var resourceQuotaGVK = schema.GroupVersionKind{Version: "v1", Kind: "ResourceQuota"}
+
+// getLockableNamespaces returns the namespaces in which every selected object is hibernated. Namespaces with objects
+// excluded by rule, opted out or failed keep running workloads which must still be able to create pods.
+func getLockableNamespaces(impactedObjects []pincherv1alpha1.ImpactedObject, excludedObjects []pincherv1alpha1.ExcludedObject) []string {
+	lockable := make(map[string]bool)
+	for _, impactedObject := range impactedObjects {
+		namespace, _, _, _, _ := componentsOfResourceKey(impactedObject.ResourceKey)
+		if _, ok := lockable[namespace]; !ok {
+			lockable[namespace] = true
+		}
+		if impactedObject.Status != "success" {
+			lockable[namespace] = false
+		}
+	}
+	for _, excludedObject := range excludedObjects {
+		namespace, _, _, _, _ := componentsOfResourceKey(excludedObject.ResourceKey)
+		if _, ok := lockable[namespace]; !ok {
+			lockable[namespace] = true
+		}
+		if excludedObject.Reason != pincherv1alpha1.AlreadyAtTarget && excludedObject.Reason != pincherv1alpha1.NoReplicaField {
+			lockable[namespace] = false
+		}
+	}
+	var namespaces []string
+	for namespace, ok := range lockable {
+		if ok && namespace != "" {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// LockNamespaces applies the lockdown quota to the namespaces in which every selected object is hibernated to zero
+// replicas and lifts it from namespaces which no longer qualify. Only the whole namespaces, all the objects of which
+// are selected, are locked as the quota stops the objects which are not selected from creating pods as well. It
+// reports if the locked namespaces changed.
+func (r *ResourceActionImpl) LockNamespaces(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, impactedObjects []pincherv1alpha1.ImpactedObject, excludedObjects []pincherv1alpha1.ExcludedObject, wholeNamespaces map[string]bool) bool {
+	if !hibernator.Spec.LockNamespaces {
+		return r.UnlockNamespaces(ctx, hibernator)
+	}
+	lockable := make(map[string]bool)
+	for _, namespace := range getLockableNamespaces(impactedObjects, excludedObjects) {
+		lockable[namespace] = wholeNamespaces[namespace]
+	}
+	locked := make(map[string]bool)
+	for _, namespace := range hibernator.Status.LockedNamespaces {
+		locked[namespace] = true
+	}
+	// objects kept at a non zero replica count need to create pods, only checked before locking a namespace
+	var hibernatedKeys []string
+	for _, impactedObject := range impactedObjects {
+		hibernatedKeys = append(hibernatedKeys, impactedObject.ResourceKey)
+	}
+	for _, excludedObject := range excludedObjects {
+		if excludedObject.Reason == pincherv1alpha1.AlreadyAtTarget {
+			hibernatedKeys = append(hibernatedKeys, excludedObject.ResourceKey)
+		}
+	}
+	for _, resourceKey := range hibernatedKeys {
+		namespace, _, _, _, _ := componentsOfResourceKey(resourceKey)
+		if lockable[namespace] && !locked[namespace] && r.hasNonZeroTarget(ctx, resourceKey) {
+			lockable[namespace] = false
+		}
+	}
+
+	var lockedNamespaces []string
+	for _, namespace := range hibernator.Status.LockedNamespaces {
+		if lockable[namespace] || r.unlockNamespace(ctx, hibernator, namespace) != nil {
+			lockedNamespaces = append(lockedNamespaces, namespace)
+		}
+	}
+	for namespace, ok := range lockable {
+		if !ok || locked[namespace] {
+			continue
+		}
+		if r.lockNamespace(ctx, hibernator, namespace) == nil {
+			lockedNamespaces = append(lockedNamespaces, namespace)
+		}
+	}
+	sort.Strings(lockedNamespaces)
+	updated := len(lockedNamespaces) != len(hibernator.Status.LockedNamespaces)
+	for i := 0; !updated && i < len(lockedNamespaces); i++ {
+		updated = lockedNamespaces[i] != hibernator.Status.LockedNamespaces[i]
+	}
+	hibernator.Status.LockedNamespaces = lockedNamespaces
+	return updated
+}
+
+// UnlockNamespaces removes the lockdown quota from all the locked namespaces. Namespaces which could not be unlocked
+// are kept in status to be retried in the next run. It reports if the locked namespaces changed.
+func (r *ResourceActionImpl) UnlockNamespaces(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) bool {
+	var lockedNamespaces []string
+	for _, namespace := range hibernator.Status.LockedNamespaces {
+		if r.unlockNamespace(ctx, hibernator, namespace) != nil {
+			lockedNamespaces = append(lockedNamespaces, namespace)
+		}
+	}
+	updated := len(lockedNamespaces) != len(hibernator.Status.LockedNamespaces)
+	hibernator.Status.LockedNamespaces = lockedNamespaces
+	return updated
+}
+
+// hasNonZeroTarget checks if the object is kept at a non zero replica count through the target replicas annotation
+func (r *ResourceActionImpl) hasNonZeroTarget(ctx context.Context, resourceKey string) bool {
+	namespace, group, version, kind, name := componentsOfResourceKey(resourceKey)
+	resp, err := r.Kubectl.GetResource(ctx, &pkg.GetRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: schema.GroupVersionKind{Group: group, Version: version, Kind: kind},
+	})
+	if err != nil {
+		return false
+	}
+	count, ok := getIntAnnotation(resp.Manifest, targetReplicasAnnotation)
+	return ok && count != 0
+}
+
+func lockdownQuotaName(hibernator *pincherv1alpha1.Hibernator) string {
+	return lockdownQuotaPrefix + hibernator.Name
+}
+
+// isLockdownQuota checks if the quota was created by the hibernator
+func isLockdownQuota(hibernator *pincherv1alpha1.Hibernator, quota unstructured.Unstructured) bool {
+	labels := quota.GetLabels()
+	return labels[hibernatorNameLabel] == hibernator.Name && labels[hibernatorNamespaceLabel] == hibernator.Namespace
+}
+
+// lockNamespace creates the lockdown quota in namespace. The quota is owned by the hibernator when both are in the same
+// namespace, owner references across namespaces not being allowed, and is otherwise identified by its labels.
+func (r *ResourceActionImpl) lockNamespace(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, namespace string) error {
+	name := lockdownQuotaName(hibernator)
+	resp, err := r.Kubectl.GetResource(ctx, &pkg.GetRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: resourceQuotaGVK,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && resp.Manifest.GetName() != "" {
+		if isLockdownQuota(hibernator, resp.Manifest) {
+			return nil
+		}
+		return fmt.Errorf("resource quota %s/%s is not managed by hibernator", namespace, name)
+	}
+
+	quota := unstructured.Unstructured{}
+	quota.SetGroupVersionKind(resourceQuotaGVK)
+	quota.SetName(name)
+	quota.SetNamespace(namespace)
+	quota.SetLabels(map[string]string{
+		hibernatorNameLabel:      hibernator.Name,
+		hibernatorNamespaceLabel: hibernator.Namespace,
+	})
+	if namespace == hibernator.Namespace && hibernator.UID != "" {
+		quota.SetOwnerReferences([]metav1.OwnerReference{{
+			APIVersion: pincherv1alpha1.GroupVersion.String(),
+			Kind:       "Hibernator",
+			Name:       hibernator.Name,
+			UID:        hibernator.UID,
+			Controller: pointer.BoolPtr(true),
+		}})
+	}
+	if err = unstructured.SetNestedField(quota.Object, "0", "spec", "hard", "pods"); err != nil {
+		return err
+	}
+	_, err = r.Kubectl.CreateResource(ctx, &pkg.CreateRequest{
+		Namespace:        namespace,
+		GroupVersionKind: resourceQuotaGVK,
+		Manifest:         quota,
+	})
+	return err
+}
+
+// unlockNamespace deletes the lockdown quota from namespace, quotas not created by the hibernator are left untouched
+func (r *ResourceActionImpl) unlockNamespace(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, namespace string) error {
+	name := lockdownQuotaName(hibernator)
+	resp, err := r.Kubectl.GetResource(ctx, &pkg.GetRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: resourceQuotaGVK,
+	})
+	if errors.IsNotFound(err) || (err == nil && !isLockdownQuota(hibernator, resp.Manifest)) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.Kubectl.DeleteResource(ctx, &pkg.DeleteRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: resourceQuotaGVK,
+	})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
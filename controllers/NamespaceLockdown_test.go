@@ -0,0 +1,147 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResourceActionImpl_lockAndUnlockNamespaces(t *testing.T) {
+	tests := []struct {
+		name                 string
+		lockNamespaces       bool
+		impactedObjects      []pincherv1alpha1.ImpactedObject
+		excludedObjects      []pincherv1alpha1.ExcludedObject
+		wholeNamespaces      []string
+		wantLockedNamespaces []string
+	}{
+		{
+			name:           "lock namespace with all workloads hibernated",
+			lockNamespaces: true,
+			impactedObjects: []pincherv1alpha1.ImpactedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/api", Status: "success"},
+			},
+			excludedObjects: []pincherv1alpha1.ExcludedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/worker", Reason: pincherv1alpha1.AlreadyAtTarget},
+			},
+			wholeNamespaces:      []string{"dev"},
+			wantLockedNamespaces: []string{"dev"},
+		},
+		{
+			name:           "skip namespaces with running workloads",
+			lockNamespaces: true,
+			impactedObjects: []pincherv1alpha1.ImpactedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/api", Status: "success"},
+				{ResourceKey: "/edge/apps/v1/Deployment/gateway", Status: "success"},
+			},
+			excludedObjects: []pincherv1alpha1.ExcludedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/worker", Reason: pincherv1alpha1.OptedOut},
+			},
+			wholeNamespaces:      []string{"dev", "edge"},
+			wantLockedNamespaces: nil,
+		},
+		{
+			name:           "skip namespace with quota not created by hibernator",
+			lockNamespaces: true,
+			impactedObjects: []pincherv1alpha1.ImpactedObject{
+				{ResourceKey: "/qa/apps/v1/Deployment/app", Status: "success"},
+				{ResourceKey: "/dev/apps/v1/Deployment/api", Status: "success"},
+			},
+			wholeNamespaces:      []string{"dev", "qa"},
+			wantLockedNamespaces: []string{"dev"},
+		},
+		{
+			name:           "skip namespace whose selected workloads are all hibernated but not all its objects selected",
+			lockNamespaces: true,
+			impactedObjects: []pincherv1alpha1.ImpactedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/api", Status: "success"},
+			},
+			excludedObjects: []pincherv1alpha1.ExcludedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/worker", Reason: pincherv1alpha1.AlreadyAtTarget},
+			},
+			wantLockedNamespaces: nil,
+		},
+		{
+			name:           "lockdown disabled",
+			lockNamespaces: false,
+			impactedObjects: []pincherv1alpha1.ImpactedObject{
+				{ResourceKey: "/dev/apps/v1/Deployment/api", Status: "success"},
+			},
+			wholeNamespaces:      []string{"dev"},
+			wantLockedNamespaces: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(lockdown_objects_mock)
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "dev", UID: "1234"},
+				Spec: pincherv1alpha1.HibernatorSpec{
+					Action:         pincherv1alpha1.Hibernate,
+					LockNamespaces: tt.lockNamespaces,
+				},
+			}
+			wholeNamespaces := make(map[string]bool)
+			for _, namespace := range tt.wholeNamespaces {
+				wholeNamespaces[namespace] = true
+			}
+			updated := r.LockNamespaces(context.Background(), hibernator, tt.impactedObjects, tt.excludedObjects, wholeNamespaces)
+			if !reflect.DeepEqual(hibernator.Status.LockedNamespaces, tt.wantLockedNamespaces) || updated != (len(tt.wantLockedNamespaces) > 0) {
+				t.Errorf("LockNamespaces() lockedNamespaces = %v, updated = %v, want %v", hibernator.Status.LockedNamespaces, updated, tt.wantLockedNamespaces)
+			}
+			for _, namespace := range tt.wantLockedNamespaces {
+				quota, _ := getMockObjects(kubectl, namespace, "ResourceQuota", "hibernator-lockdown-nightly")[0].MarshalJSON()
+				if pods := gjson.Get(string(quota), "spec.hard.pods").String(); pods != "0" {
+					t.Errorf("LockNamespaces() quota pods = %v, want 0", pods)
+				}
+				if owner := gjson.Get(string(quota), "metadata.ownerReferences.0.uid").String(); owner != "1234" {
+					t.Errorf("LockNamespaces() quota owner = %v, want 1234", owner)
+				}
+			}
+			if r.LockNamespaces(context.Background(), hibernator, tt.impactedObjects, tt.excludedObjects, wholeNamespaces) {
+				t.Errorf("LockNamespaces() updated on resync")
+			}
+
+			r.UnlockNamespaces(context.Background(), hibernator)
+			if len(hibernator.Status.LockedNamespaces) != 0 {
+				t.Errorf("UnlockNamespaces() lockedNamespaces = %v, want none", hibernator.Status.LockedNamespaces)
+			}
+			if quota := getMockObjects(kubectl, "dev", "ResourceQuota", "hibernator-lockdown-nightly")[0]; quota.GetName() != "" {
+				t.Errorf("UnlockNamespaces() quota not removed")
+			}
+			preExistingQuotas := map[string]string{"dev/compute": "20", "qa/hibernator-lockdown-nightly": "10"}
+			for quotaKey, wantPods := range preExistingQuotas {
+				namespace, name := quotaKey[:strings.Index(quotaKey, "/")], quotaKey[strings.Index(quotaKey, "/")+1:]
+				quota, _ := getMockObjects(kubectl, namespace, "ResourceQuota", name)[0].MarshalJSON()
+				if pods := gjson.Get(string(quota), "spec.hard.pods").String(); pods != wantPods {
+					t.Errorf("pre-existing quota %s pods = %v, want %v", quotaKey, pods, wantPods)
+				}
+			}
+		})
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/utils/pointer"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -32,9 +33,12 @@ import (
 type Execute func(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject)
 
 type ResourceAction interface {
-	DeleteAction(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject)
-	ScaleActionFactory(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) Execute
-	ResetScaleActionFactory(hibernator *pincherv1alpha1.Hibernator) Execute
+	DeleteActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) Execute
+	ScaleActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) Execute
+	ResetScaleActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) Execute
+	ResumeGitOpsOwners(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) []pincherv1alpha1.SuspendedOwner
+	LockNamespaces(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, impactedObjects []pincherv1alpha1.ImpactedObject, excludedObjects []pincherv1alpha1.ExcludedObject, wholeNamespaces map[string]bool) bool
+	UnlockNamespaces(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) bool
 }
 
 func NewResourceActionImpl(kubectl pkg.KubectlCmd, historyUtil History) ResourceAction {
@@ -49,36 +53,38 @@ type ResourceActionImpl struct {
 	historyUtil History
 }
 
-func (r *ResourceActionImpl) DeleteAction(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
-	impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
-	excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
-
-	for _, inc := range included {
+func (r *ResourceActionImpl) DeleteActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) Execute {
+	return func(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
+		impactedObjects := make([]pincherv1alpha1.ImpactedObject, len(included))
+		excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
 
-		impactedObject := pincherv1alpha1.ImpactedObject{
-			ResourceKey: getResourceKey(inc),
-			Status:      "success",
-		}
+		runBounded(hibernator.Spec.Parallelism, len(included), func(i int) {
+			inc := included[i]
+			impactedObject := pincherv1alpha1.ImpactedObject{
+				ResourceKey: getResourceKey(inc),
+				Status:      "success",
+			}
 
-		request := &pkg.DeleteRequest{
-			Name:             inc.GetName(),
-			Namespace:        inc.GetNamespace(),
-			GroupVersionKind: inc.GroupVersionKind(),
-			Force:            pointer.BoolPtr(true),
-		}
-		_, err := r.Kubectl.DeleteResource(context.Background(), request)
+			request := &pkg.DeleteRequest{
+				Name:             inc.GetName(),
+				Namespace:        inc.GetNamespace(),
+				GroupVersionKind: inc.GroupVersionKind(),
+				Force:            pointer.BoolPtr(true),
+			}
+			_, err := r.Kubectl.DeleteResource(ctx, request)
 
-		if err != nil {
-			impactedObject.Status = "error"
-			impactedObject.Message = err.Error()
-		}
+			if err != nil {
+				impactedObject.Status = "error"
+				impactedObject.Message = err.Error()
+			}
 
-		impactedObjects = append(impactedObjects, impactedObject)
+			impactedObjects[i] = impactedObject
+		})
+		return impactedObjects, excludedObjects
 	}
-	return impactedObjects, excludedObjects
 }
 
-func (r *ResourceActionImpl) ScaleActionFactory(hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) Execute {
+func (r *ResourceActionImpl) ScaleActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, timeGap pincherv1alpha1.NearestTimeGap) Execute {
 	fmt.Printf("entering ScaleActionFactory %s \n", time.Now().Format(time.RFC1123Z))
 	targetReplicaCount := 0
 	if hibernator.Spec.TargetReplicas != nil && len(*hibernator.Spec.TargetReplicas) > timeGap.MatchedIndex {
@@ -93,45 +99,82 @@ func (r *ResourceActionImpl) ScaleActionFactory(hibernator *pincherv1alpha1.Hibe
 	fmt.Printf("entering ScaleActionFactory %d \n", targetReplicaCount)
 	return func(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
 
-		impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
 		excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
 
-		for _, inc := range included {
+		included, autoscalers := r.pairAutoscalers(ctx, included, true)
+		// patches are executed concurrently once planned, results are kept per object to be aggregated in order
+		results := make([][]pincherv1alpha1.ImpactedObject, len(included))
+		var pending []pendingPatch
+		for i, inc := range included {
+
+			if isOptedOut(inc) {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.OptedOut, "opted out through annotation"))
+				continue
+			}
 
 			to, err := inc.MarshalJSON()
 			if err != nil {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, err.Error()))
+				continue
+			}
+
+			objectTargetReplicaCount := targetReplicaCount
+			if count, ok := getIntAnnotation(inc, targetReplicasAnnotation); ok && count >= 0 {
+				objectTargetReplicaCount = count
+			}
+
+			autoscaler := autoscalers[getResourceKey(inc)]
+			if autoscaler.autoscaler != nil && autoscaler.autoscaler.GetKind() == scaledObjectKind {
+				pausedObjects, notPausedObjects := r.pauseScaledObject(ctx, hibernator, *autoscaler.autoscaler, inc, objectTargetReplicaCount)
+				if len(pausedObjects) > 0 && pausedObjects[0].Status == "success" && autoscaler.verticalAutoscaler != nil {
+					pausedObjects = append(pausedObjects, r.pairVerticalAutoscaler(ctx, hibernator, *autoscaler.verticalAutoscaler, pausedObjects[0].ResourceKey)...)
+				}
+				results[i] = pausedObjects
+				excludedObjects = append(excludedObjects, notPausedObjects...)
 				continue
 			}
 
-			replicaCount := gjson.Get(string(to), "spec.replicas")
+			replicaCount := gjson.Get(string(to), replicaField(inc))
+			if !replicaCount.Exists() {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.NoReplicaField, replicaField(inc)+" not found"))
+				continue
+			}
 
-			if int(replicaCount.Int()) == targetReplicaCount {
+			if excludedObject, ok := r.handleDrift(ctx, hibernator, inc, int(replicaCount.Int())); ok {
+				excludedObjects = append(excludedObjects, excludedObject)
 				continue
 			}
+			message := ""
+			if scaled, ok := hibernator.Status.ScaledReplicas[getResourceKey(inc)]; ok && scaled != int(replicaCount.Int()) {
+				message = fmt.Sprintf("reverted change to %d replicas made outside of the hibernator", replicaCount.Int())
+			}
 
-			patch := fmt.Sprintf(replicaPatch, targetReplicaCount)
-			if !r.hasReplicaAnnotation(inc) {
-				fmt.Println("annotation missing in ScaleActionFactory")
-				patch = fmt.Sprintf(replicaAndAnnotationPatch, targetReplicaCount, replicaAnnotation, replicaCount.Raw)
-			} else {
-				fmt.Println("annotation found in ScaleActionFactory")
+			if int(replicaCount.Int()) == objectTargetReplicaCount {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.AlreadyAtTarget, ""))
+				continue
 			}
 
-			if inc.GetKind() == "HorizontalPodAutoscaler" {
-				replicaCount = gjson.Get(string(to), "spec.minReplicas")
+			// scaling is reverted by the GitOps owner unless it is suspended first
+			if err = r.suspendGitOpsOwner(ctx, hibernator, inc); err != nil {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, "failed to suspend gitops owner: "+err.Error()))
+				continue
+			}
 
-				if int(replicaCount.Int()) == targetReplicaCount {
-					continue
-				}
-				patch = fmt.Sprintf(minReplicaPatch, targetReplicaCount)
-				if !r.hasReplicaAnnotation(inc) {
-					patch = fmt.Sprintf(minReplicaAndAnnotationPatch, targetReplicaCount, replicaAnnotation, replicaCount.Raw)
-				}
+			// the object may already be scaled down in an earlier run, for example by a different target replica count
+			originalCount, err := r.getOriginalReplicaCount(hibernator, inc)
+			if err != nil {
+				originalCount = int(replicaCount.Int())
+			}
+
+			patch := fmt.Sprintf(replicaAndAnnotationPatch, objectTargetReplicaCount, replicaAnnotation, strconv.Itoa(originalCount))
+			if inc.GetKind() == autoscalerKind {
+				patch = fmt.Sprintf(minReplicaAndAnnotationPatch, objectTargetReplicaCount, replicaAnnotation, strconv.Itoa(originalCount))
 			}
 
 			impactedObject := pincherv1alpha1.ImpactedObject{
 				ResourceKey:   getResourceKey(inc),
-				OriginalCount: int(replicaCount.Int()),
+				OriginalCount: originalCount,
+				Message:       message,
 				Status:        "success",
 			}
 
@@ -140,22 +183,36 @@ func (r *ResourceActionImpl) ScaleActionFactory(hibernator *pincherv1alpha1.Hibe
 				Namespace:        inc.GetNamespace(),
 				GroupVersionKind: inc.GroupVersionKind(),
 				Patch:            patch,
-				PatchType:        string(types.JSONPatchType),
+				PatchType:        string(types.MergePatchType),
 			}
-			_, err = r.Kubectl.PatchResource(context.Background(), request)
+			pending = append(pending, pendingPatch{index: i, impactedObject: impactedObject, request: request, targetReplicaCount: objectTargetReplicaCount})
+		}
 
+		for j, err := range r.patchResources(ctx, hibernator, pending) {
+			impactedObject := pending[j].impactedObject
 			if err != nil {
 				impactedObject.Status = "error"
 				impactedObject.Message = err.Error()
+				results[pending[j].index] = append(results[pending[j].index], impactedObject)
+				continue
 			}
+			storeOriginalReplicaCount(hibernator, impactedObject.ResourceKey, impactedObject.OriginalCount)
+			storeScaledReplicaCount(hibernator, impactedObject.ResourceKey, pending[j].targetReplicaCount)
+			results[pending[j].index] = append(results[pending[j].index], impactedObject)
 
-			impactedObjects = append(impactedObjects, impactedObject)
+			autoscaler := autoscalers[impactedObject.ResourceKey]
+			if autoscaler.autoscaler != nil {
+				results[pending[j].index] = append(results[pending[j].index], r.pairAutoscaler(ctx, hibernator, *autoscaler.autoscaler, impactedObject.ResourceKey, pending[j].targetReplicaCount))
+			}
+			if autoscaler.verticalAutoscaler != nil {
+				results[pending[j].index] = append(results[pending[j].index], r.pairVerticalAutoscaler(ctx, hibernator, *autoscaler.verticalAutoscaler, impactedObject.ResourceKey)...)
+			}
 		}
-		return impactedObjects, excludedObjects
+		return flattenImpactedObjects(results), excludedObjects
 	}
 }
 
-func (r *ResourceActionImpl) ResetScaleActionFactory(hibernator *pincherv1alpha1.Hibernator) Execute {
+func (r *ResourceActionImpl) ResetScaleActionFactory(ctx context.Context, hibernator *pincherv1alpha1.Hibernator) Execute {
 	fmt.Printf("entering ResetScaleActionFactory %s \n", time.Now().Format(time.RFC1123Z))
 	previousHibernatedObjects := make(map[string]int, 0)
 	latestHistory := r.historyUtil.getLatestHistory(hibernator.Status.History)
@@ -166,37 +223,75 @@ func (r *ResourceActionImpl) ResetScaleActionFactory(hibernator *pincherv1alpha1
 	}
 
 	return func(included []unstructured.Unstructured) ([]pincherv1alpha1.ImpactedObject, []pincherv1alpha1.ExcludedObject) {
-		impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
 		excludedObjects := make([]pincherv1alpha1.ExcludedObject, 0)
 
-		for _, inc := range included {
-
-			to, err := inc.MarshalJSON()
-			if err != nil {
+		included, _ = r.pairAutoscalers(ctx, included, false)
+		included = sortByWakePriority(included)
+		results := make([][]pincherv1alpha1.ImpactedObject, len(included))
+		var pending []pendingPatch
+		// objects of the same wake priority are patched concurrently, before any object of a lower priority
+		executePending := func() {
+			for j, err := range r.patchResources(ctx, hibernator, pending) {
+				impactedObject := pending[j].impactedObject
+				if err != nil {
+					impactedObject.Status = "error"
+					impactedObject.Message = err.Error()
+				} else {
+					delete(hibernator.Status.OriginalReplicas, impactedObject.ResourceKey)
+				}
+				results[pending[j].index] = append(results[pending[j].index], impactedObject)
+			}
+			pending = nil
+		}
+		for i, inc := range included {
+			if i > 0 && wakePriority(inc) != wakePriority(included[i-1]) {
+				executePending()
+			}
+			// objects hibernated for being idle sleep until the end of the next time range
+			if hibernatedAt, ok := hibernator.Status.IdleHibernated[getResourceKey(inc)]; ok {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.IdleHibernated, "hibernated as idle at "+hibernatedAt.Format(time.RFC3339)))
+				continue
+			}
+			// the object is no longer expected to be at its hibernated replica count
+			delete(hibernator.Status.ScaledReplicas, getResourceKey(inc))
+			if override, ok := getManualOverride(hibernator, getResourceKey(inc)); ok && hibernator.Spec.DriftPolicy == pincherv1alpha1.RespectManualWake {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ManuallyChanged, fmt.Sprintf("released at %d replicas", override.Replicas)))
 				continue
 			}
 
-			currentReplicaCount := gjson.Get(string(to), "spec.replicas")
+			if scaledObject, ok := getPausedScaledObject(hibernator, getResourceKey(inc)); ok {
+				results[i] = append(results[i], r.resumeScaledObject(ctx, hibernator, scaledObject))
+				continue
+			}
 
-			replicaCount, err := r.getOriginalReplicaCount(inc)
+			to, err := inc.MarshalJSON()
 			if err != nil {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, err.Error()))
 				continue
 			}
 
-			if replicaCount == 0 && (hibernator.Spec.Action == pincherv1alpha1.Hibernate || hibernator.Spec.Action == pincherv1alpha1.Sleep) {
+			currentReplicaCount := gjson.Get(string(to), replicaField(inc))
+			if !currentReplicaCount.Exists() {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.NoReplicaField, replicaField(inc)+" not found"))
 				continue
 			}
 
-			if replicaCount == int(currentReplicaCount.Int()) {
+			replicaCount, err := r.getOriginalReplicaCount(hibernator, inc)
+			if err != nil {
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.MissingOriginalCount, err.Error()))
 				continue
 			}
 
-			if err != nil {
-				excludedObject := pincherv1alpha1.ExcludedObject{
-					ResourceKey: getResourceKey(inc),
-					Reason:      "error determining original count",
+			alreadyAtTarget, message := replicaCount == int(currentReplicaCount.Int()), ""
+			if replicaCount == 0 && (hibernator.Spec.Action == pincherv1alpha1.Hibernate || hibernator.Spec.Action == pincherv1alpha1.Sleep) {
+				alreadyAtTarget, message = true, "original count is 0"
+			}
+			if alreadyAtTarget {
+				if err := r.forgetOriginalReplicaCount(ctx, hibernator, inc); err != nil {
+					excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.ExclusionError, "failed to remove original count: "+err.Error()))
+					continue
 				}
-				excludedObjects = append(excludedObjects, excludedObject)
+				excludedObjects = append(excludedObjects, newExcludedObject(inc, pincherv1alpha1.AlreadyAtTarget, message))
 				continue
 			}
 
@@ -206,10 +301,10 @@ func (r *ResourceActionImpl) ResetScaleActionFactory(hibernator *pincherv1alpha1
 				Status:        "success",
 			}
 
-			patch := fmt.Sprintf(replicaPatch, replicaCount)
+			patch := fmt.Sprintf(resetReplicaPatch, replicaCount, replicaAnnotation)
 
-			if inc.GetKind() == "HorizontalPodAutoscaler" {
-				patch = fmt.Sprintf(minReplicaPatch, replicaCount)
+			if inc.GetKind() == autoscalerKind {
+				patch = fmt.Sprintf(resetMinReplicaPatch, replicaCount, replicaAnnotation)
 			}
 
 			request := &pkg.PatchRequest{
@@ -217,45 +312,123 @@ func (r *ResourceActionImpl) ResetScaleActionFactory(hibernator *pincherv1alpha1
 				Namespace:        inc.GetNamespace(),
 				GroupVersionKind: inc.GroupVersionKind(),
 				Patch:            patch,
-				PatchType:        string(types.JSONPatchType),
-			}
-			_, err = r.Kubectl.PatchResource(context.Background(), request)
-
-			if err != nil {
-				impactedObject.Status = "error"
-				impactedObject.Message = err.Error()
+				PatchType:        string(types.MergePatchType),
 			}
+			pending = append(pending, pendingPatch{index: i, impactedObject: impactedObject, request: request})
+		}
+		executePending()
 
-			impactedObjects = append(impactedObjects, impactedObject)
+		impactedObjects := flattenImpactedObjects(results)
+		awake := make(map[string]bool)
+		for _, impactedObject := range impactedObjects {
+			awake[impactedObject.ResourceKey] = impactedObject.Status == "success"
+		}
+		for _, excludedObject := range excludedObjects {
+			awake[excludedObject.ResourceKey] = excludedObject.Reason == pincherv1alpha1.AlreadyAtTarget
 		}
+		impactedObjects = append(impactedObjects, r.restorePairedAutoscalers(ctx, hibernator, awake)...)
 		return impactedObjects, excludedObjects
 	}
 }
 
-func (r *ResourceActionImpl) getOriginalReplicaCount(res unstructured.Unstructured /*, previousHibernatedObjects map[string]int*/) (int, error) {
+// getOriginalReplicaCount returns the replica count of the object before it was scaled down. The count stored in
+// the status of the hibernator is authoritative, the annotation on the object is used when it is not stored, for
+// example when hibernated by an older version.
+func (r *ResourceActionImpl) getOriginalReplicaCount(hibernator *pincherv1alpha1.Hibernator, res unstructured.Unstructured) (int, error) {
+	if replicaCount, ok := hibernator.Status.OriginalReplicas[getResourceKey(res)]; ok {
+		return replicaCount, nil
+	}
 	to, err := res.MarshalJSON()
 	if err != nil {
 		return 0, err
 	}
 	annotations := gjson.Get(string(to), "metadata.annotations")
 	originalCount := annotations.Map()[replicaAnnotation].Str
-	replicaCount, err := strconv.Atoi(originalCount)
-	//if len(originalCount) == 0 || err != nil {
-	//	resourceKey := getResourceKey(res)
-	//	ok := false
-	//	if replicaCount, ok = previousHibernatedObjects[resourceKey]; !ok {
-	//		return 0, nil
-	//	}
-	//}
-	return replicaCount, err
+	return strconv.Atoi(originalCount)
 }
 
-func (r *ResourceActionImpl) hasReplicaAnnotation(res unstructured.Unstructured) bool {
-	to, err := res.MarshalJSON()
-	if err != nil {
-		return false
+// forgetOriginalReplicaCount removes the original replica count of an object which is already at it from the status
+// of the hibernator and from the annotation on the object, so that a later change to its replica count is not reverted
+// to the stale count by the next wake up
+func (r *ResourceActionImpl) forgetOriginalReplicaCount(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, res unstructured.Unstructured) error {
+	if _, ok := res.GetAnnotations()[replicaAnnotation]; ok {
+		request := &pkg.PatchRequest{
+			Name:             res.GetName(),
+			Namespace:        res.GetNamespace(),
+			GroupVersionKind: res.GroupVersionKind(),
+			Patch:            fmt.Sprintf(removeAnnotationMergePatch, replicaAnnotation),
+			PatchType:        string(types.MergePatchType),
+		}
+		if _, err := r.Kubectl.PatchResource(ctx, request); err != nil {
+			return err
+		}
+	}
+	delete(hibernator.Status.OriginalReplicas, getResourceKey(res))
+	return nil
+}
+
+func storeOriginalReplicaCount(hibernator *pincherv1alpha1.Hibernator, resourceKey string, replicaCount int) {
+	if hibernator.Status.OriginalReplicas == nil {
+		hibernator.Status.OriginalReplicas = make(map[string]int)
+	}
+	hibernator.Status.OriginalReplicas[resourceKey] = replicaCount
+}
+
+// pendingPatch is the patch of an object planned to be executed concurrently with the patches of other objects
+type pendingPatch struct {
+	// index is the index of the object in the objects being acted upon
+	index              int
+	impactedObject     pincherv1alpha1.ImpactedObject
+	request            *pkg.PatchRequest
+	targetReplicaCount int
+}
+
+// patchResources executes the pending patches with at most spec.parallelism patches in flight and returns the error
+// of each patch at its index
+func (r *ResourceActionImpl) patchResources(ctx context.Context, hibernator *pincherv1alpha1.Hibernator, pending []pendingPatch) []error {
+	errs := make([]error, len(pending))
+	runBounded(hibernator.Spec.Parallelism, len(pending), func(i int) {
+		_, errs[i] = r.Kubectl.PatchResource(ctx, pending[i].request)
+	})
+	return errs
+}
+
+func flattenImpactedObjects(results [][]pincherv1alpha1.ImpactedObject) []pincherv1alpha1.ImpactedObject {
+	impactedObjects := make([]pincherv1alpha1.ImpactedObject, 0)
+	for _, result := range results {
+		impactedObjects = append(impactedObjects, result...)
+	}
+	return impactedObjects
+}
+
+func wakePriority(obj unstructured.Unstructured) int {
+	priority, _ := getIntAnnotation(obj, wakePriorityAnnotation)
+	return priority
+}
+
+// sortByWakePriority orders objects by the wake priority annotation, higher priority first. Objects without
+// priority have priority 0 and keep their relative order.
+func sortByWakePriority(objects []unstructured.Unstructured) []unstructured.Unstructured {
+	sorted := make([]unstructured.Unstructured, len(objects))
+	copy(sorted, objects)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return wakePriority(sorted[i]) > wakePriority(sorted[j])
+	})
+	return sorted
+}
+
+// replicaField returns the gjson path of the field holding the replica count of the object
+func replicaField(obj unstructured.Unstructured) string {
+	if obj.GetKind() == autoscalerKind {
+		return "spec.minReplicas"
+	}
+	return "spec.replicas"
+}
+
+func newExcludedObject(obj unstructured.Unstructured, reason, message string) pincherv1alpha1.ExcludedObject {
+	return pincherv1alpha1.ExcludedObject{
+		ResourceKey: getResourceKey(obj),
+		Reason:      reason,
+		Message:     message,
 	}
-	annotations := gjson.Get(string(to), "metadata.annotations")
-	originalCount := annotations.Map()[replicaAnnotation].Str
-	return len(originalCount) != 0
 }
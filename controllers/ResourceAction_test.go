@@ -0,0 +1,326 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"github.com/devtron-labs/winter-soldier/pkg"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func getMockObjects(kubectl pkg.KubectlCmd, namespace, kind string, names ...string) []unstructured.Unstructured {
+	var objects []unstructured.Unstructured
+	for _, name := range names {
+		resp, err := kubectl.GetResource(context.Background(), &pkg.GetRequest{
+			Name:             name,
+			Namespace:        namespace,
+			GroupVersionKind: schema.GroupVersionKind{Kind: kind},
+		})
+		if err != nil {
+			continue
+		}
+		objects = append(objects, resp.Manifest)
+	}
+	return objects
+}
+
+func TestResourceActionImpl_ScaleActionFactory(t *testing.T) {
+	tests := []struct {
+		name         string
+		objects      []string
+		wantImpacted map[string]int
+		wantExcluded map[string]string
+		wantReplicas map[string]int64
+	}{
+		{
+			name:         "exclude and target replicas annotations",
+			objects:      []string{"opted-out", "keep-one", "frontend"},
+			wantImpacted: map[string]int{"/dev/apps/v1/Deployment/keep-one": 3},
+			wantExcluded: map[string]string{
+				"/dev/apps/v1/Deployment/opted-out": pincherv1alpha1.OptedOut,
+				"/dev/apps/v1/Deployment/frontend":  pincherv1alpha1.AlreadyAtTarget,
+			},
+			wantReplicas: map[string]int64{"opted-out": 2, "keep-one": 1, "frontend": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(annotation_override_objects_mock)
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate}}
+			impacted, excluded := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", tt.objects...))
+			gotImpacted := make(map[string]int)
+			for _, object := range impacted {
+				gotImpacted[object.ResourceKey] = object.OriginalCount
+			}
+			gotExcluded := make(map[string]string)
+			for _, object := range excluded {
+				gotExcluded[object.ResourceKey] = object.Reason
+			}
+			if !reflect.DeepEqual(gotImpacted, tt.wantImpacted) {
+				t.Errorf("ScaleActionFactory() impacted = %v, want %v", gotImpacted, tt.wantImpacted)
+			}
+			if !reflect.DeepEqual(gotExcluded, tt.wantExcluded) {
+				t.Errorf("ScaleActionFactory() excluded = %v, want %v", gotExcluded, tt.wantExcluded)
+			}
+			for _, object := range getMockObjects(kubectl, "dev", "Deployment", tt.objects...) {
+				j, _ := object.MarshalJSON()
+				replicas := gjson.Get(string(j), "spec.replicas").Int()
+				if replicas != tt.wantReplicas[object.GetName()] {
+					t.Errorf("ScaleActionFactory() replicas of %s = %d, want %d", object.GetName(), replicas, tt.wantReplicas[object.GetName()])
+				}
+			}
+		})
+	}
+}
+
+func TestResourceActionImpl_ResetScaleActionFactory(t *testing.T) {
+	tests := []struct {
+		name         string
+		objects      []string
+		wantImpacted []string
+	}{
+		{
+			name:         "wake priority",
+			objects:      []string{"frontend", "database"},
+			wantImpacted: []string{"/dev/apps/v1/Deployment/database", "/dev/apps/v1/Deployment/frontend"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := pkg.NewKubectlMock(annotation_override_objects_mock)
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate}}
+			impacted, _ := r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "dev", "Deployment", tt.objects...))
+			var gotImpacted []string
+			for _, object := range impacted {
+				gotImpacted = append(gotImpacted, object.ResourceKey)
+			}
+			if !reflect.DeepEqual(gotImpacted, tt.wantImpacted) {
+				t.Errorf("ResetScaleActionFactory() impacted = %v, want %v", gotImpacted, tt.wantImpacted)
+			}
+		})
+	}
+}
+
+func TestResourceActionImpl_originalReplicaStore(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(annotation_override_objects_mock)
+	r := &ResourceActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+	}
+	hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate}}
+	r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", "keep-one"))
+	wantStore := map[string]int{"/dev/apps/v1/Deployment/keep-one": 3}
+	if !reflect.DeepEqual(hibernator.Status.OriginalReplicas, wantStore) {
+		t.Errorf("ScaleActionFactory() originalReplicas = %v, want %v", hibernator.Status.OriginalReplicas, wantStore)
+	}
+	object := getMockObjects(kubectl, "dev", "Deployment", "keep-one")[0]
+	annotations := object.GetAnnotations()
+	if annotations[replicaAnnotation] != "3" || annotations[targetReplicasAnnotation] != "1" {
+		t.Errorf("ScaleActionFactory() annotations = %v, want existing annotations to be preserved", annotations)
+	}
+
+	// the annotation is lost, for example when the object is re-applied by a deployment tool
+	delete(annotations, replicaAnnotation)
+	object.SetAnnotations(annotations)
+	impacted, excluded := r.ResetScaleActionFactory(context.Background(), hibernator)([]unstructured.Unstructured{object})
+	if len(impacted) != 1 || len(excluded) != 0 {
+		t.Fatalf("ResetScaleActionFactory() impacted = %v, excluded = %v", impacted, excluded)
+	}
+	j, _ := getMockObjects(kubectl, "dev", "Deployment", "keep-one")[0].MarshalJSON()
+	if replicas := gjson.Get(string(j), "spec.replicas").Int(); replicas != 3 {
+		t.Errorf("ResetScaleActionFactory() replicas = %d, want 3", replicas)
+	}
+	if gjson.Get(string(j), "metadata.annotations").Map()[replicaAnnotation].Exists() {
+		t.Errorf("ResetScaleActionFactory() replica annotation not removed")
+	}
+	if len(hibernator.Status.OriginalReplicas) != 0 {
+		t.Errorf("ResetScaleActionFactory() originalReplicas = %v, want empty", hibernator.Status.OriginalReplicas)
+	}
+}
+
+func TestResourceActionImpl_originalReplicaStoreAlreadyAtTarget(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(annotation_override_objects_mock)
+	r := &ResourceActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+	}
+	hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate}}
+	r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", "keep-one"))
+
+	// the object is scaled back to its original count before the wake up
+	object := getMockObjects(kubectl, "dev", "Deployment", "keep-one")[0]
+	if _, err := kubectl.PatchResource(context.Background(), &pkg.PatchRequest{
+		Name:             object.GetName(),
+		Namespace:        object.GetNamespace(),
+		GroupVersionKind: object.GroupVersionKind(),
+		Patch:            `{"spec": {"replicas": 3}}`,
+		PatchType:        string(types.MergePatchType),
+	}); err != nil {
+		t.Fatalf("PatchResource() error = %v", err)
+	}
+	impacted, excluded := r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "dev", "Deployment", "keep-one"))
+	if len(impacted) != 0 || len(excluded) != 1 || excluded[0].Reason != pincherv1alpha1.AlreadyAtTarget {
+		t.Fatalf("ResetScaleActionFactory() impacted = %v, excluded = %v", impacted, excluded)
+	}
+	if _, ok := getMockObjects(kubectl, "dev", "Deployment", "keep-one")[0].GetAnnotations()[replicaAnnotation]; ok {
+		t.Errorf("ResetScaleActionFactory() replica annotation not removed")
+	}
+	if len(hibernator.Status.OriginalReplicas) != 0 {
+		t.Errorf("ResetScaleActionFactory() originalReplicas = %v, want empty", hibernator.Status.OriginalReplicas)
+	}
+}
+
+func TestResourceActionImpl_parallelActions(t *testing.T) {
+	tests := []struct {
+		name        string
+		objectCount int
+		parallelism int
+	}{
+		{name: "default parallelism", objectCount: 25, parallelism: 0},
+		{name: "bounded parallelism", objectCount: 25, parallelism: 3},
+		{name: "sequential", objectCount: 5, parallelism: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var manifests, names, wantKeys []string
+			for i := 0; i < tt.objectCount; i++ {
+				name := fmt.Sprintf("app-%02d", i)
+				manifests = append(manifests, fmt.Sprintf(`{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "%s", "namespace": "dev"}, "spec": {"replicas": %d}}`, name, i%3+1))
+				names = append(names, name)
+				wantKeys = append(wantKeys, "/dev/apps/v1/Deployment/"+name)
+			}
+			kubectl := pkg.NewKubectlMock("[" + strings.Join(manifests, ",") + "]")
+			r := &ResourceActionImpl{
+				Kubectl:     kubectl,
+				historyUtil: &HistoryImpl{},
+			}
+			hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate, Parallelism: tt.parallelism}}
+
+			impacted, _ := r.ScaleActionFactory(context.Background(), hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", names...))
+			var gotKeys []string
+			for i, object := range impacted {
+				gotKeys = append(gotKeys, object.ResourceKey)
+				if object.Status != "success" || object.OriginalCount != i%3+1 || hibernator.Status.OriginalReplicas[object.ResourceKey] != i%3+1 {
+					t.Errorf("ScaleActionFactory() impacted = %v, stored = %d", object, hibernator.Status.OriginalReplicas[object.ResourceKey])
+				}
+			}
+			if !reflect.DeepEqual(gotKeys, wantKeys) {
+				t.Errorf("ScaleActionFactory() impacted = %v, want %v", gotKeys, wantKeys)
+			}
+
+			impacted, _ = r.ResetScaleActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "dev", "Deployment", names...))
+			if len(impacted) != tt.objectCount || len(hibernator.Status.OriginalReplicas) != 0 {
+				t.Errorf("ResetScaleActionFactory() impacted = %d, remaining = %v", len(impacted), hibernator.Status.OriginalReplicas)
+			}
+
+			impacted, _ = r.DeleteActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "dev", "Deployment", names...))
+			gotKeys = nil
+			for _, object := range impacted {
+				gotKeys = append(gotKeys, object.ResourceKey)
+			}
+			if !reflect.DeepEqual(gotKeys, wantKeys) {
+				t.Errorf("DeleteActionFactory() impacted = %v, want %v", gotKeys, wantKeys)
+			}
+			if remaining, _ := kubectl.ListResources(context.Background(), &pkg.ListRequest{Namespace: "dev"}); len(remaining.Manifests) != 0 {
+				t.Errorf("DeleteActionFactory() remaining = %d, want 0", len(remaining.Manifests))
+			}
+		})
+	}
+}
+
+// cancellingKubectl cancels the context of the reconcile once the given number of patches went through
+type cancellingKubectl struct {
+	pkg.KubectlCmd
+	cancel  context.CancelFunc
+	patches int
+}
+
+func (k *cancellingKubectl) PatchResource(ctx context.Context, r *pkg.PatchRequest) (*pkg.ManifestResponse, error) {
+	resp, err := k.KubectlCmd.PatchResource(ctx, r)
+	if k.patches--; k.patches == 0 {
+		k.cancel()
+	}
+	return resp, err
+}
+
+func TestResourceActionImpl_cancelledActions(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(`[
+		{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app-0", "namespace": "dev"}, "spec": {"replicas": 1}},
+		{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app-1", "namespace": "dev"}, "spec": {"replicas": 2}},
+		{"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "app-2", "namespace": "dev"}, "spec": {"replicas": 3}}
+	]`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &ResourceActionImpl{
+		Kubectl:     &cancellingKubectl{KubectlCmd: kubectl, cancel: cancel, patches: 2},
+		historyUtil: &HistoryImpl{},
+	}
+	hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Hibernate, Parallelism: 1}}
+
+	impacted, _ := r.ScaleActionFactory(ctx, hibernator, pincherv1alpha1.NearestTimeGap{WithinRange: true})(getMockObjects(kubectl, "dev", "Deployment", "app-0", "app-1", "app-2"))
+	var gotStatus []string
+	for _, object := range impacted {
+		gotStatus = append(gotStatus, object.Status+" "+object.Message)
+	}
+	wantStatus := []string{"success ", "success ", "error " + context.Canceled.Error()}
+	if !reflect.DeepEqual(gotStatus, wantStatus) {
+		t.Errorf("ScaleActionFactory() status = %v, want %v", gotStatus, wantStatus)
+	}
+	wantStore := map[string]int{"/dev/apps/v1/Deployment/app-0": 1, "/dev/apps/v1/Deployment/app-1": 2}
+	if !reflect.DeepEqual(hibernator.Status.OriginalReplicas, wantStore) {
+		t.Errorf("ScaleActionFactory() originalReplicas = %v, want %v", hibernator.Status.OriginalReplicas, wantStore)
+	}
+	j, _ := getMockObjects(kubectl, "dev", "Deployment", "app-2")[0].MarshalJSON()
+	if replicas := gjson.Get(string(j), "spec.replicas").Int(); replicas != 3 {
+		t.Errorf("ScaleActionFactory() replicas of object not acted upon = %d, want 3", replicas)
+	}
+}
+
+func TestResourceActionImpl_deleteClusterScoped(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(cluster_scoped_objects_mock)
+	r := &ResourceActionImpl{
+		Kubectl:     kubectl,
+		historyUtil: &HistoryImpl{},
+	}
+	hibernator := &pincherv1alpha1.Hibernator{Spec: pincherv1alpha1.HibernatorSpec{Action: pincherv1alpha1.Delete}}
+	impacted, _ := r.DeleteActionFactory(context.Background(), hibernator)(getMockObjects(kubectl, "", "PersistentVolume", "pv-released"))
+	if len(impacted) != 1 || impacted[0].ResourceKey != "///v1/PersistentVolume/pv-released" || impacted[0].Status != "success" {
+		t.Errorf("DeleteActionFactory() impacted = %v", impacted)
+	}
+	if remaining := getMockObjects(kubectl, "", "PersistentVolume", "pv-released"); len(remaining[0].Object) != 0 {
+		t.Errorf("DeleteActionFactory() remaining = %v, want deleted", remaining)
+	}
+	if remaining := getMockObjects(kubectl, "", "PersistentVolume", "pv-bound"); len(remaining[0].Object) == 0 {
+		t.Errorf("DeleteActionFactory() deleted pv-bound")
+	}
+}
@@ -18,20 +18,32 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/devtron-labs/winter-soldier/pkg"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"strings"
+	"time"
 )
 
+var secretGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
 type ResourceSelector interface {
-	handleLabelSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
-	handleFieldSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
-	handleSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
-	getNamespaces(rule pincherv1alpha1.Selector, factory pkg.ArgsProcessor) ([]string, error)
-	getMatchingObjects(selectors []pincherv1alpha1.Selector) []unstructured.Unstructured
-	getIncludedExcludedObjects(inclusions, exclusions []unstructured.Unstructured) (included []unstructured.Unstructured, excluded []unstructured.Unstructured)
+	handleLabelSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
+	handleFieldSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, []expressionFailure, error)
+	handleSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
+	handleHelmReleaseSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error)
+	getNamespaces(ctx context.Context, rule pincherv1alpha1.Selector, factory pkg.ArgsProcessor) ([]string, error)
+	getMatchingObjects(ctx context.Context, selectors []pincherv1alpha1.Selector) (matches []unstructured.Unstructured, resolvedFrom map[string]string, failures []expressionFailure, err error)
+	getIncludedExcludedObjects(ctx context.Context, inclusions, exclusions []unstructured.Unstructured) (included []unstructured.Unstructured, excluded []pincherv1alpha1.ExcludedObject, keptAwake []unstructured.Unstructured)
+	getListedResources(rules []pincherv1alpha1.Rule, watched bool) []schema.GroupVersionResource
+	getWholeNamespaces(ctx context.Context, rules []pincherv1alpha1.Rule) map[string]bool
 }
 
 func NewResourceSelectorImpl(Kubectl pkg.KubectlCmd, Mapper *pkg.Mapper, factory func(mapper *pkg.Mapper) pkg.ArgsProcessor) ResourceSelector {
@@ -48,63 +60,72 @@ type ResourceSelectorImpl struct {
 	factory func(mapper *pkg.Mapper) pkg.ArgsProcessor
 }
 
-func (r *ResourceSelectorImpl) handleFieldSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
+// handleFieldSelector returns the objects for which all the field selector expressions are true along with the
+// objects for which one of them failed, which are not matched
+func (r *ResourceSelectorImpl) handleFieldSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, []expressionFailure, error) {
 	var resp []unstructured.Unstructured
 	var err error
-	if len(rule.ObjectSelector.Labels) > 0 {
-		resp, err = r.handleLabelSelector(rule)
+	if hasLabelSelector(rule.ObjectSelector) {
+		resp, err = r.handleLabelSelector(ctx, rule)
 	} else {
-		resp, err = r.handleSelector(rule)
+		resp, err = r.handleSelector(ctx, rule)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	matchedObjects, failures := r.filterByFieldSelector(ctx, resp, rule.ObjectSelector.FieldSelector)
+	return matchedObjects, failures, nil
+}
+
+// filterByFieldSelector returns the objects for which all the field selector expressions are true along with the
+// objects for which one of them failed
+func (r *ResourceSelectorImpl) filterByFieldSelector(ctx context.Context, objects []unstructured.Unstructured, fieldSelector []pincherv1alpha1.FieldExpression) ([]unstructured.Unstructured, []expressionFailure) {
+	// the related objects are looked up once for all the objects of the selector
+	lookup := pkg.NewObjectLookup(ctx, r.Kubectl)
 	var matchedObjects []unstructured.Unstructured
-	for _, r := range resp {
-		j, err := r.MarshalJSON()
+	var failures []expressionFailure
+	for _, object := range objects {
+		found, err := pkg.EvaluateRelatedExpressions(fieldSelector, object, lookup)
 		if err != nil {
+			failures = append(failures, expressionFailure{object: object, err: err})
 			continue
 		}
-		found := true
-		for _, field := range rule.ObjectSelector.FieldSelector {
-			if !pkg.ExpressionEvaluator(field, string(j)) {
-				found = false
-				break
-			}
-		}
 		if found {
-			matchedObjects = append(matchedObjects, r)
+			matchedObjects = append(matchedObjects, object)
 		}
 	}
-	return matchedObjects, nil
+	return matchedObjects, failures
 }
 
-func (r *ResourceSelectorImpl) handleLabelSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
+func (r *ResourceSelectorImpl) handleLabelSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
+	selector, err := getLabelSelector(rule.ObjectSelector.Labels, rule.ObjectSelector.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
 	factory := r.factory(r.Mapper)
 	types := strings.Split(rule.ObjectSelector.Type, ",")
-	namespaces, err := r.getNamespaces(rule, factory)
+	namespaces, err := r.getNamespaces(ctx, rule, factory)
 	if err != nil {
 		return nil, err
 	}
 	var apiResources []pkg.APIResourceInfo
-	isNamespaced := len(namespaces) != 0
-	apiResources, err = r.getResources(types, isNamespaced, factory)
+	apiResources, err = r.getResources(types, factory)
 	if err != nil {
 		return nil, err
 	}
 	var manifests []unstructured.Unstructured
 	for _, t := range apiResources {
-		for _, namespace := range namespaces {
+		for _, namespace := range namespacesFor(t, namespaces) {
 			request := &pkg.ListRequest{
 				Namespace:            namespace,
 				GroupVersionResource: t.GroupVersionResource,
 				ListOptions: metav1.ListOptions{
-					LabelSelector: strings.Join(rule.ObjectSelector.Labels, ","),
+					LabelSelector: selector.String(),
 				},
 			}
-			resp, err := r.Kubectl.ListResources(context.Background(), request)
+			resp, err := r.Kubectl.ListResources(ctx, request)
 			if err != nil {
-				continue
+				return nil, err
 			}
 			manifests = append(manifests, resp.Manifests...)
 		}
@@ -113,64 +134,175 @@ func (r *ResourceSelectorImpl) handleLabelSelector(rule pincherv1alpha1.Selector
 	return manifests, nil
 }
 
-func (r *ResourceSelectorImpl) handleSelector(rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
+func (r *ResourceSelectorImpl) handleSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
 	factory := r.factory(r.Mapper)
-	namespaces, err := r.getNamespaces(rule, factory)
+	namespaces, err := r.getNamespaces(ctx, rule, factory)
 	if err != nil {
 		return nil, err
 	}
 	var apiResources []pkg.APIResourceInfo
-	isNamespaced := len(namespaces) != 0
 	types := strings.Split(rule.ObjectSelector.Type, ",")
-	apiResources, err = r.getResources(types, isNamespaced, factory)
+	apiResources, err = r.getResources(types, factory)
 	if err != nil {
 		return nil, err
 	}
-	if len(rule.ObjectSelector.Name) > 0 {
+	if len(rule.ObjectSelector.Name) > 0 && !hasWildcard(rule.ObjectSelector.Name) {
 		names := strings.Split(rule.ObjectSelector.Name, ",")
 		var manifests []unstructured.Unstructured
 		for _, t := range apiResources {
-			for _, namespace := range namespaces {
+			for _, namespace := range namespacesFor(t, namespaces) {
 				for _, name := range names {
 					request := &pkg.GetRequest{
 						Name:             name,
 						Namespace:        namespace,
 						GroupVersionKind: t.GroupVersionKind,
 					}
-					resp, err := r.Kubectl.GetResource(context.Background(), request)
-					if err != nil {
+					resp, err := r.Kubectl.GetResource(ctx, request)
+					if errors.IsNotFound(err) {
 						continue
 					}
+					if err != nil {
+						return nil, err
+					}
 					manifests = append(manifests, resp.Manifest)
 				}
 			}
 		}
 		return manifests, nil
 	} else {
+		// names with wildcards are matched against the listed objects
+		patterns, err := parseNamePatterns(rule.ObjectSelector.Name)
+		if err != nil {
+			return nil, err
+		}
 		var manifests []unstructured.Unstructured
 		for _, t := range apiResources {
-			for _, namespace := range namespaces {
+			for _, namespace := range namespacesFor(t, namespaces) {
 				request := &pkg.ListRequest{
 					Namespace:            namespace,
 					GroupVersionResource: t.GroupVersionResource,
 					ListOptions:          metav1.ListOptions{},
 				}
-				resp, err := r.Kubectl.ListResources(context.Background(), request)
+				resp, err := r.Kubectl.ListResources(ctx, request)
 				if err != nil {
-					continue
+					return nil, err
+				}
+				for _, manifest := range resp.Manifests {
+					if len(patterns) == 0 || matchesAnyName(patterns, manifest.GetName()) {
+						manifests = append(manifests, manifest)
+					}
 				}
-				manifests = append(manifests, resp.Manifests...)
 			}
 		}
 		return manifests, nil
 	}
 }
 
-func (r *ResourceSelectorImpl) getResources(types []string, isNamespaced bool, factory pkg.ArgsProcessor) ([]pkg.APIResourceInfo, error) {
+// handleHelmReleaseSelector resolves the Helm releases of the selector into their objects of the selected types, which
+// default to the scalable workloads, matching the labels of the selector
+func (r *ResourceSelectorImpl) handleHelmReleaseSelector(ctx context.Context, rule pincherv1alpha1.Selector) ([]unstructured.Unstructured, error) {
+	selector, err := getLabelSelector(rule.ObjectSelector.Labels, rule.ObjectSelector.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	factory := r.factory(r.Mapper)
+	namespaces, err := r.getNamespaces(ctx, rule, factory)
+	if err != nil {
+		return nil, err
+	}
+	objectTypes := rule.ObjectSelector.Type
+	if len(objectTypes) == 0 {
+		objectTypes = scalableWorkloadTypes
+	}
+	apiResources, err := r.getResources(strings.Split(objectTypes, ","), factory)
+	if err != nil {
+		return nil, err
+	}
+	releases, err := r.getHelmReleases(ctx, rule.ObjectSelector.HelmRelease, namespaces)
+	if err != nil {
+		return nil, err
+	}
+	var manifests []unstructured.Unstructured
+	found := make(map[string]bool)
+	for _, t := range apiResources {
+		for _, namespace := range namespacesFor(t, namespaces) {
+			request := &pkg.ListRequest{
+				Namespace:            namespace,
+				GroupVersionResource: t.GroupVersionResource,
+				ListOptions: metav1.ListOptions{
+					LabelSelector: selector.String(),
+				},
+			}
+			resp, err := r.Kubectl.ListResources(ctx, request)
+			if err != nil {
+				return nil, err
+			}
+			for _, manifest := range resp.Manifests {
+				namespace, release, ok := getHelmRelease(manifest)
+				if !ok || !releases[namespace+"/"+release] || found[getResourceKey(manifest)] {
+					continue
+				}
+				found[getResourceKey(manifest)] = true
+				manifests = append(manifests, manifest)
+			}
+		}
+	}
+	return manifests, nil
+}
+
+// getHelmReleases returns the installed Helm releases, as <namespace>/<name>, of the comma separated releases given as
+// <name> or <namespace>/<name>. Releases given by name are looked up in each of the selected namespaces. A release is
+// installed in a namespace when its release secret sh.helm.release.v1.<name>.v<revision> is found there.
+func (r *ResourceSelectorImpl) getHelmReleases(ctx context.Context, helmReleases string, namespaces []string) (map[string]bool, error) {
+	releases := make(map[string]bool)
+	for _, release := range strings.Split(helmReleases, ",") {
+		release = strings.TrimSpace(release)
+		if len(release) == 0 {
+			continue
+		}
+		releaseNamespaces := namespaces
+		if components := strings.SplitN(release, "/", 2); len(components) == 2 {
+			releaseNamespaces, release = []string{components[0]}, components[1]
+		}
+		for _, namespace := range releaseNamespaces {
+			installed, err := r.hasHelmReleaseSecret(ctx, namespace, release)
+			if err != nil {
+				return nil, err
+			}
+			if installed {
+				releases[namespace+"/"+release] = true
+			}
+		}
+	}
+	return releases, nil
+}
+
+// hasHelmReleaseSecret reports if a release secret of the Helm release exists in the namespace, found by the labels
+// Helm sets on it
+func (r *ResourceSelectorImpl) hasHelmReleaseSecret(ctx context.Context, namespace, release string) (bool, error) {
+	resp, err := r.Kubectl.ListResources(ctx, &pkg.ListRequest{
+		Namespace:            namespace,
+		GroupVersionResource: secretGVR,
+		ListOptions:          metav1.ListOptions{LabelSelector: fmt.Sprintf("owner=helm,name=%s", release)},
+	})
+	if err != nil {
+		return false, fmt.Errorf("release secret of %s/%s: %w", namespace, release, err)
+	}
+	for _, manifest := range resp.Manifests {
+		if manifest.GetKind() == "Secret" && strings.HasPrefix(manifest.GetName(), helmReleaseSecretPrefix+release+".v") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getResources returns the resources of the types along with their scope taken from the REST mapping. Type all only
+// stands for the namespaced resources, cluster-scoped resources have to be selected by type.
+func (r *ResourceSelectorImpl) getResources(types []string, factory pkg.ArgsProcessor) ([]pkg.APIResourceInfo, error) {
 	var apiResources []pkg.APIResourceInfo
 	var err error
 	if len(types) == 1 && types[0] == "all" {
-		apiResources, err = pkg.GetAllAPIResources(isNamespaced)
+		apiResources, err = r.Mapper.GetAllAPIResources(true)
 		if err != nil {
 			return nil, err
 		}
@@ -182,7 +314,7 @@ func (r *ResourceSelectorImpl) getResources(types []string, isNamespaced bool, f
 			}
 			apiResourceInfo := pkg.APIResourceInfo{
 				GroupVersionKind:     resourceMapping.GroupVersionKind,
-				Meta:                 metav1.APIResource{},
+				Meta:                 metav1.APIResource{Namespaced: isNamespacedMapping(resourceMapping)},
 				GroupVersionResource: resourceMapping.Resource,
 			}
 			apiResources = append(apiResources, apiResourceInfo)
@@ -191,66 +323,364 @@ func (r *ResourceSelectorImpl) getResources(types []string, isNamespaced bool, f
 	return apiResources, nil
 }
 
-func (r *ResourceSelectorImpl) getNamespaces(rule pincherv1alpha1.Selector, factory pkg.ArgsProcessor) ([]string, error) {
-	var namespaces []string
-	if rule.NamespaceSelector.Name == "all" || len(rule.NamespaceSelector.Name) == 0 {
-		resourceMapping, _ := factory.MappingFor("ns")
-		listOptions := metav1.ListOptions{}
-		if len(rule.NamespaceSelector.Labels) > 0 {
-			listOptions = metav1.ListOptions{
-				LabelSelector: strings.Join(rule.ObjectSelector.Labels, ","),
-			}
-		}
-		request := &pkg.ListRequest{
-			GroupVersionResource: resourceMapping.Resource,
-			ListOptions:          listOptions,
+// isNamespacedMapping checks if the mapped resource is namespaced, mappings without a scope are taken as namespaced
+func isNamespacedMapping(mapping *meta.RESTMapping) bool {
+	return mapping.Scope == nil || mapping.Scope.Name() != meta.RESTScopeNameRoot
+}
+
+// namespacesFor returns the namespaces to look the objects of the resource up in. Cluster-scoped objects do not belong
+// to a namespace, they are looked up once without one whichever namespaces are selected.
+func namespacesFor(apiResource pkg.APIResourceInfo, namespaces []string) []string {
+	if !apiResource.Meta.Namespaced {
+		return []string{metav1.NamespaceNone}
+	}
+	return namespaces
+}
+
+// getNamespaces returns the namespaces selected by name, labels and annotations. Namespaces given by exact name are
+// used as is unless they are also selected by labels or annotations, names with wildcards are matched against the
+// listed namespaces.
+func (r *ResourceSelectorImpl) getNamespaces(ctx context.Context, rule pincherv1alpha1.Selector, factory pkg.ArgsProcessor) ([]string, error) {
+	namespaceSelector := rule.NamespaceSelector
+	var patterns []namePattern
+	if namespaceSelector.Name != "all" && len(namespaceSelector.Name) != 0 {
+		if !hasWildcard(namespaceSelector.Name) && !hasNamespaceSelector(namespaceSelector) {
+			return strings.Split(namespaceSelector.Name, ","), nil
 		}
-		resp, err := r.Kubectl.ListResources(context.Background(), request)
-		if err != nil {
+		var err error
+		if patterns, err = parseNamePatterns(namespaceSelector.Name); err != nil {
 			return nil, err
 		}
-		for _, manifest := range resp.Manifests {
+	}
+	selector, err := getLabelSelector(namespaceSelector.Labels, namespaceSelector.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := r.listNamespaces(ctx, selector.String(), factory)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err = filterByAnnotations(manifests, namespaceSelector.AnnotationSelector)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, manifest := range manifests {
+		if len(patterns) == 0 || matchesAnyName(patterns, manifest.GetName()) {
 			namespaces = append(namespaces, manifest.GetName())
 		}
-	} else {
-		namespaces = strings.Split(rule.NamespaceSelector.Name, ",")
 	}
 	return namespaces, nil
 }
 
-func (r *ResourceSelectorImpl) getMatchingObjects(selectors []pincherv1alpha1.Selector) []unstructured.Unstructured {
+// listNamespaces lists the namespaces matching the label selector, once per reconcile when the context caches them
+func (r *ResourceSelectorImpl) listNamespaces(ctx context.Context, labelSelector string, factory pkg.ArgsProcessor) ([]unstructured.Unstructured, error) {
+	cache, cached := getNamespaceCache(ctx)
+	if cached {
+		cache.lock.Lock()
+		defer cache.lock.Unlock()
+		if manifests, ok := cache.lists[labelSelector]; ok {
+			return manifests, nil
+		}
+	}
+	resourceMapping, _ := factory.MappingFor("ns")
+	request := &pkg.ListRequest{
+		GroupVersionResource: resourceMapping.Resource,
+		ListOptions: metav1.ListOptions{
+			LabelSelector: labelSelector,
+		},
+	}
+	resp, err := r.Kubectl.ListResources(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if cached {
+		cache.lists[labelSelector] = resp.Manifests
+	}
+	return resp.Manifests, nil
+}
+
+// getListedResources returns the resources listed when selecting the objects of the rules, including the resources of
+// the related objects looked up by the field selectors, to be served from the resource cache. Objects selected by
+// exact name are fetched individually and type all is not cached, unless watched is set, in which case the resources
+// of the objects selected by exact name are included, the scalable workloads for type all, so that the informers of
+// the cache see their changes for the drift watch.
+func (r *ResourceSelectorImpl) getListedResources(rules []pincherv1alpha1.Rule, watched bool) []schema.GroupVersionResource {
+	factory := r.factory(r.Mapper)
+	var resources []schema.GroupVersionResource
+	found := make(map[schema.GroupVersionResource]bool)
+	addGroupVersionResource := func(resource schema.GroupVersionResource) {
+		if found[resource] {
+			return
+		}
+		found[resource] = true
+		resources = append(resources, resource)
+	}
+	addResource := func(resourceOrKind string) {
+		resourceMapping, err := factory.MappingFor(strings.TrimSpace(resourceOrKind))
+		if err != nil {
+			return
+		}
+		addGroupVersionResource(resourceMapping.Resource)
+	}
+	for _, rule := range rules {
+		for _, selector := range append(append([]pincherv1alpha1.Selector{}, rule.Inclusions...), rule.Exclusions...) {
+			for _, field := range selector.ObjectSelector.FieldSelector {
+				for _, resource := range pkg.RelatedResources(field.Expression) {
+					addGroupVersionResource(resource)
+				}
+			}
+			namespaceSelector := selector.NamespaceSelector
+			if namespaceSelector.Name == "all" || len(namespaceSelector.Name) == 0 || hasWildcard(namespaceSelector.Name) || hasNamespaceSelector(namespaceSelector) {
+				addResource("ns")
+			}
+			objectSelector := selector.ObjectSelector
+			objectTypes := objectSelector.Type
+			if len(objectSelector.Name) != 0 && !hasWildcard(objectSelector.Name) && !hasLabelSelector(objectSelector) && len(objectSelector.HelmRelease) == 0 {
+				if !watched {
+					continue
+				}
+				if len(objectTypes) == 0 || objectTypes == "all" {
+					objectTypes = scalableWorkloadTypes
+				}
+			}
+			if len(objectTypes) == 0 && (len(objectSelector.HelmRelease) != 0 || objectSelector.TopLevelWorkloads) {
+				objectTypes = scalableWorkloadTypes
+			}
+			if len(objectTypes) == 0 || objectTypes == "all" {
+				continue
+			}
+			for _, objectType := range strings.Split(objectTypes, ",") {
+				addResource(objectType)
+			}
+		}
+	}
+	return resources
+}
+
+// getWholeNamespaces returns the namespaces all the objects of which are selected by an inclusion of the rules, one
+// selecting the objects of all the types without filtering them by name, labels, annotations, fields or Helm release.
+// Namespaces which can't be listed are left out.
+func (r *ResourceSelectorImpl) getWholeNamespaces(ctx context.Context, rules []pincherv1alpha1.Rule) map[string]bool {
+	factory := r.factory(r.Mapper)
+	wholeNamespaces := make(map[string]bool)
+	for _, rule := range rules {
+		for _, inclusion := range rule.Inclusions {
+			objectSelector := inclusion.ObjectSelector
+			if objectSelector.Type != "all" || len(objectSelector.Name) != 0 || hasLabelSelector(objectSelector) ||
+				objectSelector.AnnotationSelector != nil || len(objectSelector.FieldSelector) != 0 || len(objectSelector.HelmRelease) != 0 {
+				continue
+			}
+			namespaces, err := r.getNamespaces(ctx, inclusion, factory)
+			if err != nil {
+				continue
+			}
+			for _, namespace := range namespaces {
+				wholeNamespaces[namespace] = true
+			}
+		}
+	}
+	return wholeNamespaces
+}
+
+// getMatchingObjects returns the objects matched by the selectors along with, for objects resolved from the matched
+// objects through owner references, the resource key of the matched object keyed by resource key of the owner, and
+// the objects for which a field selector expression failed, resolved to their owner as well. The error aggregates the
+// selectors which failed to select objects, the objects of the other selectors are returned all the same.
+func (r *ResourceSelectorImpl) getMatchingObjects(ctx context.Context, selectors []pincherv1alpha1.Selector) ([]unstructured.Unstructured, map[string]string, []expressionFailure, error) {
 	var allMatches []unstructured.Unstructured
-	for _, selector := range selectors {
+	var allFailures []expressionFailure
+	var errs []error
+	resolvedFrom := make(map[string]string)
+	for i, selector := range selectors {
 		var err error
 		var matches []unstructured.Unstructured
-		if len(selector.ObjectSelector.FieldSelector) != 0 {
-			matches, err = r.handleFieldSelector(selector)
-		} else if len(selector.ObjectSelector.Labels) != 0 {
-			matches, err = r.handleLabelSelector(selector)
+		var failures []expressionFailure
+		if selector.ObjectSelector.TopLevelWorkloads && len(selector.ObjectSelector.Type) == 0 {
+			selector.ObjectSelector.Type = scalableWorkloadTypes
+		}
+		if err := validateFieldSelector(selector.ObjectSelector.FieldSelector); err != nil {
+			errs = append(errs, fmt.Errorf("selector %d: %w", i, err))
+			continue
+		}
+		if len(selector.ObjectSelector.HelmRelease) != 0 {
+			matches, err = r.handleHelmReleaseSelector(ctx, selector)
+			// the objects of the releases are narrowed down by the field selector like the objects of any type
+			if err == nil && len(selector.ObjectSelector.FieldSelector) != 0 {
+				matches, failures = r.filterByFieldSelector(ctx, matches, selector.ObjectSelector.FieldSelector)
+			}
+		} else if len(selector.ObjectSelector.FieldSelector) != 0 {
+			matches, failures, err = r.handleFieldSelector(ctx, selector)
+		} else if hasLabelSelector(selector.ObjectSelector) {
+			matches, err = r.handleLabelSelector(ctx, selector)
 		} else {
-			matches, err = r.handleSelector(selector)
+			matches, err = r.handleSelector(ctx, selector)
+		}
+		if err == nil {
+			matches, err = filterByAnnotations(matches, selector.ObjectSelector.AnnotationSelector)
 		}
+		// the other selectors are still matched, the caller decides what a failing selector means for the rule
 		if err != nil {
+			errs = append(errs, fmt.Errorf("selector %d: %w", i, err))
 			continue
 		}
+		if selector.ObjectSelector.ResolveOwners {
+			matches = r.resolveOwners(ctx, matches, resolvedFrom)
+			for i := range failures {
+				failures[i].object = r.getTopLevelOwner(ctx, failures[i].object)
+			}
+		}
+		if selector.ObjectSelector.TopLevelWorkloads {
+			matches = filterTopLevel(matches)
+		}
 		allMatches = append(allMatches, matches...)
+		allFailures = append(allFailures, failures...)
+	}
+	return allMatches, resolvedFrom, allFailures, utilerrors.NewAggregate(errs)
+}
+
+// validateFieldSelector compiles the field selector expressions before selecting any object, so that an expression
+// which can't compile fails its selector even when there is no object to evaluate it against
+func validateFieldSelector(fieldSelector []pincherv1alpha1.FieldExpression) error {
+	var errs []error
+	for _, field := range fieldSelector {
+		if err := pkg.ValidateExpression(field); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return allMatches
+	return utilerrors.NewAggregate(errs)
 }
 
-func (r *ResourceSelectorImpl) getIncludedExcludedObjects(inclusions, exclusions []unstructured.Unstructured) (included []unstructured.Unstructured, excluded []unstructured.Unstructured) {
+// resolveOwners replaces objects managed by a controller with their top level controller, which would otherwise
+// re-create or revert them, removing duplicates
+func (r *ResourceSelectorImpl) resolveOwners(ctx context.Context, objects []unstructured.Unstructured, resolvedFrom map[string]string) []unstructured.Unstructured {
+	var resolved []unstructured.Unstructured
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		owner := r.getTopLevelOwner(ctx, object)
+		ownerKey := getResourceKey(owner)
+		if seen[ownerKey] {
+			continue
+		}
+		seen[ownerKey] = true
+		if objectKey := getResourceKey(object); objectKey != ownerKey {
+			resolvedFrom[ownerKey] = objectKey
+		}
+		resolved = append(resolved, owner)
+	}
+	return resolved
+}
+
+func (r *ResourceSelectorImpl) getTopLevelOwner(ctx context.Context, object unstructured.Unstructured) unstructured.Unstructured {
+	for depth := 0; depth < maxOwnerReferenceDepth; depth++ {
+		ownerReference := metav1.GetControllerOf(&object)
+		if ownerReference == nil {
+			return object
+		}
+		groupVersion, err := schema.ParseGroupVersion(ownerReference.APIVersion)
+		if err != nil {
+			return object
+		}
+		request := &pkg.GetRequest{
+			Name:             ownerReference.Name,
+			Namespace:        object.GetNamespace(),
+			GroupVersionKind: groupVersion.WithKind(ownerReference.Kind),
+		}
+		resp, err := r.Kubectl.GetResource(ctx, request)
+		if err != nil || resp.Manifest.GetName() == "" {
+			return object
+		}
+		object = resp.Manifest
+	}
+	return object
+}
+
+func filterTopLevel(objects []unstructured.Unstructured) []unstructured.Unstructured {
+	var topLevel []unstructured.Unstructured
+	for _, object := range objects {
+		if metav1.GetControllerOf(&object) == nil {
+			topLevel = append(topLevel, object)
+		}
+	}
+	return topLevel
+}
+
+// getIncludedExcludedObjects splits inclusions into objects to act upon and excluded objects. Objects which have
+// opted out through the exclude annotation, or which themselves or whose namespace is annotated with
+// keep-awake-until in the future, are excluded and also returned as keptAwake so that they can be woken up if
+// already hibernated.
+func (r *ResourceSelectorImpl) getIncludedExcludedObjects(ctx context.Context, inclusions, exclusions []unstructured.Unstructured) (included []unstructured.Unstructured, excluded []pincherv1alpha1.ExcludedObject, keptAwake []unstructured.Unstructured) {
+	now := time.Now()
 	excludedKey := map[string]bool{}
 	for _, exclusion := range exclusions {
 		key := pkg.GetResourceKey(&exclusion)
 		excludedKey[key.String()] = true
 	}
+	namespaceKeepAwake := map[string]*time.Time{}
 	for _, inclusion := range inclusions {
 		key := pkg.GetResourceKey(&inclusion)
 		if excludedKey[key.String()] {
-			excluded = append(excluded, inclusion)
-		} else {
-			included = append(included, inclusion)
+			excluded = append(excluded, newExcludedObject(inclusion, pincherv1alpha1.ExcludedByRule, ""))
+			continue
+		}
+		if isOptedOut(inclusion) {
+			excluded = append(excluded, newExcludedObject(inclusion, pincherv1alpha1.OptedOut, "opted out through annotation"))
+			keptAwake = append(keptAwake, inclusion)
+			continue
+		}
+		if until := r.getKeepAwakeUntil(ctx, inclusion, namespaceKeepAwake, now); until != nil {
+			excluded = append(excluded, newExcludedObject(inclusion, pincherv1alpha1.OptedOut, fmt.Sprintf("keep awake until %s", until.Format(time.RFC3339))))
+			keptAwake = append(keptAwake, inclusion)
+			continue
+		}
+		included = append(included, inclusion)
+	}
+	return included, excluded, keptAwake
+}
+
+func (r *ResourceSelectorImpl) getKeepAwakeUntil(ctx context.Context, obj unstructured.Unstructured, namespaceKeepAwake map[string]*time.Time, now time.Time) *time.Time {
+	if until := r.activeKeepAwakeUntil(ctx, obj, now); until != nil {
+		return until
+	}
+	namespace := obj.GetNamespace()
+	if len(namespace) == 0 {
+		return nil
+	}
+	until, ok := namespaceKeepAwake[namespace]
+	if !ok {
+		request := &pkg.GetRequest{
+			Name:             namespace,
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "Namespace"},
 		}
+		resp, err := r.Kubectl.GetResource(ctx, request)
+		if err == nil {
+			until = r.activeKeepAwakeUntil(ctx, resp.Manifest, now)
+		}
+		namespaceKeepAwake[namespace] = until
+	}
+	return until
+}
+
+// activeKeepAwakeUntil returns the keep-awake-until time of obj if it lies in the future, expired annotations are removed
+func (r *ResourceSelectorImpl) activeKeepAwakeUntil(ctx context.Context, obj unstructured.Unstructured, now time.Time) *time.Time {
+	value, ok := obj.GetAnnotations()[keepAwakeUntilAnnotation]
+	if !ok {
+		return nil
+	}
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	if until.After(now) {
+		return &until
+	}
+	request := &pkg.PatchRequest{
+		Name:             obj.GetName(),
+		Namespace:        obj.GetNamespace(),
+		GroupVersionKind: obj.GroupVersionKind(),
+		Patch:            fmt.Sprintf(removeAnnotationPatch, escapeJSONPointer(keepAwakeUntilAnnotation)),
+		PatchType:        string(types.JSONPatchType),
 	}
-	return included, excluded
+	_, _ = r.Kubectl.PatchResource(ctx, request)
+	return nil
 }
@@ -17,10 +17,16 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"fmt"
 	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/devtron-labs/winter-soldier/pkg"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"reflect"
+	"sort"
+	"strings"
 	"testing"
 )
 
@@ -71,7 +77,7 @@ func TestHibernatorReconciler_handleLabelSelector(t *testing.T) {
 				Mapper:  tt.fields.mapper,
 				factory: tt.fields.factory,
 			}
-			got, err := r.handleLabelSelector(tt.args.rule)
+			got, err := r.handleLabelSelector(context.Background(), tt.args.rule)
 			fmt.Println(got)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("handleLabelSelector() error = %v, wantErr %v", err, tt.wantErr)
@@ -112,11 +118,12 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 		rule v1alpha1.Selector
 	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    []*unstructured.Unstructured
-		wantErr bool
+		name       string
+		fields     fields
+		args       args
+		want       []*unstructured.Unstructured
+		wantFailed int
+		wantErr    bool
 	}{
 		{
 			name: "label with field selector",
@@ -130,7 +137,7 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 					Labels:        []string{"app=nginx"},
 					Name:          "",
 					Type:          "deployment",
-					FieldSelector: []string{"{{spec.replicas}}==3"},
+					FieldSelector: []v1alpha1.FieldExpression{{Expression: "{{spec.replicas}}==3"}},
 				},
 				NamespaceSelector: v1alpha1.NamespaceSelector{
 					Name: "pras",
@@ -153,7 +160,7 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 					Labels:        []string{},
 					Name:          "",
 					Type:          "deployment",
-					FieldSelector: []string{"{{spec.replicas}}==3"},
+					FieldSelector: []v1alpha1.FieldExpression{{Expression: "{{spec.replicas}}==3"}},
 				},
 				NamespaceSelector: v1alpha1.NamespaceSelector{
 					Name: "pras",
@@ -165,6 +172,25 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "failing field selector",
+			fields: fields{
+				kubectl: pkg.NewKubectlMock(pkg.DeploymentObjectsMock),
+				mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			},
+			args: args{rule: v1alpha1.Selector{
+				ObjectSelector: v1alpha1.ObjectSelector{
+					Labels:        []string{"app=nginx"},
+					Type:          "deployment",
+					FieldSelector: []v1alpha1.FieldExpression{{Expression: "{{spec.replicas}} > {{metadata.name}}"}},
+				},
+				NamespaceSelector: v1alpha1.NamespaceSelector{
+					Name: "pras",
+				},
+			}},
+			wantFailed: 1,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -173,11 +199,14 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 				Mapper:  tt.fields.mapper,
 				factory: tt.fields.factory,
 			}
-			got, err := r.handleFieldSelector(tt.args.rule)
+			got, failed, err := r.handleFieldSelector(context.Background(), tt.args.rule)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("handleFieldSelector() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if len(failed) != tt.wantFailed {
+				t.Errorf("handleFieldSelector() failed = %v, want %d", failed, tt.wantFailed)
+			}
 			if len(got) != len(tt.want) {
 				t.Errorf("handleFieldSelector() got = %v, want %v", got, tt.want)
 			}
@@ -197,3 +226,619 @@ func TestHibernatorReconciler_handleFieldSelector(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceSelectorImpl_getIncludedExcludedObjects(t *testing.T) {
+	kubectl := pkg.NewKubectlMock(keep_awake_objects_mock)
+	objects := func(names ...string) []unstructured.Unstructured {
+		var result []unstructured.Unstructured
+		for _, name := range names {
+			parts := strings.Split(name, "/")
+			resp, _ := kubectl.GetResource(context.Background(), &pkg.GetRequest{
+				Name:             parts[1],
+				Namespace:        parts[0],
+				GroupVersionKind: schema.GroupVersionKind{Kind: "Deployment"},
+			})
+			result = append(result, resp.Manifest)
+		}
+		return result
+	}
+	type args struct {
+		inclusions []unstructured.Unstructured
+		exclusions []unstructured.Unstructured
+	}
+	tests := []struct {
+		name          string
+		args          args
+		wantIncluded  []string
+		wantExcluded  []string
+		wantKeptAwake []string
+	}{
+		{
+			name: "object and namespace keep awake",
+			args: args{
+				inclusions: objects("dev/late-night", "dev/expired", "dev/plain", "qa/qa-app"),
+				exclusions: objects("dev/plain"),
+			},
+			wantIncluded:  []string{"expired"},
+			wantExcluded:  []string{"late-night", "plain", "qa-app"},
+			wantKeptAwake: []string{"late-night", "qa-app"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: kubectl,
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			included, excluded, keptAwake := r.getIncludedExcludedObjects(context.Background(), tt.args.inclusions, tt.args.exclusions)
+			var gotIncluded, gotExcluded, gotKeptAwake []string
+			for _, object := range included {
+				gotIncluded = append(gotIncluded, object.GetName())
+			}
+			for _, object := range excluded {
+				_, _, _, _, name := componentsOfResourceKey(object.ResourceKey)
+				gotExcluded = append(gotExcluded, name)
+				if name == "plain" && object.Reason != v1alpha1.ExcludedByRule {
+					t.Errorf("getIncludedExcludedObjects() reason = %s for %s", object.Reason, name)
+				}
+				if name != "plain" && (object.Reason != v1alpha1.OptedOut || !strings.HasPrefix(object.Message, "keep awake until")) {
+					t.Errorf("getIncludedExcludedObjects() reason = %s, message = %s for %s", object.Reason, object.Message, name)
+				}
+			}
+			for _, object := range keptAwake {
+				gotKeptAwake = append(gotKeptAwake, object.GetName())
+			}
+			if !reflect.DeepEqual(gotIncluded, tt.wantIncluded) {
+				t.Errorf("getIncludedExcludedObjects() included = %v, want %v", gotIncluded, tt.wantIncluded)
+			}
+			if !reflect.DeepEqual(gotExcluded, tt.wantExcluded) {
+				t.Errorf("getIncludedExcludedObjects() excluded = %v, want %v", gotExcluded, tt.wantExcluded)
+			}
+			if !reflect.DeepEqual(gotKeptAwake, tt.wantKeptAwake) {
+				t.Errorf("getIncludedExcludedObjects() keptAwake = %v, want %v", gotKeptAwake, tt.wantKeptAwake)
+			}
+			resp, _ := kubectl.GetResource(context.Background(), &pkg.GetRequest{
+				Name:             "expired",
+				Namespace:        "dev",
+				GroupVersionKind: schema.GroupVersionKind{Kind: "Deployment"},
+			})
+			if _, ok := resp.Manifest.GetAnnotations()[keepAwakeUntilAnnotation]; ok {
+				t.Errorf("getIncludedExcludedObjects() expired keep awake annotation not removed")
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_getMatchingObjects(t *testing.T) {
+	tests := []struct {
+		name             string
+		selector         v1alpha1.Selector
+		wantMatches      []string
+		wantResolvedFrom map[string]string
+	}{
+		{
+			name: "resolve pods to deployment",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Labels: []string{"app=web"}, Type: "pod", ResolveOwners: true},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"},
+			},
+			wantMatches: []string{"/apps//v1/Pod/debug", "/apps/apps/v1/Deployment/web"},
+			wantResolvedFrom: map[string]string{
+				"/apps/apps/v1/Deployment/web": "/apps//v1/Pod/web-5d8f-abc",
+			},
+		},
+		{
+			name: "top level workloads of namespace",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", TopLevelWorkloads: true},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"},
+			},
+			wantMatches:      []string{"/apps//v1/Pod/debug", "/apps/apps/v1/Deployment/web"},
+			wantResolvedFrom: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(owner_reference_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			matches, resolvedFrom, _, _ := r.getMatchingObjects(context.Background(), []v1alpha1.Selector{tt.selector})
+			var gotMatches []string
+			for _, match := range matches {
+				gotMatches = append(gotMatches, getResourceKey(match))
+			}
+			sort.Strings(gotMatches)
+			if !reflect.DeepEqual(gotMatches, tt.wantMatches) {
+				t.Errorf("getMatchingObjects() matches = %v, want %v", gotMatches, tt.wantMatches)
+			}
+			// either of the pods of the replica set can be the one resolved first
+			for owner, from := range resolvedFrom {
+				if strings.HasPrefix(from, "/apps//v1/Pod/web-5d8f-") {
+					resolvedFrom[owner] = tt.wantResolvedFrom[owner]
+				}
+			}
+			if !reflect.DeepEqual(resolvedFrom, tt.wantResolvedFrom) {
+				t.Errorf("getMatchingObjects() resolvedFrom = %v, want %v", resolvedFrom, tt.wantResolvedFrom)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_getListedResources(t *testing.T) {
+	tests := []struct {
+		name          string
+		rules         []v1alpha1.Rule
+		watched       bool
+		wantResources []string
+	}{
+		{
+			name: "types of listing selectors",
+			rules: []v1alpha1.Rule{{
+				Inclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Labels: []string{"app=web"}, Type: "deployment,statefulset"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+					{ObjectSelector: v1alpha1.ObjectSelector{HelmRelease: "web"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+				},
+				Exclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Type: "pod"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "all"}},
+				},
+			}},
+			wantResources: []string{"deployment", "statefulset", "rollout", "ns", "pod"},
+		},
+		{
+			name: "selection by name and type all",
+			rules: []v1alpha1.Rule{{
+				Inclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "web", Type: "deployment"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+					{ObjectSelector: v1alpha1.ObjectSelector{Type: "all"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+				},
+			}},
+			wantResources: nil,
+		},
+		{
+			name: "selection by name patterns",
+			rules: []v1alpha1.Rule{{
+				Inclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "web-*", Type: "deployment"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "web", Type: "statefulset"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "/qa-.*/"}},
+				},
+			}},
+			wantResources: []string{"deployment", "ns"},
+		},
+		{
+			name: "related objects of field selectors",
+			rules: []v1alpha1.Rule{{
+				Inclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "web", Type: "service", FieldSelector: []v1alpha1.FieldExpression{{Expression: "EndpointAddresses() == 0"}}}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+				},
+			}},
+			wantResources: []string{"endpoints"},
+		},
+		{
+			name: "selection by name watched for drift",
+			rules: []v1alpha1.Rule{{
+				Inclusions: []v1alpha1.Selector{
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "web", Type: "statefulset"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+					{ObjectSelector: v1alpha1.ObjectSelector{Name: "api", Type: "all"}, NamespaceSelector: v1alpha1.NamespaceSelector{Name: "apps"}},
+				},
+			}},
+			watched:       true,
+			wantResources: []string{"statefulset", "deployment", "rollout"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(owner_reference_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			var gotResources []string
+			for _, resource := range r.getListedResources(tt.rules, tt.watched) {
+				gotResources = append(gotResources, resource.Resource)
+			}
+			if !reflect.DeepEqual(gotResources, tt.wantResources) {
+				t.Errorf("getListedResources() = %v, want %v", gotResources, tt.wantResources)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_getNamespaces(t *testing.T) {
+	tests := []struct {
+		name              string
+		namespaceSelector v1alpha1.NamespaceSelector
+		objectLabels      []string
+		want              []string
+	}{
+		{
+			name:              "all namespaces",
+			namespaceSelector: v1alpha1.NamespaceSelector{Name: "all"},
+			want:              []string{"dev", "prod", "qa"},
+		},
+		{
+			name:              "namespaces by name",
+			namespaceSelector: v1alpha1.NamespaceSelector{Name: "dev,missing"},
+			want:              []string{"dev", "missing"},
+		},
+		{
+			name:              "labels of objects do not filter namespaces",
+			namespaceSelector: v1alpha1.NamespaceSelector{},
+			objectLabels:      []string{"app=web"},
+			want:              []string{"dev", "prod", "qa"},
+		},
+		{
+			name:              "comma separated labels",
+			namespaceSelector: v1alpha1.NamespaceSelector{Labels: []string{"env=qa"}},
+			want:              []string{"qa"},
+		},
+		{
+			name: "match expressions",
+			namespaceSelector: v1alpha1.NamespaceSelector{LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: metav1.LabelSelectorOpNotIn, Values: []string{"prod"}}},
+			}},
+			want: []string{"dev", "qa"},
+		},
+		{
+			name: "labels combined with match labels and expressions",
+			namespaceSelector: v1alpha1.NamespaceSelector{Labels: []string{"env in (dev,prod)"}, LabelSelector: &metav1.LabelSelector{
+				MatchLabels:      map[string]string{"team": "payments"},
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"prod", "qa"}}},
+			}},
+			want: []string{"prod"},
+		},
+		{
+			name: "names filtered by labels",
+			namespaceSelector: v1alpha1.NamespaceSelector{Name: "dev,qa", LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: metav1.LabelSelectorOpExists}},
+			}},
+			want: []string{"dev"},
+		},
+		{
+			name: "annotations",
+			namespaceSelector: v1alpha1.NamespaceSelector{AnnotationSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"owner": "platform"},
+			}},
+			want: []string{"dev"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(label_selector_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			rule := v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Labels: tt.objectLabels},
+				NamespaceSelector: tt.namespaceSelector,
+			}
+			got, err := r.getNamespaces(context.Background(), rule, r.factory(r.Mapper))
+			if err != nil {
+				t.Fatalf("getNamespaces() error = %v", err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getNamespaces() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_getWholeNamespaces(t *testing.T) {
+	tests := []struct {
+		name           string
+		objectSelector v1alpha1.ObjectSelector
+		namespaces     string
+		want           map[string]bool
+	}{
+		{
+			name:           "all types",
+			objectSelector: v1alpha1.ObjectSelector{Type: "all"},
+			namespaces:     "dev,qa",
+			want:           map[string]bool{"dev": true, "qa": true},
+		},
+		{
+			name:           "one type",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment"},
+			namespaces:     "dev",
+			want:           map[string]bool{},
+		},
+		{
+			name:           "all types by name",
+			objectSelector: v1alpha1.ObjectSelector{Type: "all", Name: "web"},
+			namespaces:     "dev",
+			want:           map[string]bool{},
+		},
+		{
+			name:           "all types by labels",
+			objectSelector: v1alpha1.ObjectSelector{Type: "all", Labels: []string{"app=web"}},
+			namespaces:     "dev",
+			want:           map[string]bool{},
+		},
+		{
+			name:           "all types by field",
+			objectSelector: v1alpha1.ObjectSelector{Type: "all", FieldSelector: []v1alpha1.FieldExpression{{Expression: "true"}}},
+			namespaces:     "dev",
+			want:           map[string]bool{},
+		},
+		{
+			name:           "all types of a helm release",
+			objectSelector: v1alpha1.ObjectSelector{Type: "all", HelmRelease: "web"},
+			namespaces:     "dev",
+			want:           map[string]bool{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(label_selector_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			rules := []v1alpha1.Rule{{Inclusions: []v1alpha1.Selector{{
+				ObjectSelector:    tt.objectSelector,
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: tt.namespaces},
+			}}}}
+			if got := r.getWholeNamespaces(context.Background(), rules); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getWholeNamespaces() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_getMatchingObjectsBySelectors(t *testing.T) {
+	tests := []struct {
+		name           string
+		objectSelector v1alpha1.ObjectSelector
+		want           []string
+		wantErr        bool
+	}{
+		{
+			name: "match expressions",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"frontend", "cache"}}},
+			}},
+			want: []string{"/dev/apps/v1/Deployment/web", "/qa/apps/v1/Deployment/web"},
+		},
+		{
+			name: "labels and match labels",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", Labels: []string{"tier!=frontend"}, LabelSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": "api"},
+			}},
+			want: []string{"/dev/apps/v1/Deployment/api"},
+		},
+		{
+			name: "annotations",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", AnnotationSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "hibernate", Operator: metav1.LabelSelectorOpExists}},
+			}},
+			want: []string{"/dev/apps/v1/Deployment/web"},
+		},
+		{
+			name: "invalid selector",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", LabelSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: "Like"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "invalid annotation selector",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", AnnotationSelector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"hibernate": "not a value"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:           "invalid name pattern",
+			objectSelector: v1alpha1.ObjectSelector{Type: "deployment", Name: "/web-(/"},
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(label_selector_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			selector := v1alpha1.Selector{
+				ObjectSelector: tt.objectSelector,
+				NamespaceSelector: v1alpha1.NamespaceSelector{LabelSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "env", Operator: metav1.LabelSelectorOpIn, Values: []string{"dev", "qa"}}},
+				}},
+			}
+			matches, _, _, err := r.getMatchingObjects(context.Background(), []v1alpha1.Selector{selector})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("getMatchingObjects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, match := range matches {
+				got = append(got, getResourceKey(match))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMatchingObjects() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_clusterScopedResources(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector v1alpha1.Selector
+		want     []string
+	}{
+		{
+			name: "by name",
+			selector: v1alpha1.Selector{
+				ObjectSelector: v1alpha1.ObjectSelector{Type: "persistentvolume", Name: "pv-bound"},
+			},
+			want: []string{"///v1/PersistentVolume/pv-bound"},
+		},
+		{
+			name: "by name pattern whichever namespaces are selected",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "pv", Name: "pv-*"},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "dev"},
+			},
+			want: []string{"///v1/PersistentVolume/pv-bound", "///v1/PersistentVolume/pv-other", "///v1/PersistentVolume/pv-released"},
+		},
+		{
+			name: "by field selector",
+			selector: v1alpha1.Selector{
+				ObjectSelector: v1alpha1.ObjectSelector{Type: "persistentvolume", FieldSelector: []v1alpha1.FieldExpression{{Expression: "{{status.phase}} == 'Released'"}}},
+			},
+			want: []string{"///v1/PersistentVolume/pv-other", "///v1/PersistentVolume/pv-released"},
+		},
+		{
+			name: "mixed scopes by labels",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment,persistentvolume", Labels: []string{"team=dev"}},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "dev"},
+			},
+			want: []string{"///v1/PersistentVolume/pv-bound", "///v1/PersistentVolume/pv-released", "/dev/apps/v1/Deployment/web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(cluster_scoped_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			matches, _, _, _ := r.getMatchingObjects(context.Background(), []v1alpha1.Selector{tt.selector})
+			var got []string
+			for _, match := range matches {
+				got = append(got, getResourceKey(match))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMatchingObjects() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_handleHelmReleaseSelector(t *testing.T) {
+	tests := []struct {
+		name           string
+		helmRelease    string
+		namespace      string
+		objectSelector v1alpha1.ObjectSelector
+		want           []string
+	}{
+		{
+			name:        "release in the selected namespace",
+			helmRelease: "foo",
+			namespace:   "shop",
+			want:        []string{"/shop/apps/v1/Deployment/api", "/shop/apps/v1/Deployment/cache", "/shop/apps/v1/Deployment/web", "/shop/apps/v1/Deployment/worker"},
+		},
+		{
+			name:        "release of the same name in another namespace",
+			helmRelease: "analytics/foo",
+			namespace:   "shop",
+			want:        []string{"/shop/apps/v1/Deployment/reporting"},
+		},
+		{
+			name:        "release without a release secret",
+			helmRelease: "bar",
+			namespace:   "shop",
+			want:        nil,
+		},
+		{
+			name:           "release objects matching labels",
+			helmRelease:    "foo",
+			namespace:      "shop",
+			objectSelector: v1alpha1.ObjectSelector{Labels: []string{"app.kubernetes.io/instance=foo"}},
+			want:           []string{"/shop/apps/v1/Deployment/api"},
+		},
+		{
+			name:           "release objects matching a field selector",
+			helmRelease:    "foo",
+			namespace:      "shop",
+			objectSelector: v1alpha1.ObjectSelector{FieldSelector: []v1alpha1.FieldExpression{{Expression: "{{spec.replicas}} > 1"}}},
+			want:           []string{"/shop/apps/v1/Deployment/web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(helm_release_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			objectSelector := tt.objectSelector
+			objectSelector.HelmRelease, objectSelector.Type = tt.helmRelease, "deployment"
+			matches, _, failures, err := r.getMatchingObjects(context.Background(), []v1alpha1.Selector{{
+				ObjectSelector:    objectSelector,
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: tt.namespace},
+			}})
+			if err != nil || len(failures) != 0 {
+				t.Fatalf("getMatchingObjects() error = %v, failures = %v", err, failures)
+			}
+			var got []string
+			for _, match := range matches {
+				got = append(got, getResourceKey(match))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMatchingObjects() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResourceSelectorImpl_relatedObjects(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector v1alpha1.Selector
+		want     []string
+	}{
+		{
+			name: "services without endpoints",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "service", FieldSelector: []v1alpha1.FieldExpression{{Expression: "EndpointAddresses() == 0"}}},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "network"},
+			},
+			want: []string{"/network//v1/Service/idle"},
+		},
+		{
+			name: "claims not mounted by any pod",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "persistentvolumeclaim", FieldSelector: []v1alpha1.FieldExpression{{Expression: "len(ReferencingPods()) == 0"}}},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "storage"},
+			},
+			want: []string{"/storage//v1/PersistentVolumeClaim/orphan"},
+		},
+		{
+			name: "deployments of namespaces labelled env=qa",
+			selector: v1alpha1.Selector{
+				ObjectSelector:    v1alpha1.ObjectSelector{Type: "deployment", FieldSelector: []v1alpha1.FieldExpression{{Expression: "NamespaceLabels().env == 'qa'"}}},
+				NamespaceSelector: v1alpha1.NamespaceSelector{Name: "dev,qa"},
+			},
+			want: []string{"/qa/apps/v1/Deployment/web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ResourceSelectorImpl{
+				Kubectl: pkg.NewKubectlMock(related_objects_mock),
+				Mapper:  pkg.NewMockMapperFactory(),
+				factory: pkg.NewMockFactory,
+			}
+			matches, _, _, _ := r.getMatchingObjects(context.Background(), []v1alpha1.Selector{tt.selector})
+			var got []string
+			for _, match := range matches {
+				got = append(got, getResourceKey(match))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getMatchingObjects() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
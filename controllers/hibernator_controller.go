@@ -19,23 +19,43 @@ package controllers
 import (
 	"context"
 	"github.com/devtron-labs/winter-soldier/pkg"
+	"reflect"
 	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 
 	pincherv1alpha1 "github.com/devtron-labs/winter-soldier/api/v1alpha1"
 )
 
 const (
-	layout                       = "Jan 2, 2006 3:04pm"
-	replicaPatch                 = `[{"op": "replace", "path": "/spec/replicas", "value":%d}]`
-	replicaAndAnnotationPatch    = `[{"op": "replace", "path": "/spec/replicas", "value":%d}, {"op": "add", "path": "/metadata/annotations", "value": {"%s":"%s"}}]`
-	replicaAnnotation            = `hibernator.devtron.ai/replicas`
-	minReplicaPatch              = `[{"op": "replace", "path": "/spec/minReplicas", "value":%d}]`
-	minReplicaAndAnnotationPatch = `[{"op": "replace", "path": "/spec/minReplicas", "value":%d}, {"op": "add", "path": "/metadata/annotations", "value": {"%s":"%s"}}]`
+	layout                         = "Jan 2, 2006 3:04pm"
+	replicaAndAnnotationPatch      = `{"spec": {"replicas": %d}, "metadata": {"annotations": {"%s": "%s"}}}`
+	replicaAnnotation              = `hibernator.devtron.ai/replicas`
+	minReplicaAndAnnotationPatch   = `{"spec": {"minReplicas": %d}, "metadata": {"annotations": {"%s": "%s"}}}`
+	resetReplicaPatch              = `{"spec": {"replicas": %d}, "metadata": {"annotations": {"%s": null}}}`
+	resetMinReplicaPatch           = `{"spec": {"minReplicas": %d}, "metadata": {"annotations": {"%s": null}}}`
+	removeAnnotationPatch          = `[{"op": "remove", "path": "/metadata/annotations/%s"}]`
+	removeAnnotationMergePatch     = `{"metadata": {"annotations": {"%s": null}}}`
+	keepAwakeUntilAnnotation       = `hibernator.devtron.ai/keep-awake-until`
+	excludeAnnotation              = `hibernator.devtron.ai/exclude`
+	targetReplicasAnnotation       = `hibernator.devtron.ai/target-replicas`
+	wakePriorityAnnotation         = `hibernator.devtron.ai/wake-priority`
+	helmReleaseNameAnnotation      = `meta.helm.sh/release-name`
+	helmReleaseNamespaceAnnotation = `meta.helm.sh/release-namespace`
+	helmReleaseSecretPrefix        = `sh.helm.release.v1.`
+	scalableWorkloadTypes          = `deployment,statefulset,rollout`
+	maxOwnerReferenceDepth         = 10
+	defaultParallelism             = 10
+	defaultReconcileTimeout        = 5 * time.Minute
+	statusUpdateTimeout            = 30 * time.Second
 )
 
 // HibernatorReconciler reconciles a Hibernator object
@@ -47,6 +67,18 @@ type HibernatorReconciler struct {
 	Mapper           *pkg.Mapper
 	HibernatorAction HibernatorAction
 	TimeUtil         TimeUtil
+	// ResourceCache serves the lists of objects selected by the hibernators, it is optional
+	ResourceCache    pkg.CachedKubectl
+	ResourceSelector ResourceSelector
+	// MaxConcurrentReconciles is the maximum number of hibernators reconciled concurrently, defaults to 1
+	MaxConcurrentReconciles int
+	// ReconcileTimeout bounds a single reconcile of a hibernator, defaults to defaultReconcileTimeout
+	ReconcileTimeout time.Duration
+	// DriftWatch requests the reconciliation of hibernators whose hibernated objects changed, it is optional
+	DriftWatch *DriftWatch
+	// Activator routes the services of hibernated hibernators to itself and requests the reconciliation of the
+	// hibernators whose services are requested, it is optional
+	Activator *Activator
 }
 
 // +kubebuilder:rbac:groups=pincher.devtron.ai,resources=hibernators,verbs=get;list;watch;create;update;patch;delete
@@ -60,19 +92,42 @@ func (r *HibernatorReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	//r.Client.Get()
 	hibernator := pincherv1alpha1.Hibernator{}
 	err := r.Client.Get(ctx, req.NamespacedName, &hibernator)
+	if errors.IsNotFound(err) && (r.ResourceCache != nil || r.DriftWatch != nil || r.Activator != nil) {
+		if r.ResourceCache != nil {
+			r.ResourceCache.Release(req.NamespacedName.String())
+		}
+		if r.DriftWatch != nil {
+			r.DriftWatch.Release(req.NamespacedName)
+		}
+		if r.Activator != nil {
+			r.Activator.Release(ctx, req.NamespacedName)
+		}
+		return ctrl.Result{}, nil
+	}
 	if err != nil {
 		log.Error(err, "error while fetching hibernator")
 		return ctrl.Result{}, err
 	}
 	log.Info("initiate processing")
 
-	return r.process(hibernator)
+	reconcileTimeout := r.ReconcileTimeout
+	if reconcileTimeout <= 0 {
+		reconcileTimeout = defaultReconcileTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, reconcileTimeout)
+	defer cancel()
+	return r.process(ctx, hibernator)
 }
 
-func (r *HibernatorReconciler) process(hibernator pincherv1alpha1.Hibernator) (ctrl.Result, error) {
+func (r *HibernatorReconciler) process(ctx context.Context, hibernator pincherv1alpha1.Hibernator) (ctrl.Result, error) {
 	log := r.Log.WithValues("hibernator", getNamespacedName(&hibernator))
 	now := time.Now()
 
+	drifted := false
+	if r.DriftWatch != nil {
+		drifted = r.DriftWatch.Drifted(types.NamespacedName{Namespace: hibernator.Namespace, Name: hibernator.Name})
+		r.DriftWatch.Update(&hibernator)
+	}
 	diff, err := r.TimeUtil.getPauseUntilDuration(&hibernator, now)
 	if err != nil {
 		log.Error(err, "continue processing as error parsing pause until %s", hibernator.Spec.PauseUntil.DateTime)
@@ -85,6 +140,13 @@ func (r *HibernatorReconciler) process(hibernator pincherv1alpha1.Hibernator) (c
 		return ctrl.Result{}, nil
 	}
 
+	// a request to a routed service wakes up the hibernator right away, unless it is paused
+	wokenOnRequest := false
+	if r.Activator != nil && r.Activator.WakeRequested(types.NamespacedName{Namespace: hibernator.Namespace, Name: hibernator.Name}) && hibernator.Spec.WakeOnRequest != nil {
+		hibernator.Status.WokenOnRequest = &metav1.Time{Time: now}
+		wokenOnRequest = true
+	}
+
 	//TODO: calculation may be different for delete
 	timeRangeWithZone := hibernator.Spec.When
 	nearestTimeGap, err := timeRangeWithZone.NearestTimeGapInSeconds(now)
@@ -103,42 +165,74 @@ func (r *HibernatorReconciler) process(hibernator pincherv1alpha1.Hibernator) (c
 	requeueTime := r.TimeUtil.getRequeueTimeDuration(nearestTimeGap.TimeGapInSeconds, &hibernator)
 
 	timeElapsedSinceLastRunInSeconds, hasPreviousRun := r.TimeUtil.timeElapsedSinceLastRunInSeconds(&hibernator)
-	if hasPreviousRun && timeElapsedSinceLastRunInSeconds <= pincherv1alpha1.MinReSyncIntervalInSeconds {
+	// a change to a hibernated object is handled right away
+	if hasPreviousRun && timeElapsedSinceLastRunInSeconds <= pincherv1alpha1.MinReSyncIntervalInSeconds && !drifted && !wokenOnRequest {
 		log.Info("skipping reconciliation as time elapsed since last run is less than 1 min")
 		return ctrl.Result{RequeueAfter: time.Duration(pincherv1alpha1.MinReSyncIntervalInSeconds) * time.Second}, nil
 	}
 
+	if r.ResourceCache != nil && r.ResourceSelector != nil {
+		r.ResourceCache.Retain(types.NamespacedName{Namespace: hibernator.Namespace, Name: hibernator.Name}.String(), r.ResourceSelector.getListedResources(hibernator.Spec.Selectors, r.DriftWatch != nil))
+	}
+
+	// the namespaces are listed once for all the selectors of the hibernator
+	ctx = withNamespaceCache(ctx)
 	finalHibernator := &hibernator
 	updated := false
+	expressionErrors, selectionErrors := hibernator.Status.ExpressionErrors, hibernator.Status.SelectionErrors
 	if hibernator.Spec.Action == pincherv1alpha1.Delete {
-		finalHibernator, updated = r.HibernatorAction.delete(&hibernator)
+		finalHibernator, updated = r.HibernatorAction.delete(ctx, &hibernator)
 	} else if hibernator.Spec.Action == pincherv1alpha1.Hibernate || hibernator.Spec.Action == pincherv1alpha1.Sleep {
-		finalHibernator, updated = r.HibernatorAction.hibernate(&hibernator, nearestTimeGap)
+		finalHibernator, updated = r.HibernatorAction.hibernate(ctx, &hibernator, nearestTimeGap)
 	} else if hibernator.Spec.Action == pincherv1alpha1.Scale {
-		finalHibernator, updated = r.HibernatorAction.scale(&hibernator, nearestTimeGap)
+		finalHibernator, updated = r.HibernatorAction.scale(ctx, &hibernator, nearestTimeGap)
 	} else {
 		log.Info("didnt hibernate or unHibernate -", "action", nearestTimeGap.WithinRange, "timegap", nearestTimeGap.TimeGapInSeconds, "isHibernating", hibernator.Status.IsHibernating)
 	}
 
+	// a failing expression is surfaced even when nothing else changed
+	updated = updated || !reflect.DeepEqual(expressionErrors, finalHibernator.Status.ExpressionErrors) || !reflect.DeepEqual(selectionErrors, finalHibernator.Status.SelectionErrors)
+
+	if r.Activator != nil {
+		routesUpdated := r.Activator.Route(ctx, finalHibernator)
+		updated = updated || routesUpdated || wokenOnRequest
+	}
+	if remaining, ok := wakeOnRequestRemaining(finalHibernator, now); ok && remaining < requeueTime {
+		requeueTime = remaining
+	}
+
 	if updated {
-		err = r.Client.Update(context.Background(), finalHibernator)
+		// the objects acted upon before the reconcile was cancelled or timed out are still recorded, the status is
+		// updated with a context of its own
+		updateCtx, cancel := context.WithTimeout(context.Background(), statusUpdateTimeout)
+		defer cancel()
+		err = r.Client.Status().Update(updateCtx, finalHibernator)
 		if err != nil {
 			log.Error(err, "error while updating hibernator %v")
 			return ctrl.Result{}, err
 		}
 	}
+	if r.DriftWatch != nil {
+		r.DriftWatch.Update(finalHibernator)
+	}
+	if ctx.Err() != nil {
+		log.Info("reconcile interrupted, remaining objects are retried", "error", ctx.Err().Error())
+		return ctrl.Result{}, ctx.Err()
+	}
 
 	//log.Info("end processing, processing parameter - start time: %v, timegap: %d, requeueTime:  %s", now, timeGap, requeueTime)
 	return ctrl.Result{RequeueAfter: requeueTime}, nil
 }
 
 func (r *HibernatorReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&pincherv1alpha1.Hibernator{}).
-		Complete(r)
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles})
+	if r.DriftWatch != nil {
+		builder = builder.Watches(r.DriftWatch.Source(), &handler.EnqueueRequestForObject{})
+	}
+	if r.Activator != nil {
+		builder = builder.Watches(r.Activator.Source(), &handler.EnqueueRequestForObject{})
+	}
+	return builder.Complete(r)
 }
-
-//type TargetObjectHPAPair struct {
-//	TargetObject *unstructured.Unstructured
-//	HPA          *unstructured.Unstructured
-//}
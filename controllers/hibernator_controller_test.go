@@ -17,6 +17,7 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/devtron-labs/winter-soldier/pkg"
@@ -123,7 +124,7 @@ func TestHibernatorReconciler_hibernate(t *testing.T) {
 		{
 			name: "hibernate sleep test",
 			fields: fields{
-				kubectl:     pkg.NewKubectl(),
+				kubectl:     pkg.NewKubectl(pkg.NewClientsOrDie()),
 				historyUtil: &HistoryImpl{},
 				log:         controllerruntime.Log.WithName("controllers").WithName("Hibernator"),
 			},
@@ -135,7 +136,7 @@ func TestHibernatorReconciler_hibernate(t *testing.T) {
 		{
 			name: "hibernate delete test",
 			fields: fields{
-				kubectl:     pkg.NewKubectl(),
+				kubectl:     pkg.NewKubectl(pkg.NewClientsOrDie()),
 				historyUtil: &HistoryImpl{},
 			},
 			args: args{hibernator: hibernator2},
@@ -143,8 +144,8 @@ func TestHibernatorReconciler_hibernate(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			r := NewHibernatorActionImpl(tt.fields.kubectl, tt.fields.historyUtil, tt.fields.resourceAction, tt.fields.resourceSelector, tt.fields.log)
-			got, _ := r.hibernate(&tt.args.hibernator, tt.args.timeGap)
+			r := NewHibernatorActionImpl(tt.fields.kubectl, tt.fields.historyUtil, tt.fields.resourceAction, tt.fields.resourceSelector, nil, tt.fields.log)
+			got, _ := r.hibernate(context.Background(), &tt.args.hibernator, tt.args.timeGap)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("hibernate() got = %v, want %v", got, tt.want)
 			}
@@ -204,7 +205,7 @@ func TestHibernatorReconciler_process(t *testing.T) {
 				HibernatorAction: tt.fields.HibernatorAction,
 				TimeUtil:         tt.fields.TimeUtil,
 			}
-			got, err := r.process(tt.args.hibernator)
+			got, err := r.process(context.Background(), tt.args.hibernator)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("process() error = %v, wantErr %v", err, tt.wantErr)
 				return
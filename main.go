@@ -19,12 +19,15 @@ package main
 import (
 	"flag"
 	"github.com/devtron-labs/winter-soldier/pkg"
+	"net/http"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -48,15 +51,35 @@ func init() {
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var maxConcurrentReconciles int
+	var kubeAPIQPS float64
+	var kubeAPIBurst int
+	var requestTimeout time.Duration
+	var reconcileTimeout time.Duration
+	var activatorPort int
+	var activatorIP string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The maximum number of hibernators reconciled concurrently.")
+	flag.Float64Var(&kubeAPIQPS, "kube-api-qps", 20, "The maximum queries per second to the Kubernetes API server.")
+	flag.IntVar(&kubeAPIBurst, "kube-api-burst", 30, "The maximum burst of queries to the Kubernetes API server.")
+	flag.DurationVar(&requestTimeout, "request-timeout", pkg.DefaultRequestTimeout, "The timeout of a single request to the Kubernetes API server.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", 5*time.Minute, "The timeout of a single reconcile of a hibernator.")
+	flag.IntVar(&activatorPort, "activator-port", 0, "The port the wake on request activator listens on, 0 disables it.")
+	flag.StringVar(&activatorIP, "activator-ip", os.Getenv("POD_IP"), "The IP at which pods reach the activator, defaults to the POD_IP environment variable.")
+	flag.DurationVar(&pkg.ExpressionTimeout, "expression-timeout", pkg.DefaultExpressionTimeout, "The timeout of the evaluation of a field selector expression against an object.")
+	flag.DurationVar(&pkg.MaxEvaluationTime, "max-evaluation-time", pkg.DefaultMaxEvaluationTime, "The timeout of the evaluation of a field selector expression against an object, lookups of related objects included.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	restConfig := ctrl.GetConfigOrDie()
+	restConfig.QPS = float32(kubeAPIQPS)
+	restConfig.Burst = kubeAPIBurst
+
+	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
@@ -68,13 +91,35 @@ func main() {
 		os.Exit(1)
 	}
 	log := ctrl.Log.WithName("controllers").WithName("Hibernator")
-	kubectl := pkg.NewKubectl()
-	mapper := pkg.NewMapperFactory()
+	clients, err := pkg.NewClients(rest.CopyConfig(restConfig))
+	if err != nil {
+		setupLog.Error(err, "unable to create clients")
+		os.Exit(1)
+	}
+	clients.RequestTimeout = requestTimeout
+	kubectl := pkg.NewKubectl(clients)
+	mapper := pkg.NewMapperFactory(clients)
 	history := controllers.NewHistoryImpl()
 	resourceAction := controllers.NewResourceActionImpl(kubectl, history)
-	resourceSelector := controllers.NewResourceSelectorImpl(kubectl, mapper, pkg.NewFactory)
-	hibernatorAction := controllers.NewHibernatorActionImpl(kubectl, history, resourceAction, resourceSelector, log)
+	resourceCache := pkg.NewCachedKubectl(kubectl)
+	driftWatch := controllers.NewDriftWatch()
+	resourceCache.AddEventHandler(driftWatch)
+	resourceSelector := controllers.NewResourceSelectorImpl(resourceCache, mapper, pkg.NewFactory)
+	idleDetector := controllers.NewIdleDetectorImpl(kubectl, &http.Client{Timeout: requestTimeout})
+	hibernatorAction := controllers.NewHibernatorActionImpl(kubectl, history, resourceAction, resourceSelector, idleDetector, log)
 	timeUtil := controllers.NewTimeUtilImpl(history)
+	var activator *controllers.Activator
+	if activatorPort > 0 {
+		if len(activatorIP) == 0 {
+			setupLog.Error(nil, "activator-ip is required with activator-port")
+			os.Exit(1)
+		}
+		activator = controllers.NewActivator(kubectl, activatorIP, int32(activatorPort), ctrl.Log.WithName("activator"))
+		if err = mgr.Add(activator); err != nil {
+			setupLog.Error(err, "unable to add activator")
+			os.Exit(1)
+		}
+	}
 	if err = (&controllers.HibernatorReconciler{
 		Client:           mgr.GetClient(),
 		Log:              log,
@@ -83,14 +128,24 @@ func main() {
 		Mapper:           mapper,
 		HibernatorAction: hibernatorAction,
 		TimeUtil:         timeUtil,
+		ResourceCache:    resourceCache,
+		ResourceSelector: resourceSelector,
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		ReconcileTimeout:        reconcileTimeout,
+		DriftWatch:              driftWatch,
+		Activator:               activator,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Hibernator")
 		os.Exit(1)
 	}
 	// +kubebuilder:scaffold:builder
 
+	ctx := ctrl.SetupSignalHandler()
+	clients.WatchCustomResourceDefinitions(ctx.Done())
+
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	if err := mgr.Start(ctx); err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
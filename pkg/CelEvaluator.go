@@ -0,0 +1,334 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	"github.com/google/cel-go/common"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/interpreter/functions"
+	exprpb "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// celInterruptCheckFrequency is the number of iterations of a CEL comprehension after which the cancellation of
+	// the evaluation is checked
+	celInterruptCheckFrequency = 100
+	// celBindingsVariable holds the functions of the object being evaluated, every function call of a CEL expression
+	// is expanded to take it as its first argument
+	celBindingsVariable = "__bindings"
+)
+
+var (
+	errorType        = reflect.TypeOf((*error)(nil)).Elem()
+	interfaceType    = reflect.TypeOf((*interface{})(nil)).Elem()
+	timeType         = reflect.TypeOf(&time.Time{})
+	celBindingsType  = types.NewTypeValue("winter_soldier.bindings")
+	celFunctionTypes = make(map[string]reflect.Type)
+)
+
+// celPrograms keeps the planned CEL programs, compile errors included, as expressionCache does for expr
+var celPrograms = struct {
+	lock     sync.Mutex
+	programs map[string]*celCompiled
+}{
+	programs: make(map[string]*celCompiled),
+}
+
+type celCompiled struct {
+	program cel.Program
+	err     error
+}
+
+var (
+	celEnvOnce sync.Once
+	celEnv     *cel.Env
+	celEnvErr  error
+)
+
+// celBindings are the functions of the object being evaluated along with its evaluation, the CEL programs being
+// shared by all the objects
+type celBindings struct {
+	functions map[string]interface{}
+	e         *evaluation
+}
+
+func (b *celBindings) ConvertToNative(typeDesc reflect.Type) (interface{}, error) {
+	return nil, fmt.Errorf("%s can't be converted to %s", celBindingsType, typeDesc)
+}
+
+func (b *celBindings) ConvertToType(typeVal ref.Type) ref.Val {
+	return types.NewErr("%s can't be converted to %s", celBindingsType, typeVal)
+}
+
+func (b *celBindings) Equal(other ref.Val) ref.Val {
+	return types.Bool(other == b)
+}
+
+func (b *celBindings) Type() ref.Type {
+	return celBindingsType
+}
+
+func (b *celBindings) Value() interface{} {
+	return b
+}
+
+// getCelEnv returns the environment of the CEL expressions, declaring the object as self along with the functions
+// of the expr expressions, the functions reading the object or its related objects included
+func getCelEnv() (*cel.Env, error) {
+	celEnvOnce.Do(func() {
+		options := []cel.EnvOption{
+			cel.Variable("self", cel.DynType),
+			cel.Variable(celBindingsVariable, cel.DynType),
+			cel.CrossTypeNumericComparisons(true),
+		}
+		declared := make(map[string]interface{})
+		for name, function := range expressionFunctions {
+			declared[name] = function
+		}
+		for name, function := range objectFunctions(nil, nil, "") {
+			declared[name] = function
+		}
+		for name, function := range (&ObjectLookup{}).functions(nil, unstructured.Unstructured{}) {
+			declared[name] = function
+		}
+		var names []string
+		for name := range declared {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			celFunctionTypes[name] = reflect.TypeOf(declared[name])
+			option, err := celFunction(name, celFunctionTypes[name])
+			if err != nil {
+				celEnvErr = err
+				return
+			}
+			options = append(options, option, cel.Macros(cel.NewGlobalVarArgMacro(name, expandCelCall(name))))
+		}
+		celEnv, celEnvErr = cel.NewEnv(options...)
+	})
+	return celEnv, celEnvErr
+}
+
+// expandCelCall passes the bindings to the calls of the function as their first argument
+func expandCelCall(name string) cel.MacroExpander {
+	return func(eh cel.MacroExprHelper, _ *exprpb.Expr, args []*exprpb.Expr) (*exprpb.Expr, *common.Error) {
+		return eh.GlobalCall(name, append([]*exprpb.Expr{eh.Ident(celBindingsVariable)}, args...)...), nil
+	}
+}
+
+// celFunction declares the function for its Go signature, returning a value or a value and an error, taking the
+// bindings as its first argument
+func celFunction(name string, function reflect.Type) (cel.EnvOption, error) {
+	args := []*cel.Type{cel.DynType}
+	for i := 0; i < function.NumIn(); i++ {
+		arg, err := celType(function.In(i))
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", name, err)
+		}
+		args = append(args, arg)
+	}
+	if function.NumOut() == 0 || function.NumOut() > 2 || (function.NumOut() == 2 && function.Out(1) != errorType) {
+		return nil, fmt.Errorf("function %s: unsupported results", name)
+	}
+	result, err := celType(function.Out(0))
+	if err != nil {
+		return nil, fmt.Errorf("function %s: %w", name, err)
+	}
+	return cel.Function(name, cel.Overload(fmt.Sprintf("%s_%d", name, len(args)), args, result)), nil
+}
+
+func celType(t reflect.Type) (*cel.Type, error) {
+	switch {
+	case t == timeType:
+		return cel.TimestampType, nil
+	case t.Kind() == reflect.String:
+		return cel.StringType, nil
+	case t.Kind() == reflect.Bool:
+		return cel.BoolType, nil
+	case t.Kind() == reflect.Int:
+		return cel.IntType, nil
+	case t.Kind() == reflect.Float64:
+		return cel.DoubleType, nil
+	case t.Kind() == reflect.Interface:
+		return cel.DynType, nil
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.String:
+		return cel.ListType(cel.StringType), nil
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String && t.Elem().Kind() == reflect.String:
+		return cel.MapType(cel.StringType, cel.StringType), nil
+	case t.Kind() == reflect.Map && t.Key().Kind() == reflect.String:
+		return cel.MapType(cel.StringType, cel.DynType), nil
+	}
+	return nil, fmt.Errorf("unsupported type %s", t)
+}
+
+// celOverloads binds the functions, each call checking that the evaluation still has time left and calling the
+// function of the object being evaluated
+func celOverloads() []*functions.Overload {
+	var overloads []*functions.Overload
+	for name := range celFunctionTypes {
+		name := name
+		overloads = append(overloads, &functions.Overload{
+			Operator: name,
+			Function: func(values ...ref.Val) ref.Val {
+				if len(values) == 0 {
+					return types.NewErr("%s: no bindings", name)
+				}
+				bindings, ok := values[0].(*celBindings)
+				if !ok {
+					return types.NewErr("%s: no bindings", name)
+				}
+				if _, err := bindings.e.check(); err != nil {
+					return types.NewErr(err.Error())
+				}
+				function, ok := bindings.functions[name]
+				if !ok {
+					return types.NewErr("%s is not available", name)
+				}
+				return callFunction(reflect.ValueOf(function), values[1:])
+			},
+		})
+	}
+	return overloads
+}
+
+// callFunction calls the Go function with the CEL values, converting its result back to a CEL value
+func callFunction(fn reflect.Value, values []ref.Val) ref.Val {
+	if len(values) != fn.Type().NumIn() {
+		return types.NewErr("expected %d arguments, found %d", fn.Type().NumIn(), len(values))
+	}
+	args := make([]reflect.Value, len(values))
+	for i, value := range values {
+		in := fn.Type().In(i)
+		switch {
+		case in == timeType:
+			t, ok := value.Value().(time.Time)
+			if !ok {
+				return types.NewErr("expected a timestamp, found %v", value.Value())
+			}
+			args[i] = reflect.ValueOf(&t)
+		case in.Kind() == reflect.Interface:
+			if t, ok := value.Value().(time.Time); ok {
+				args[i] = reflect.ValueOf(&t)
+			} else if value.Value() == nil {
+				args[i] = reflect.Zero(in)
+			} else {
+				args[i] = reflect.ValueOf(value.Value())
+			}
+		default:
+			native, err := value.ConvertToNative(in)
+			if err != nil {
+				return types.NewErr(err.Error())
+			}
+			args[i] = reflect.ValueOf(native)
+		}
+	}
+	results := fn.Call(args)
+	if len(results) == 2 && !results[1].IsNil() {
+		return types.NewErr(results[1].Interface().(error).Error())
+	}
+	result := results[0].Interface()
+	if t, ok := result.(*time.Time); ok {
+		if t == nil {
+			return types.NullValue
+		}
+		return types.Timestamp{Time: *t}
+	}
+	return types.DefaultTypeAdapter.NativeToValue(result)
+}
+
+// compileCel returns the program of the expression, compiling and planning it only the first time
+func compileCel(expression string) (cel.Program, error) {
+	celPrograms.lock.Lock()
+	compiled, ok := celPrograms.programs[expression]
+	celPrograms.lock.Unlock()
+	if ok {
+		return compiled.program, compiled.err
+	}
+	compiled = &celCompiled{}
+	env, err := getCelEnv()
+	if err != nil {
+		compiled.err = err
+	} else if len(expression) > MaxExpressionLength {
+		compiled.err = fmt.Errorf("longer than %d characters", MaxExpressionLength)
+	} else if ast, issues := env.Compile(expression); issues != nil && issues.Err() != nil {
+		compiled.err = issues.Err()
+	} else if !reflect.DeepEqual(ast.OutputType(), cel.BoolType) && !reflect.DeepEqual(ast.OutputType(), cel.DynType) {
+		compiled.err = fmt.Errorf("evaluates to %s instead of a bool", ast.OutputType())
+	} else if cost, err := env.EstimateCost(ast, celCostEstimator{}); err != nil {
+		compiled.err = err
+	} else if cost.Max > MaxExpressionCost {
+		compiled.err = fmt.Errorf("cost of %d is more than %d, loop over fewer or smaller collections", cost.Max, MaxExpressionCost)
+	} else {
+		compiled.program, compiled.err = env.Program(ast,
+			cel.Functions(celOverloads()...),
+			cel.CostLimit(MaxExpressionCost),
+			cel.InterruptCheckFrequency(celInterruptCheckFrequency),
+		)
+	}
+	celPrograms.lock.Lock()
+	if len(celPrograms.programs) >= maxCachedExpressions {
+		celPrograms.programs = make(map[string]*celCompiled)
+	}
+	celPrograms.programs[expression] = compiled
+	celPrograms.lock.Unlock()
+	return compiled.program, compiled.err
+}
+
+// celCostEstimator assumes the size of the lists and maps only known at evaluation, such as the fields of the
+// object, as expr expressions do
+type celCostEstimator struct{}
+
+func (celCostEstimator) EstimateSize(_ checker.AstNode) *checker.SizeEstimate {
+	return &checker.SizeEstimate{Min: 0, Max: assumedCollectionSize}
+}
+
+func (celCostEstimator) EstimateCallCost(_, _ string, _ *checker.AstNode, _ []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// evaluateCel evaluates the CEL expression against the object as self, along with the functions of the object
+func evaluateCel(expression string, object unstructured.Unstructured, functionsByName map[string]interface{}, e *evaluation) (bool, error) {
+	program, err := compileCel(expression)
+	if err != nil {
+		return false, &ExpressionError{Expression: expression, Err: err}
+	}
+	output, _, err := program.ContextEval(e.ctx, map[string]interface{}{
+		"self":              object.Object,
+		celBindingsVariable: &celBindings{functions: functionsByName, e: e},
+	})
+	if err != nil {
+		if e.err != nil || e.ctx.Err() != nil {
+			_, err = e.check()
+		}
+		return false, &ExpressionError{Expression: expression, Err: err}
+	}
+	matched, ok := output.Value().(bool)
+	if !ok {
+		return false, &ExpressionError{Expression: expression, Err: fmt.Errorf("evaluated to %v instead of a bool", output.Value())}
+	}
+	return matched, nil
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	memory "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/flowcontrol"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sync"
+	"time"
+)
+
+// DefaultRequestTimeout bounds each request to the API server made through kubectl
+const DefaultRequestTimeout = 30 * time.Second
+
+var customResourceDefinitionGVR = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// Clients is the long lived bundle of clients shared by kubectl and the mapper. Discovery is cached and invalidated,
+// together with the REST mapper, when custom resource definitions change or a kind is not found.
+type Clients struct {
+	RestConfig *rest.Config
+	Dynamic    dynamic.Interface
+	Discovery  discovery.CachedDiscoveryInterface
+	RESTMapper *restmapper.DeferredDiscoveryRESTMapper
+	// RequestTimeout bounds each request made through kubectl, requests are only bounded by their context when it is
+	// not positive. It is not set on the rest config as it would also cut the watches of the informers.
+	RequestTimeout time.Duration
+
+	lock sync.Mutex
+	// unmatched are the kinds the REST mapper was reset for without being found, it is not reset for them again until
+	// discovery is invalidated, so that looking up kinds the cluster does not serve does not keep resetting it
+	unmatched map[schema.GroupKind]bool
+}
+
+func NewClients(restConfig *rest.Config) (*Clients, error) {
+	// all the clients share the rate limiter to stay within the client side rate limits together
+	if restConfig.RateLimiter == nil {
+		restConfig.RateLimiter = flowcontrol.NewTokenBucketRateLimiter(restConfig.QPS, restConfig.Burst)
+	}
+	dynamicIf, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	disco, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	cachedDisco := memory.NewMemCacheClient(disco)
+	return &Clients{
+		RestConfig:     restConfig,
+		Dynamic:        dynamicIf,
+		Discovery:      cachedDisco,
+		RESTMapper:     restmapper.NewDeferredDiscoveryRESTMapper(cachedDisco),
+		RequestTimeout: DefaultRequestTimeout,
+	}, nil
+}
+
+func NewClientsOrDie() *Clients {
+	clients, err := NewClients(ctrl.GetConfigOrDie())
+	if err != nil {
+		panic(err)
+	}
+	return clients
+}
+
+// Invalidate drops the cached discovery information and REST mappings
+func (c *Clients) Invalidate() {
+	c.lock.Lock()
+	c.unmatched = nil
+	c.lock.Unlock()
+	c.RESTMapper.Reset()
+}
+
+// requestContext derives the context of a single request from ctx, bounded by the request timeout
+func (c *Clients) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.RequestTimeout)
+}
+
+// ResourceFor returns the resource of the kind, in its preferred version when the version is empty. The REST mapper
+// is reset and the kind looked up again if it is unknown, for example when its custom resource definition was just
+// created, once per kind until discovery is invalidated.
+func (c *Clients) ResourceFor(gvk schema.GroupVersionKind) (schema.GroupVersionResource, error) {
+	var versions []string
+	if len(gvk.Version) != 0 {
+		versions = append(versions, gvk.Version)
+	}
+	mapping, err := c.RESTMapper.RESTMapping(gvk.GroupKind(), versions...)
+	if meta.IsNoMatchError(err) && c.resetFor(gvk.GroupKind()) {
+		mapping, err = c.RESTMapper.RESTMapping(gvk.GroupKind(), versions...)
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, err
+	}
+	return mapping.Resource, nil
+}
+
+// resetFor resets the REST mapper for the unknown kind unless it was already reset for it
+func (c *Clients) resetFor(groupKind schema.GroupKind) bool {
+	c.lock.Lock()
+	if c.unmatched[groupKind] {
+		c.lock.Unlock()
+		return false
+	}
+	if c.unmatched == nil {
+		c.unmatched = make(map[schema.GroupKind]bool)
+	}
+	c.unmatched[groupKind] = true
+	c.lock.Unlock()
+	c.RESTMapper.Reset()
+	return true
+}
+
+// WatchCustomResourceDefinitions invalidates the cached discovery information whenever a custom resource definition
+// is created, updated or deleted, until stop is closed
+func (c *Clients) WatchCustomResourceDefinitions(stop <-chan struct{}) {
+	informer := cache.NewSharedIndexInformer(dynamicListerWatcher(c.Dynamic, customResourceDefinitionGVR), &unstructured.Unstructured{}, 0, cache.Indexers{})
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			// the initial list of existing definitions does not change discovery
+			if informer.HasSynced() {
+				c.Invalidate()
+			}
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			c.Invalidate()
+		},
+		DeleteFunc: func(obj interface{}) {
+			c.Invalidate()
+		},
+	})
+	go informer.Run(stop)
+}
+
+func dynamicListerWatcher(dynamicIf dynamic.Interface, gvr schema.GroupVersionResource) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return dynamicIf.Resource(gvr).Namespace(metav1.NamespaceAll).List(context.Background(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return dynamicIf.Resource(gvr).Namespace(metav1.NamespaceAll).Watch(context.Background(), options)
+		},
+	}
+}
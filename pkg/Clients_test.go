@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/restmapper"
+	"testing"
+)
+
+type fakeDiscovery struct {
+	discovery.DiscoveryInterface
+	resources   []*metav1.APIResourceList
+	invalidated int
+}
+
+func (f *fakeDiscovery) ServerGroupsAndResources() ([]*metav1.APIGroup, []*metav1.APIResourceList, error) {
+	var groups []*metav1.APIGroup
+	for _, resourceList := range f.resources {
+		gv, _ := schema.ParseGroupVersion(resourceList.GroupVersion)
+		version := metav1.GroupVersionForDiscovery{GroupVersion: resourceList.GroupVersion, Version: gv.Version}
+		groups = append(groups, &metav1.APIGroup{Name: gv.Group, Versions: []metav1.GroupVersionForDiscovery{version}, PreferredVersion: version})
+	}
+	return groups, f.resources, nil
+}
+
+func (f *fakeDiscovery) Fresh() bool {
+	return true
+}
+
+func (f *fakeDiscovery) Invalidate() {
+	f.invalidated++
+}
+
+func TestClients_ResourceFor(t *testing.T) {
+	tests := []struct {
+		name            string
+		gvk             schema.GroupVersionKind
+		lookups         int
+		want            schema.GroupVersionResource
+		wantErr         bool
+		wantInvalidated int
+	}{
+		{
+			name: "known kind",
+			gvk:  schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			want: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+		{
+			name: "preferred version of a kind",
+			gvk:  schema.GroupVersionKind{Group: "apps", Kind: "Deployment"},
+			want: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		},
+		{
+			name:            "kind of custom resource definition created after discovery",
+			gvk:             schema.GroupVersionKind{Group: "keda.sh", Version: "v1alpha1", Kind: "ScaledObject"},
+			want:            schema.GroupVersionResource{Group: "keda.sh", Version: "v1alpha1", Resource: "scaledobjects"},
+			wantInvalidated: 1,
+		},
+		{
+			name:            "unknown kind",
+			gvk:             schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"},
+			wantErr:         true,
+			wantInvalidated: 1,
+		},
+		{
+			name:            "unknown kind looked up again",
+			gvk:             schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Unknown"},
+			lookups:         3,
+			wantErr:         true,
+			wantInvalidated: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			disco := &fakeDiscovery{resources: []*metav1.APIResourceList{{
+				GroupVersion: "apps/v1",
+				APIResources: []metav1.APIResource{{Name: "deployments", Kind: "Deployment", Namespaced: true}},
+			}}}
+			clients := &Clients{Discovery: disco, RESTMapper: restmapper.NewDeferredDiscoveryRESTMapper(disco)}
+			if _, err := clients.ResourceFor(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}); err != nil {
+				t.Fatalf("ResourceFor() error = %v", err)
+			}
+			disco.resources = append(disco.resources, &metav1.APIResourceList{
+				GroupVersion: "keda.sh/v1alpha1",
+				APIResources: []metav1.APIResource{{Name: "scaledobjects", Kind: "ScaledObject", Namespaced: true}},
+			})
+
+			var got schema.GroupVersionResource
+			var err error
+			for i := 0; i < tt.lookups || i == 0; i++ {
+				got, err = clients.ResourceFor(tt.gvk)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResourceFor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResourceFor() got = %v, want %v", got, tt.want)
+			}
+			if disco.invalidated != tt.wantInvalidated {
+				t.Errorf("ResourceFor() invalidated = %d, want %d", disco.invalidated, tt.wantInvalidated)
+			}
+		})
+	}
+}
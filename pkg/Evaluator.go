@@ -17,232 +17,662 @@ limitations under the License.
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/ast"
+	"github.com/antonmedv/expr/conf"
+	"github.com/antonmedv/expr/vm"
+	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
 	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 const variablePattern = `\{\{[^\}\}|\{\{]*\}\}`
 
-var variableRegex *regexp.Regexp
-
-//var memoryConversions = map[string]float64{
-//	"E":  float64(1000000000000000000),
-//	"P":  float64(1000000000000000),
-//	"T":  float64(1000000000000),
-//	"G":  float64(1000000000),
-//	"M":  float64(1000000),
-//	"K":  float64(1000),
-//	"Ei": float64(1152921504606846976),
-//	"Pi": float64(1125899906842624),
-//	"Ti": float64(1099511627776),
-//	"Gi": float64(1073741824),
-//	"Mi": float64(1048576),
-//	"Ki": float64(10241),
-//}
-//
-//var cpuConversions = map[string]float64{
-//	"m": .0001,
-//}
-
-type resourceParser struct {
-	name        string
-	pattern     string
-	regex       *regexp.Regexp
-	conversions map[string]float64
-}
-
-//const memoryPattern = "(\\d*e?\\d*)(Ei?|Pi?|Ti?|Gi?|Mi?|Ki?|$)"
-//const cpuPattern = "(\\d*e?\\d*)(m?)"
-
-var memoryParser *resourceParser
-var cpuParser *resourceParser
-
-// TODO: handle date and memory and cpu properly
-func ExpressionEvaluator(expression, json string) bool {
-	if variableRegex == nil {
-		variableRegex, _ = regexp.Compile(variablePattern)
-	}
-	matches := variableRegex.FindAllString(expression, -1)
-	variablesWithValue := make(map[string]interface{}, len(matches))
-	finalExpression := expression
-	var expressions, variables []string
-	for index, match := range matches {
-		originalMatch := match
-		match = strings.Replace(match, "{{", "", 1)
-		match = strings.Replace(match, "}}", "", 1)
-		variableName := "var" + strconv.Itoa(index)
-		variables = append(variables, variableName)
-		expressions = append(expressions, match)
-		//fmt.Println(match)
-		finalExpression = strings.ReplaceAll(finalExpression, originalMatch, variableName)
-	}
-	res := gjson.GetMany(json, expressions...)
-	for i, re := range res {
-		variablesWithValue[variables[i]] = re.Value()
-	}
-	//fmt.Println(expression)
-	//fmt.Println(finalExpression)
-	//fmt.Println(variables)
-	//fmt.Println(variablesWithValue)
-	variablesWithValue["CpuToNumber"] = CpuToNumber
-	variablesWithValue["MemoryToNumber"] = MemoryToNumber
-	variablesWithValue["ParseTime"] = ParseTime
-	variablesWithValue["Now"] = func() *time.Time {
+// Limits of the expressions. expr has no limit on the steps of an evaluation, they are bounded through the number of
+// nodes an expression compiles from, the cost of its loops and the time its evaluation takes.
+const (
+	MaxExpressionLength = 4096
+	MaxExpressionNodes  = 500
+	// MaxExpressionCost bounds the number of nodes evaluated by an expression, counting each node of the closure of
+	// all, any, none, one, filter and map once for every item of the collection it loops over
+	MaxExpressionCost = 1000000
+	// DefaultExpressionTimeout bounds the evaluation of an expression against an object, not counting the time spent
+	// looking up related objects
+	DefaultExpressionTimeout = 100 * time.Millisecond
+	// DefaultMaxEvaluationTime bounds the evaluation of an expression against an object, lookups included
+	DefaultMaxEvaluationTime = 10 * time.Second
+	maxCachedExpressions     = 1000
+	// assumedCollectionSize is the size assumed for a collection whose size is only known at evaluation, such as a
+	// field of the object
+	assumedCollectionSize = 100
+	// continueFunction is the function checking at every step of the loops of an expression that its evaluation
+	// still has time left
+	continueFunction = "$continue"
+)
+
+// ExpressionTimeout bounds the evaluation of an expression against an object, not counting the time spent looking up
+// related objects. The loops of an evaluation running out of time fail at their next step.
+var ExpressionTimeout = DefaultExpressionTimeout
+
+// MaxEvaluationTime bounds the evaluation of an expression against an object, lookups included, the lookups still
+// running then being cancelled
+var MaxEvaluationTime = DefaultMaxEvaluationTime
+
+var ErrExpressionTimeout = errors.New("evaluation timed out")
+
+var variableRegex = regexp.MustCompile(variablePattern)
+
+// ExpressionError is the failure of an expression to compile, or to evaluate to a bool against an object
+type ExpressionError struct {
+	Expression string
+	Err        error
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("expression %s: %v", e.Expression, e.Err)
+}
+
+func (e *ExpressionError) Unwrap() error {
+	return e.Err
+}
+
+// parsedExpression is an expression whose {{path}} variables are replaced with var0, var1... along with their paths
+type parsedExpression struct {
+	expression string
+	replaced   string
+	variables  []string
+	paths      []string
+}
+
+// compiledKey identifies a program compiled from an expression for the types of its variables, as a variable is of
+// any of the few types of JSON values, and whether it is compiled along with the lookup functions
+type compiledKey struct {
+	expression string
+	related    bool
+	types      string
+}
+
+type compiledProgram struct {
+	program *vm.Program
+	err     error
+}
+
+// expressionCache keeps the parsed expressions and compiled programs, compile errors included, so that evaluating an
+// expression against all the selected objects compiles it once. It is reset once it grows past its limit.
+var expressionCache = struct {
+	lock     sync.Mutex
+	parsed   map[string]*parsedExpression
+	programs map[compiledKey]*compiledProgram
+}{
+	parsed:   make(map[string]*parsedExpression),
+	programs: make(map[compiledKey]*compiledProgram),
+}
+
+// expressionFunctions are the functions of the expressions not depending on the object being evaluated
+var expressionFunctions = map[string]interface{}{
+	"CpuToNumber":     CpuToNumber,
+	"MemoryToNumber":  MemoryToNumber,
+	"Quantity":        Quantity,
+	"CompareQuantity": CompareQuantity,
+	"SumRequests":     SumRequests,
+	"ParseTime":       ParseTime,
+	"Time":            Time,
+	"Now": func() *time.Time {
 		n := time.Now()
 		return &n
-	}
-	variablesWithValue["AfterTime"] = func(t1, t2 *time.Time) (bool, error) {
+	},
+	"AfterTime": func(t1, t2 *time.Time) (bool, error) {
 		if t1 == nil || t2 == nil {
 			return false, fmt.Errorf("t1: %v or t2: %v is nil", t1, t2)
 		}
 		return t1.After(*t2), nil
+	},
+	"AddTime":  AddTime,
+	"Duration": Duration,
+	"Since":    Since,
+}
+
+// ExpressionEvaluator evaluates the expression against the JSON document, false when it fails
+func ExpressionEvaluator(expression, json string) bool {
+	matched, _ := EvaluateExpression(expression, json)
+	return matched
+}
+
+// evaluation is the evaluation of an expression against an object, its context is cancelled after MaxEvaluationTime
+// or once the evaluation is done, so that the lookups of related objects made with it stop as well
+type evaluation struct {
+	ctx   context.Context
+	start time.Time
+	// waiting is the time spent looking up related objects, not counted in ExpressionTimeout
+	waiting time.Duration
+	err     error
+}
+
+func newEvaluation(ctx context.Context) (*evaluation, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, MaxEvaluationTime)
+	return &evaluation{ctx: ctx, start: time.Now()}, cancel
+}
+
+// check fails once the evaluation ran out of time, it is called at every step of the loops of the expression
+func (e *evaluation) check() (bool, error) {
+	if e.err != nil {
+		return false, e.err
+	}
+	if err := e.ctx.Err(); err == context.DeadlineExceeded {
+		e.err = fmt.Errorf("%w after %s", ErrExpressionTimeout, MaxEvaluationTime)
+	} else if err != nil {
+		e.err = err
+	} else if time.Since(e.start)-e.waiting > ExpressionTimeout {
+		e.err = fmt.Errorf("%w after %s", ErrExpressionTimeout, ExpressionTimeout)
+	}
+	return e.err == nil, e.err
+}
+
+// addWaiting adds the time spent looking up related objects since the start
+func (e *evaluation) addWaiting(start time.Time) {
+	e.waiting += time.Since(start)
+}
+
+// EvaluateExpression evaluates the expression against the JSON document, failing with an ExpressionError when it
+// does not compile or evaluate to a bool
+func EvaluateExpression(expression, json string) (bool, error) {
+	parsed := parseExpression(expression)
+	env := parsed.values(json)
+	metadata := gjson.Get(json, "metadata")
+	for name, function := range objectFunctions(stringMap(metadata.Get("labels")), stringMap(metadata.Get("annotations")), metadata.Get("creationTimestamp").String()) {
+		env[name] = function
+	}
+	e, cancel := newEvaluation(context.Background())
+	defer cancel()
+	return evaluate(parsed, env, e, false)
+}
+
+// RelatedExpressionEvaluator evaluates the expression against the object along with the functions looking up the
+// objects related to it through the lookup, eg its namespace, owner, referencing pods or endpoints
+func RelatedExpressionEvaluator(expression string, object unstructured.Unstructured, lookup *ObjectLookup) bool {
+	matched, _ := EvaluateRelatedExpressions([]v1alpha1.FieldExpression{{Expression: expression}}, object, lookup)
+	return matched
+}
+
+// EvaluateRelatedExpressions returns whether all the expressions are true for the object, evaluated along with the
+// functions looking up its related objects. It stops at the first expression false or failing with an
+// ExpressionError. The object is marshalled once for all the expr expressions and only if one of them reads a field,
+// CEL expressions read it as self.
+func EvaluateRelatedExpressions(expressions []v1alpha1.FieldExpression, object unstructured.Unstructured, lookup *ObjectLookup) (bool, error) {
+	var creationTimestamp string
+	if created := object.GetCreationTimestamp(); !created.IsZero() {
+		creationTimestamp = created.UTC().Format(time.RFC3339)
+	}
+	functions := objectFunctions(object.GetLabels(), object.GetAnnotations(), creationTimestamp)
+	var json []byte
+	for _, expression := range expressions {
+		var matched bool
+		var err error
+		switch expression.Language {
+		case "", v1alpha1.ExprLanguage:
+			parsed := parseExpression(expression.Expression)
+			if len(parsed.paths) > 0 && json == nil {
+				if json, err = object.MarshalJSON(); err != nil {
+					return false, &ExpressionError{Expression: expression.Expression, Err: err}
+				}
+			}
+			env := parsed.values(string(json))
+			for name, function := range functions {
+				env[name] = function
+			}
+			matched, err = evaluateRelated(object, lookup, func(e *evaluation, lookupFunctions map[string]interface{}) (bool, error) {
+				for name, function := range lookupFunctions {
+					env[name] = function
+				}
+				return evaluate(parsed, env, e, true)
+			})
+		case v1alpha1.CELLanguage:
+			matched, err = evaluateRelated(object, lookup, func(e *evaluation, lookupFunctions map[string]interface{}) (bool, error) {
+				celFunctions := make(map[string]interface{}, len(expressionFunctions)+len(functions)+len(lookupFunctions))
+				for _, group := range []map[string]interface{}{expressionFunctions, functions, lookupFunctions} {
+					for name, function := range group {
+						celFunctions[name] = function
+					}
+				}
+				return evaluateCel(expression.Expression, object, celFunctions, e)
+			})
+		default:
+			err = &ExpressionError{Expression: expression.Expression, Err: fmt.Errorf("unknown language %s", expression.Language)}
+		}
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ValidateExpression compiles the expression for the object it is evaluated against being unknown, failing with an
+// ExpressionError when it can't compile whatever the object, eg on a syntax error or an unknown function, so that it
+// is reported even when there is no object to evaluate it against
+func ValidateExpression(expression v1alpha1.FieldExpression) error {
+	switch expression.Language {
+	case "", v1alpha1.ExprLanguage:
+		parsed := parseExpression(expression.Expression)
+		env := make(map[string]interface{}, len(expressionFunctions))
+		for _, group := range []map[string]interface{}{expressionFunctions, objectFunctions(nil, nil, ""), (&ObjectLookup{}).functions(nil, unstructured.Unstructured{})} {
+			for name, function := range group {
+				env[name] = function
+			}
+		}
+		env[continueFunction] = (&evaluation{}).check
+		if _, err := compile(parsed, env, true); err != nil {
+			return &ExpressionError{Expression: expression.Expression, Err: err}
+		}
+	case v1alpha1.CELLanguage:
+		if _, err := compileCel(expression.Expression); err != nil {
+			return &ExpressionError{Expression: expression.Expression, Err: err}
+		}
+	default:
+		return &ExpressionError{Expression: expression.Expression, Err: fmt.Errorf("unknown language %s", expression.Language)}
+	}
+	return nil
+}
+
+// evaluateRelated runs an evaluation along with the functions looking up the related objects of the object within it
+func evaluateRelated(object unstructured.Unstructured, lookup *ObjectLookup, evaluate func(e *evaluation, lookupFunctions map[string]interface{}) (bool, error)) (bool, error) {
+	e, cancel := newEvaluation(lookup.ctx)
+	defer cancel()
+	return evaluate(e, lookup.functions(e, object))
+}
+
+func parseExpression(expression string) *parsedExpression {
+	expressionCache.lock.Lock()
+	defer expressionCache.lock.Unlock()
+	if parsed, ok := expressionCache.parsed[expression]; ok {
+		return parsed
+	}
+	parsed := &parsedExpression{expression: expression, replaced: expression}
+	for index, match := range variableRegex.FindAllString(expression, -1) {
+		variableName := "var" + strconv.Itoa(index)
+		parsed.variables = append(parsed.variables, variableName)
+		parsed.paths = append(parsed.paths, strings.TrimSuffix(strings.TrimPrefix(match, "{{"), "}}"))
+		parsed.replaced = strings.ReplaceAll(parsed.replaced, match, variableName)
+	}
+	if len(expressionCache.parsed) >= maxCachedExpressions {
+		expressionCache.parsed = make(map[string]*parsedExpression)
+	}
+	expressionCache.parsed[expression] = parsed
+	return parsed
+}
+
+// values returns the environment of the expression holding the values of its variables in the JSON document
+func (p *parsedExpression) values(json string) map[string]interface{} {
+	env := make(map[string]interface{}, len(p.variables)+len(expressionFunctions))
+	for i, result := range gjson.GetMany(json, p.paths...) {
+		env[p.variables[i]] = result.Value()
+	}
+	for name, function := range expressionFunctions {
+		env[name] = function
 	}
-	variablesWithValue["AddTime"] = AddTime
-	program, err := expr.Compile(finalExpression, expr.Env(variablesWithValue))
+	return env
+}
+
+func evaluate(parsed *parsedExpression, env map[string]interface{}, e *evaluation, related bool) (bool, error) {
+	env[continueFunction] = e.check
+	program, err := compile(parsed, env, related)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return false, &ExpressionError{Expression: parsed.expression, Err: err}
 	}
-	output, err := expr.Run(program, variablesWithValue)
+	output, err := run(program, env, e)
 	if err != nil {
-		fmt.Println(err)
-		return false
+		return false, &ExpressionError{Expression: parsed.expression, Err: err}
 	}
-	//fmt.Println(output)
-	switch v := output.(type) {
-	case bool:
-		return v
-	default:
-		return false
+	matched, ok := output.(bool)
+	if !ok {
+		return false, &ExpressionError{Expression: parsed.expression, Err: fmt.Errorf("evaluated to %v instead of a bool", output)}
+	}
+	return matched, nil
+}
+
+// compile returns the program of the expression for the types of the values of its variables in the environment,
+// compiling it only the first time
+func compile(parsed *parsedExpression, env map[string]interface{}, related bool) (*vm.Program, error) {
+	types := make([]string, len(parsed.variables))
+	var unknown []string
+	for i, variable := range parsed.variables {
+		if value, ok := env[variable]; ok {
+			types[i] = fmt.Sprintf("%T", value)
+		} else {
+			types[i] = "unknown"
+			unknown = append(unknown, variable)
+		}
+	}
+	key := compiledKey{expression: parsed.expression, related: related, types: strings.Join(types, ",")}
+	expressionCache.lock.Lock()
+	compiled, ok := expressionCache.programs[key]
+	expressionCache.lock.Unlock()
+	if ok {
+		return compiled.program, compiled.err
+	}
+	compiled = &compiledProgram{}
+	limiter := &expressionLimiter{}
+	if len(parsed.expression) > MaxExpressionLength {
+		compiled.err = fmt.Errorf("longer than %d characters", MaxExpressionLength)
+	} else if compiled.program, compiled.err = expr.Compile(parsed.replaced, expr.Env(env), unknownVariables(unknown), expr.Patch(limiter)); compiled.err == nil && limiter.nodes > MaxExpressionNodes {
+		compiled.program, compiled.err = nil, fmt.Errorf("more than %d nodes", MaxExpressionNodes)
+	} else if compiled.err == nil && limiter.cost > MaxExpressionCost {
+		compiled.program, compiled.err = nil, fmt.Errorf("cost of %.0f is more than %d, loop over fewer or smaller collections", limiter.cost, MaxExpressionCost)
+	}
+	expressionCache.lock.Lock()
+	if len(expressionCache.programs) >= maxCachedExpressions {
+		expressionCache.programs = make(map[compiledKey]*compiledProgram)
+	}
+	expressionCache.programs[key] = compiled
+	expressionCache.lock.Unlock()
+	return compiled.program, compiled.err
+}
+
+// unknownVariables declares the variables missing from the environment as of any type, their values being unknown
+func unknownVariables(variables []string) expr.Option {
+	return func(c *conf.Config) {
+		for _, variable := range variables {
+			c.Types[variable] = conf.Tag{Type: interfaceType}
+		}
+	}
+}
+
+// expressionLimiter counts the nodes of the expression being compiled and estimates its cost, the number of nodes its
+// evaluation goes through, and makes every step of its loops check the time left to the evaluation
+type expressionLimiter struct {
+	nodes int
+	cost  float64
+	// children is the costs of the children of each node being walked
+	children [][]float64
+}
+
+func (l *expressionLimiter) Enter(_ *ast.Node) {
+	l.nodes++
+	l.children = append(l.children, nil)
+}
+
+func (l *expressionLimiter) Exit(node *ast.Node) {
+	children := l.children[len(l.children)-1]
+	l.children = l.children[:len(l.children)-1]
+	cost := 1.0
+	if builtin, ok := (*node).(*ast.BuiltinNode); ok && isLoop(builtin.Name) && len(children) == 2 {
+		cost += children[0] + collectionSize(builtin.Arguments[0])*children[1]
+	} else {
+		for _, child := range children {
+			cost += child
+		}
+	}
+	if closure, ok := (*node).(*ast.ClosureNode); ok {
+		// the body is kept on both branches so that its type is left unchanged
+		check := &ast.FunctionNode{Name: continueFunction}
+		closure.Node = &ast.ConditionalNode{Cond: check, Exp1: closure.Node, Exp2: closure.Node}
+	}
+	if len(l.children) == 0 {
+		l.cost = cost
+		return
+	}
+	l.children[len(l.children)-1] = append(l.children[len(l.children)-1], cost)
+}
+
+// isLoop reports if the builtin evaluates its closure for every item of its collection
+func isLoop(builtin string) bool {
+	switch builtin {
+	case "all", "none", "any", "one", "filter", "map":
+		return true
+	}
+	return false
+}
+
+// collectionSize returns the size of the collection, as many items as the memory budget of expr allows for a range
+// whose bounds are only known at evaluation
+func collectionSize(node ast.Node) float64 {
+	switch n := node.(type) {
+	case *ast.ArrayNode:
+		return float64(len(n.Nodes))
+	case *ast.BinaryNode:
+		if n.Operator != ".." {
+			return assumedCollectionSize
+		}
+		from, fromOk := n.Left.(*ast.IntegerNode)
+		to, toOk := n.Right.(*ast.IntegerNode)
+		if !fromOk || !toOk {
+			return float64(vm.MemoryBudget)
+		}
+		return math.Max(float64(to.Value-from.Value+1), 0)
+	case *ast.BuiltinNode:
+		if (n.Name == "filter" || n.Name == "map") && len(n.Arguments) > 0 {
+			return collectionSize(n.Arguments[0])
+		}
 	}
+	return assumedCollectionSize
 }
 
-func ParseTime(dateTime, format string) (*time.Time, error) {
-	t, err := time.Parse(format, dateTime)
+// run runs the program, failing with ErrExpressionTimeout once its evaluation ran out of time
+func run(program *vm.Program, env map[string]interface{}, e *evaluation) (interface{}, error) {
+	output, err := expr.Run(program, env)
+	if err != nil {
+		// a lookup cancelled along with the evaluation fails with the error of its context
+		if e.err != nil || e.ctx.Err() != nil {
+			_, err = e.check()
+		}
+		return nil, err
+	}
+	return output, nil
+}
 
+// ParseTime parses the time in the layout, RFC3339 when the layout is empty
+func ParseTime(dateTime, layout string) (*time.Time, error) {
+	if len(layout) == 0 {
+		layout = time.RFC3339
+	}
+	t, err := time.Parse(layout, dateTime)
 	if err != nil {
-		fmt.Println(err)
 		return nil, err
 	}
-	fmt.Println(t)
 	return &t, nil
 }
 
+// Time parses an RFC3339 time such as metadata.creationTimestamp
+func Time(dateTime string) (*time.Time, error) {
+	return ParseTime(dateTime, time.RFC3339)
+}
+
+// AddTime adds the period, as parsed by ParseDuration, to the time
 func AddTime(t *time.Time, period string) (*time.Time, error) {
-	chars := []rune(period)
-	start := 0
-	if chars[0] == '-' {
-		start = 1
-	}
-	numeral := ""
-	duration := 0
-	for i := start; i < len(chars); i++ {
-		if chars[i] == 'd' || chars[i] == 'h' || chars[i] == 'm' || chars[i] == 's' {
-			d, err := strconv.Atoi(numeral)
-			if err != nil {
-				return nil, err
-			}
-			m, err := toSeconds(chars[i])
-			if err != nil {
-				return nil, err
-			}
-			duration += d * m
-			numeral = ""
-		} else {
-			numeral += string(chars[i])
-		}
+	if t == nil {
+		return nil, fmt.Errorf("time to add %s to is nil", period)
 	}
-	if chars[0] == '-' {
-		duration *= -1
+	d, err := ParseDuration(period)
+	if err != nil {
+		return nil, err
 	}
-	ft := t.Add(time.Duration(duration) * time.Second)
+	ft := t.Add(d)
 	return &ft, nil
 }
 
-func toSeconds(symbol rune) (int, error) {
-	switch symbol {
-	case 'd':
-		return 24 * 60 * 60, nil
-	case 'h':
-		return 60 * 60, nil
-	case 'm':
-		return 60, nil
-	case 's':
-		return 1, nil
-	default:
-		return 0, fmt.Errorf("unsupported format %c", symbol)
+// ParseDuration parses a duration as time.ParseDuration does, such as -1h30m, which can also start with a number of
+// days such as 7d or 1d12h
+func ParseDuration(period string) (time.Duration, error) {
+	value := strings.TrimSpace(period)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimLeft(value, "+-")
+	if len(value) == 0 {
+		return 0, fmt.Errorf("invalid duration %q", period)
+	}
+	var d time.Duration
+	if i := strings.Index(value, "d"); i >= 0 {
+		days, err := strconv.ParseFloat(value[:i], 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid duration %q", period)
+		}
+		d = time.Duration(days * float64(24*time.Hour))
+		value = value[i+1:]
 	}
+	if len(value) > 0 {
+		rest, err := time.ParseDuration(value)
+		if err != nil || strings.ContainsAny(value[:1], "+-") {
+			return 0, fmt.Errorf("invalid duration %q", period)
+		}
+		d += rest
+	}
+	if negative {
+		d = -d
+	}
+	return d, nil
 }
 
-func MemoryToNumber(memory string) (float64, error) {
-	if memoryParser == nil {
-		pattern := "(\\d*e?\\d*)(Ei?|Pi?|Ti?|Gi?|Mi?|Ki?|$)"
-		re, _ := regexp.Compile(pattern)
-		memoryParser = &resourceParser{
-			name:    "memory",
-			pattern: pattern,
-			regex:   re,
-			conversions: map[string]float64{
-				"E":  float64(1000000000000000000),
-				"P":  float64(1000000000000000),
-				"T":  float64(1000000000000),
-				"G":  float64(1000000000),
-				"M":  float64(1000000),
-				"K":  float64(1000),
-				"Ei": float64(1152921504606846976),
-				"Pi": float64(1125899906842624),
-				"Ti": float64(1099511627776),
-				"Gi": float64(1073741824),
-				"Mi": float64(1048576),
-				"Ki": float64(10241),
-			},
-		}
+// Duration returns the period, as parsed by ParseDuration, in seconds so that it can be compared with Age and Since
+func Duration(period string) (float64, error) {
+	d, err := ParseDuration(period)
+	if err != nil {
+		return 0, err
 	}
-	return convertResource(memoryParser, memory)
+	return d.Seconds(), nil
 }
 
-func CpuToNumber(cpu string) (float64, error) {
-	if cpuParser == nil {
-		pattern := "(\\d*e?\\d*)(m?)"
-		re, _ := regexp.Compile(pattern)
-		cpuParser = &resourceParser{
-			name:    "cpu",
-			pattern: pattern,
-			regex:   re,
-			conversions: map[string]float64{
-				"m": .0001,
-			},
+// Since returns the seconds elapsed since the time, given as a time or an RFC3339 string
+func Since(value interface{}) (float64, error) {
+	switch t := value.(type) {
+	case *time.Time:
+		if t == nil {
+			return 0, fmt.Errorf("time is nil")
 		}
+		return time.Since(*t).Seconds(), nil
+	case time.Time:
+		return time.Since(t).Seconds(), nil
+	case string:
+		parsed, err := Time(t)
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(*parsed).Seconds(), nil
+	default:
+		return 0, fmt.Errorf("expected a time, found %v", value)
+	}
+}
+
+// objectFunctions returns the functions of the expressions reading the object being evaluated
+func objectFunctions(labels, annotations map[string]string, creationTimestamp string) map[string]interface{} {
+	return map[string]interface{}{
+		// Age returns the seconds elapsed since the object was created
+		"Age": func() (float64, error) {
+			return Since(creationTimestamp)
+		},
+		// Label returns the value of the label of the object, empty if not set
+		"Label": func(key string) string {
+			return labels[key]
+		},
+		"HasLabel": func(key string) bool {
+			_, ok := labels[key]
+			return ok
+		},
+		// Annotation returns the value of the annotation of the object, empty if not set
+		"Annotation": func(key string) string {
+			return annotations[key]
+		},
+		"HasAnnotation": func(key string) bool {
+			_, ok := annotations[key]
+			return ok
+		},
+	}
+}
+
+func stringMap(result gjson.Result) map[string]string {
+	values := make(map[string]string)
+	for key, value := range result.Map() {
+		values[key] = value.String()
+	}
+	return values
+}
+
+// Quantity returns the value of a resource quantity such as 250m or 64Mi, in cores for cpu and bytes for memory
+func Quantity(value string) (float64, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q: %w", value, err)
 	}
-	return convertResource(cpuParser, cpu)
+	return quantity.AsApproximateFloat64(), nil
 }
 
-func convertResource(rp *resourceParser, resource string) (float64, error) {
-	matches := rp.regex.FindAllStringSubmatch(resource, -1)
-	if len(matches[0]) < 2 {
-		fmt.Printf("expected pattern for %s should match %s, found %s\n", rp.name, rp.pattern, resource)
-		return float64(0), fmt.Errorf("expected pattern for %s should match %s, found %s", rp.name, rp.pattern, resource)
+// CompareQuantity compares two resource quantities exactly, returning -1, 0 or 1 as a is less than, equal to or
+// greater than b
+func CompareQuantity(a, b string) (int, error) {
+	qa, err := resource.ParseQuantity(a)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q: %w", a, err)
 	}
-	num, err := ParseFloat(matches[0][1])
+	qb, err := resource.ParseQuantity(b)
 	if err != nil {
-		fmt.Println(err)
-		return float64(0), err
+		return 0, fmt.Errorf("invalid quantity %q: %w", b, err)
+	}
+	return qa.Cmp(qb), nil
+}
+
+// MemoryToNumber returns the bytes of a memory quantity such as 64Mi
+func MemoryToNumber(memory string) (float64, error) {
+	return Quantity(memory)
+}
+
+// CpuToNumber returns the cores of a cpu quantity such as 250m
+func CpuToNumber(cpu string) (float64, error) {
+	return Quantity(cpu)
+}
+
+// SumRequests returns the effective request of the resource, such as cpu or memory, of the pod spec as the scheduler
+// sees it: the larger of the sum over the containers and the largest init container, plus the pod overhead
+func SumRequests(podSpec interface{}, resourceName string) (float64, error) {
+	spec, ok := podSpec.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("expected a pod spec, found %v", podSpec)
 	}
-	if len(matches[0]) == 3 && matches[0][2] != "" {
-		if suffix, ok := rp.conversions[matches[0][2]]; ok {
-			return num * suffix, nil
+	request := func(container interface{}) (resource.Quantity, error) {
+		value, _, _ := unstructured.NestedString(asMap(container), "resources", "requests", resourceName)
+		if len(value) == 0 {
+			return resource.Quantity{}, nil
 		}
-	} else {
-		return num, nil
+		return resource.ParseQuantity(value)
+	}
+	containers, _, _ := unstructured.NestedSlice(spec, "containers")
+	total := resource.Quantity{}
+	for _, container := range containers {
+		quantity, err := request(container)
+		if err != nil {
+			return 0, err
+		}
+		total.Add(quantity)
+	}
+	initContainers, _, _ := unstructured.NestedSlice(spec, "initContainers")
+	for _, container := range initContainers {
+		quantity, err := request(container)
+		if err != nil {
+			return 0, err
+		}
+		if quantity.Cmp(total) > 0 {
+			total = quantity
+		}
+	}
+	if overhead, ok, _ := unstructured.NestedString(spec, "overhead", resourceName); ok {
+		quantity, err := resource.ParseQuantity(overhead)
+		if err != nil {
+			return 0, err
+		}
+		total.Add(quantity)
 	}
-	fmt.Printf("expected pattern for %s should match %s, found %s\n", rp.name, rp.pattern, resource)
-	return float64(0), fmt.Errorf("expected pattern for %s should match %s, found %s", rp.name, rp.pattern, resource)
+	return total.AsApproximateFloat64(), nil
+}
+
+func asMap(value interface{}) map[string]interface{} {
+	m, _ := value.(map[string]interface{})
+	return m
 }
 
 func ParseFloat(str string) (float64, error) {
@@ -17,7 +17,15 @@ limitations under the License.
 package pkg
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const pod = `
@@ -313,10 +321,29 @@ func Test_convertMemory(t *testing.T) {
 			wantErr: false,
 		},
 		{
-			name:    "base test - scientifc notation",
+			name:    "base test - scientifc notation with suffix",
 			args:    args{memory: "1e2G"},
-			want:    float64(100 * 1000000000),
-			wantErr: false,
+			wantErr: true,
+		},
+		{
+			name: "kibibytes",
+			args: args{memory: "1Ki"},
+			want: float64(1024),
+		},
+		{
+			name: "decimal",
+			args: args{memory: "1.5G"},
+			want: float64(1500000000),
+		},
+		{
+			name:    "empty",
+			args:    args{memory: ""},
+			wantErr: true,
+		},
+		{
+			name:    "invalid",
+			args:    args{memory: "lots"},
+			wantErr: true,
 		},
 		{
 			name:    "base test - scientifc notation",
@@ -338,3 +365,332 @@ func Test_convertMemory(t *testing.T) {
 		})
 	}
 }
+
+func Test_cpuToNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     string
+		want    float64
+		wantErr bool
+	}{
+		{name: "millicores", cpu: "250m", want: 0.25},
+		{name: "cores", cpu: "2", want: 2},
+		{name: "fractional cores", cpu: "0.5", want: 0.5},
+		{name: "invalid", cpu: "250x", wantErr: true},
+		{name: "empty", cpu: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CpuToNumber(tt.cpu)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CpuToNumber() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CpuToNumber() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareQuantity(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		want    int
+		wantErr bool
+	}{
+		{name: "equal in different units", a: "1Gi", b: "1024Mi", want: 0},
+		{name: "less", a: "500m", b: "1", want: -1},
+		{name: "greater", a: "1G", b: "1Gi", want: -1},
+		{name: "greater binary", a: "1Ti", b: "1T", want: 1},
+		{name: "invalid", a: "1Gi", b: "big", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CompareQuantity(tt.a, tt.b)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CompareQuantity() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("CompareQuantity() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		period  string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "hours", period: "10h", want: 10 * time.Hour},
+		{name: "days", period: "20d", want: 20 * 24 * time.Hour},
+		{name: "days and hours", period: "1d12h", want: 36 * time.Hour},
+		{name: "negative days", period: "-1d", want: -24 * time.Hour},
+		{name: "negative minutes and seconds", period: "-1m30s", want: -90 * time.Second},
+		{name: "empty", period: "", wantErr: true},
+		{name: "sign only", period: "-", wantErr: true},
+		{name: "days without number", period: "d", wantErr: true},
+		{name: "unknown unit", period: "2w", wantErr: true},
+		{name: "sign after days", period: "1d-2h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDuration(tt.period)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseDuration() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddTime(t *testing.T) {
+	base := time.Date(2021, 9, 9, 7, 56, 32, 0, time.UTC)
+	tests := []struct {
+		name    string
+		time    *time.Time
+		period  string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "days", time: &base, period: "2d", want: base.Add(48 * time.Hour)},
+		{name: "earlier", time: &base, period: "-10h", want: base.Add(-10 * time.Hour)},
+		{name: "empty period", time: &base, period: "", wantErr: true},
+		{name: "nil time", period: "1h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AddTime(tt.time, tt.period)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AddTime() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("AddTime() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSumRequests(t *testing.T) {
+	spec := func(raw string) interface{} {
+		value := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(raw), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name     string
+		podSpec  interface{}
+		resource string
+		want     float64
+		wantErr  bool
+	}{
+		{
+			name:     "containers",
+			podSpec:  spec(`{"containers": [{"resources": {"requests": {"cpu": "250m"}}}, {"resources": {"requests": {"cpu": "500m"}}}, {"name": "no requests"}]}`),
+			resource: "cpu",
+			want:     0.75,
+		},
+		{
+			name:     "larger init container",
+			podSpec:  spec(`{"containers": [{"resources": {"requests": {"memory": "64Mi"}}}], "initContainers": [{"resources": {"requests": {"memory": "256Mi"}}}]}`),
+			resource: "memory",
+			want:     256 * 1024 * 1024,
+		},
+		{
+			name:     "overhead",
+			podSpec:  spec(`{"containers": [{"resources": {"requests": {"cpu": "1"}}}], "overhead": {"cpu": "250m"}}`),
+			resource: "cpu",
+			want:     1.25,
+		},
+		{
+			name:     "invalid request",
+			podSpec:  spec(`{"containers": [{"resources": {"requests": {"cpu": "lots"}}}]}`),
+			resource: "cpu",
+			wantErr:  true,
+		},
+		{
+			name:     "not a pod spec",
+			podSpec:  "spec",
+			resource: "cpu",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SumRequests(tt.podSpec, tt.resource)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("SumRequests() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("SumRequests() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionEvaluator_helpers(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	object := `{"metadata": {"name": "web", "creationTimestamp": "` + created + `", "labels": {"app.kubernetes.io/name": "web"}, "annotations": {"devtron.ai/owner": "qa"}},
+  "status": {"startTime": "` + created + `"},
+  "spec": {"template": {"spec": {"containers": [{"resources": {"requests": {"cpu": "250m", "memory": "64Mi"}}}, {"resources": {"requests": {"cpu": "250m", "memory": "64Mi"}}}]}}}}`
+	tests := []struct {
+		name       string
+		expression string
+		want       bool
+	}{
+		{name: "age", expression: "Age() > Duration('1d')", want: true},
+		{name: "younger", expression: "Age() < Duration('1d')", want: false},
+		{name: "since time", expression: "Since(Time({{status.startTime}})) > Duration('47h')", want: true},
+		{name: "since string", expression: "Since({{status.startTime}}) < Duration('1h')", want: false},
+		{name: "since invalid time", expression: "Since('yesterday') > 0", want: false},
+		{name: "quantity", expression: "Quantity('1Gi') > Quantity('1G')", want: true},
+		{name: "compare quantity", expression: "CompareQuantity('1Gi', '1024Mi') == 0", want: true},
+		{name: "invalid quantity", expression: "Quantity('big') > 0", want: false},
+		{name: "sum of requests", expression: "SumRequests({{spec.template.spec}}, 'cpu') == Quantity('500m')", want: true},
+		{name: "sum of memory requests", expression: "SumRequests({{spec.template.spec}}, 'memory') == MemoryToNumber('128Mi')", want: true},
+		{name: "label", expression: "Label('app.kubernetes.io/name') == 'web'", want: true},
+		{name: "missing label", expression: "Label('team') == '' && !HasLabel('team')", want: true},
+		{name: "has label", expression: "HasLabel('app.kubernetes.io/name')", want: true},
+		{name: "annotation", expression: "Annotation('devtron.ai/owner') == 'qa' && HasAnnotation('devtron.ai/owner')", want: true},
+		{name: "add empty period", expression: "AfterTime(Now(), AddTime(Now(), ''))", want: false},
+		{name: "rfc3339 parse time", expression: "AfterTime(Now(), ParseTime({{metadata.creationTimestamp}}, ''))", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExpressionEvaluator(tt.expression, object); got != tt.want {
+				t.Errorf("ExpressionEvaluator() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	object := `{"metadata": {"name": "web"}, "spec": {"replicas": 2}}`
+	tests := []struct {
+		name       string
+		expression string
+		timeout    time.Duration
+		want       bool
+		wantErr    string
+	}{
+		{name: "true", expression: "{{spec.replicas}} + 1 == 3", want: true},
+		{name: "false", expression: "{{spec.replicas}} > 2", want: false},
+		{name: "syntax error", expression: "{{spec.replicas}} >", wantErr: "unexpected token"},
+		{name: "mismatched types", expression: "{{spec.replicas}} > {{metadata.name}}", wantErr: "mismatched types"},
+		{name: "missing field", expression: "{{spec.paused}} > 1", wantErr: "mismatched types"},
+		{name: "not a bool", expression: "{{metadata.name}}", wantErr: "instead of a bool"},
+		{name: "failing function", expression: "Quantity('big') > 0", wantErr: "invalid quantity"},
+		{name: "too long", expression: "{{spec.replicas}} > 1" + strings.Repeat(" ", MaxExpressionLength), wantErr: "longer than"},
+		{name: "too many nodes", expression: strings.Repeat("1 > 0 && ", MaxExpressionNodes/3) + "{{spec.replicas}} > 0", wantErr: "more than"},
+		{name: "nested loops over large ranges", expression: "all(1..900, {all(1..900, {# > 0})})", wantErr: "cost of"},
+		{name: "loop over a range of unknown size", expression: "any(1..len({{metadata.name}}), {any(1..len({{metadata.name}}), {# > 1})})", wantErr: "cost of"},
+		{name: "loop within the cost", expression: "all(1..900, {# > 0}) && any(map(1..10, {# * 2}), {# == 20})", want: true},
+		{name: "timed out", expression: "all(1..300, {all(1..300, {# > 0})})", timeout: time.Nanosecond, wantErr: "timed out"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.timeout != 0 {
+				ExpressionTimeout = tt.timeout
+				defer func() { ExpressionTimeout = DefaultExpressionTimeout }()
+			}
+			got, err := EvaluateExpression(tt.expression, object)
+			if len(tt.wantErr) != 0 {
+				var expressionErr *ExpressionError
+				if !errors.As(err, &expressionErr) || expressionErr.Expression != tt.expression || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("EvaluateExpression() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("EvaluateExpression() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateRelatedExpressions(t *testing.T) {
+	lookup := NewObjectLookup(context.Background(), NewKubectlMock("[]"))
+	expressions := []v1alpha1.FieldExpression{{Expression: "{{spec.replicas}} >= 1"}, {Expression: "Label('app') == 'web'"}}
+	tests := []struct {
+		name    string
+		object  string
+		want    bool
+		wantErr bool
+	}{
+		{name: "all true", object: `{"kind": "Deployment", "metadata": {"name": "web", "labels": {"app": "web"}}, "spec": {"replicas": 2}}`, want: true},
+		{name: "second false", object: `{"kind": "Deployment", "metadata": {"name": "api", "labels": {"app": "api"}}, "spec": {"replicas": 3}}`, want: false},
+		{name: "first false", object: `{"kind": "Deployment", "metadata": {"name": "batch", "labels": {"app": "web"}}, "spec": {"replicas": 0}}`, want: false},
+		{name: "first failing", object: `{"kind": "Deployment", "metadata": {"name": "config", "labels": {"app": "web"}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := unstructured.Unstructured{}
+			if err := object.UnmarshalJSON([]byte(tt.object)); err != nil {
+				t.Fatal(err)
+			}
+			got, err := EvaluateRelatedExpressions(expressions, object, lookup)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("EvaluateRelatedExpressions() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+	// the expressions are compiled once for each type of spec.replicas, a number or missing
+	programs := make(map[string]int)
+	expressionCache.lock.Lock()
+	for key := range expressionCache.programs {
+		if key.related && (key.expression == expressions[0].Expression || key.expression == expressions[1].Expression) {
+			programs[key.expression]++
+		}
+	}
+	expressionCache.lock.Unlock()
+	if want := map[string]int{expressions[0].Expression: 2, expressions[1].Expression: 1}; !reflect.DeepEqual(programs, want) {
+		t.Errorf("EvaluateRelatedExpressions() compiled programs = %v, want %v", programs, want)
+	}
+}
+
+func TestValidateExpression(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression v1alpha1.FieldExpression
+		wantErr    string
+	}{
+		{name: "expr", expression: v1alpha1.FieldExpression{Expression: "{{spec.replicas}} > {{metadata.name}} && NamespaceLabels().env == 'qa'"}},
+		{name: "expr loop over a field", expression: v1alpha1.FieldExpression{Expression: "all({{spec.containers}}, {Quantity(.resources.requests.cpu) < 1})"}},
+		{name: "expr syntax error", expression: v1alpha1.FieldExpression{Expression: "{{spec.replicas}} >"}, wantErr: "unexpected token"},
+		{name: "expr unknown function", expression: v1alpha1.FieldExpression{Expression: "Replicas() > 1"}, wantErr: "unknown func"},
+		{name: "expr cost", expression: v1alpha1.FieldExpression{Expression: "all(1..900, {all(1..900, {# > 0})})"}, wantErr: "cost of"},
+		{name: "cel", expression: v1alpha1.FieldExpression{Expression: "self.spec.replicas > 1 && NamespaceLabels().env == 'qa'", Language: v1alpha1.CELLanguage}},
+		{name: "cel syntax error", expression: v1alpha1.FieldExpression{Expression: "self.spec.replicas >", Language: v1alpha1.CELLanguage}, wantErr: "Syntax error"},
+		{name: "cel unknown function", expression: v1alpha1.FieldExpression{Expression: "Replicas() > 1", Language: v1alpha1.CELLanguage}, wantErr: "undeclared reference"},
+		{name: "cel not a bool", expression: v1alpha1.FieldExpression{Expression: "Label('app')", Language: v1alpha1.CELLanguage}, wantErr: "instead of a bool"},
+		{name: "unknown language", expression: v1alpha1.FieldExpression{Expression: "true", Language: "rego"}, wantErr: "unknown language"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateExpression(tt.expression)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Errorf("ValidateExpression() error = %v", err)
+				}
+				return
+			}
+			var expressionErr *ExpressionError
+			if !errors.As(err, &expressionErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateExpression() error = %v, want %s", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"strings"
+	"testing"
+)
+
+const conformanceObjectsMock = `
+[
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "dev", "labels": {"env": "qa"}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment",
+   "metadata": {"name": "web", "namespace": "dev", "labels": {"app": "web"}, "creationTimestamp": "2020-01-01T00:00:00Z"},
+   "spec": {"replicas": 2, "template": {"spec": {"containers": [
+     {"name": "app", "resources": {"requests": {"cpu": "250m", "memory": "64Mi"}}, "envFrom": [{"configMapRef": {"name": "settings"}}]},
+     {"name": "proxy", "resources": {"requests": {"cpu": "500m"}}}
+   ]}}}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings", "namespace": "dev"}}
+]`
+
+// TestExpressionConformance evaluates each case with both engines, expr and CEL, which are expected to agree
+func TestExpressionConformance(t *testing.T) {
+	web, settings := "/dev/Deployment/web", "/dev/ConfigMap/settings"
+	tests := []struct {
+		name    string
+		object  string
+		expr    string
+		cel     string
+		want    bool
+		wantErr string
+	}{
+		{name: "number field", object: web, expr: "{{spec.replicas}} == 2", cel: "self.spec.replicas == 2", want: true},
+		{name: "number field compared with a double", object: web, expr: "{{spec.replicas}} > 1.5", cel: "self.spec.replicas > 1.5", want: true},
+		{name: "string field", object: web, expr: "{{metadata.name}} startsWith 'api'", cel: "self.metadata.name.startsWith('api')"},
+		{name: "label", object: web, expr: "Label('app') == 'web'", cel: "Label('app') == 'web'", want: true},
+		{name: "missing annotation", object: web, expr: "HasAnnotation('owner')", cel: "HasAnnotation('owner')"},
+		{name: "age", object: web, expr: "Age() > Duration('30d')", cel: "Age() > Duration('30d')", want: true},
+		{name: "time", object: web, expr: "AfterTime(Now(), Time({{metadata.creationTimestamp}}))", cel: "Now() > timestamp(self.metadata.creationTimestamp)", want: true},
+		{name: "added time", object: web, expr: "AfterTime(AddTime(Time({{metadata.creationTimestamp}}), '1d'), Now())", cel: "AddTime(Time(self.metadata.creationTimestamp), '1d') > Now()"},
+		{name: "quantity", object: web, expr: "Quantity({{spec.template.spec.containers.0.resources.requests.cpu}}) == 0.25", cel: "Quantity(self.spec.template.spec.containers[0].resources.requests.cpu) == 0.25", want: true},
+		{name: "compared quantities", object: web, expr: "CompareQuantity('1Gi', '1000Mi') == 1", cel: "CompareQuantity('1Gi', '1000Mi') == 1", want: true},
+		{name: "summed requests", object: web, expr: "SumRequests({{spec.template.spec}}, 'cpu') == 0.75", cel: "SumRequests(self.spec.template.spec, 'cpu') == 0.75", want: true},
+		{name: "loop", object: web, expr: "all({{spec.template.spec.containers}}, {.name != ''})", cel: "self.spec.template.spec.containers.all(c, c.name != '')", want: true},
+		{name: "namespace label", object: web, expr: "NamespaceLabels().env == 'qa'", cel: "NamespaceLabels().env == 'qa'", want: true},
+		{name: "missing namespace label", object: web, expr: "'team' in NamespaceLabels()", cel: "'team' in NamespaceLabels()"},
+		{name: "referencing workloads", object: settings, expr: "'Deployment/web' in ReferencingWorkloads()", cel: "'Deployment/web' in ReferencingWorkloads()", want: true},
+		{name: "syntax error", object: web, expr: "{{spec.replicas}} >", cel: "self.spec.replicas >", wantErr: "expression"},
+		{name: "missing field", object: web, expr: "{{spec.paused}} > 1", cel: "self.spec.paused > 1", wantErr: "expression"},
+		{name: "failing function", object: web, expr: "Quantity('big') > 0", cel: "Quantity('big') > 0", wantErr: "invalid quantity"},
+		{name: "not a bool", object: web, expr: "{{metadata.name}}", cel: "self.metadata.name", wantErr: "instead of a bool"},
+		{
+			name:    "nested loops over collections of unknown size",
+			object:  web,
+			expr:    "all({{spec.template.spec.containers}}, {all({{spec.template.spec.containers}}, {all({{spec.template.spec.containers}}, {true})})})",
+			cel:     "self.spec.template.spec.containers.all(a, self.spec.template.spec.containers.all(b, self.spec.template.spec.containers.all(c, true)))",
+			wantErr: "cost of",
+		},
+	}
+	kubectl := NewKubectlMock(conformanceObjectsMock)
+	for _, tt := range tests {
+		for language, expression := range map[v1alpha1.ExpressionLanguage]string{v1alpha1.ExprLanguage: tt.expr, v1alpha1.CELLanguage: tt.cel} {
+			t.Run(tt.name+"/"+string(language), func(t *testing.T) {
+				lookup := NewObjectLookup(context.Background(), kubectl)
+				object := kubectl.(*kubectlMock).db[tt.object]
+				got, err := EvaluateRelatedExpressions([]v1alpha1.FieldExpression{{Expression: expression, Language: language}}, object, lookup)
+				if len(tt.wantErr) != 0 {
+					if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("EvaluateRelatedExpressions() error = %v, want %s", err, tt.wantErr)
+					}
+					return
+				}
+				if err != nil || got != tt.want {
+					t.Errorf("EvaluateRelatedExpressions() = %v, %v, want %v", got, err, tt.want)
+				}
+			})
+		}
+	}
+}
@@ -6,6 +6,17 @@ import (
 	"strings"
 )
 
+// clusterScopedKindsMock is the kinds of the cluster-scoped resources known to the mock keyed by resource or kind
+var clusterScopedKindsMock = map[string]string{
+	"ns":                       "Namespace",
+	"namespace":                "Namespace",
+	"pv":                       "PersistentVolume",
+	"persistentvolume":         "PersistentVolume",
+	"clusterrole":              "ClusterRole",
+	"node":                     "Node",
+	"customresourcedefinition": "CustomResourceDefinition",
+}
+
 type MockResourceProcessor struct {
 }
 
@@ -20,8 +31,16 @@ func NewMockFactory(mapper *Mapper) ArgsProcessor {
 func (m *MockResourceProcessor) MappingFor(resourceOrKindArg string) (*meta.RESTMapping, error) {
 	lResourceOrKindArgs := strings.ToLower(resourceOrKindArg)
 	tResourceOrKindArgs := strings.Title(lResourceOrKindArgs)
+	if kind, ok := clusterScopedKindsMock[lResourceOrKindArgs]; ok {
+		return &meta.RESTMapping{
+			Resource:         schema.GroupVersionResource{Resource: lResourceOrKindArgs},
+			GroupVersionKind: schema.GroupVersionKind{Kind: kind},
+			Scope:            meta.RESTScopeRoot,
+		}, nil
+	}
 	return &meta.RESTMapping{
 		Resource:         schema.GroupVersionResource{Resource: lResourceOrKindArgs},
 		GroupVersionKind: schema.GroupVersionKind{Kind: tResourceOrKindArgs},
+		Scope:            meta.RESTScopeNamespace,
 	}, nil
 }
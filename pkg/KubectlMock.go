@@ -21,12 +21,27 @@ import (
 	"encoding/json"
 	"fmt"
 	jsonpatch "github.com/evanphx/json-patch"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
 	"strings"
+	"sync"
 )
 
 type kubectlMock struct {
-	db map[string]unstructured.Unstructured
+	db       map[string]unstructured.Unstructured
+	watchers []mockWatcher
+	lock     sync.Mutex
+}
+
+type mockWatcher struct {
+	gvr     schema.GroupVersionResource
+	watcher *watch.RaceFreeFakeWatcher
 }
 
 func NewKubectlMock(bulk string) KubectlCmd {
@@ -64,28 +79,18 @@ func (k *kubectlMock) key(obj unstructured.Unstructured) string {
 }
 
 func (k *kubectlMock) ListResources(ctx context.Context, r *ListRequest) (*ListResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	var response []unstructured.Unstructured
-	labels := strings.Split(r.LabelSelector, ",")
-	labelSelectors := make(map[string]string, 0)
-	for _, label := range labels {
-		if label == "" {
-			continue
-		}
-		keyVal := strings.Split(label, "=")
-		labelSelectors[strings.TrimSpace(keyVal[0])] = strings.TrimSpace(keyVal[1])
+	selector, err := labels.Parse(r.LabelSelector)
+	if err != nil {
+		return nil, err
 	}
 	for _, item := range k.db {
-		found := true
-		for key, val := range labelSelectors {
-			labels := item.GetLabels()
-			if labels[key] != val {
-				found = false
-			}
-		}
-		if item.GetNamespace() != r.Namespace {
-			found = false
-		}
-		if !found {
+		if item.GetNamespace() != r.Namespace || !selector.Matches(labels.Set(item.GetLabels())) {
 			continue
 		}
 		response = append(response, item)
@@ -94,19 +99,37 @@ func (k *kubectlMock) ListResources(ctx context.Context, r *ListRequest) (*ListR
 }
 
 func (k *kubectlMock) GetResource(ctx context.Context, r *GetRequest) (*ManifestResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	key := fmt.Sprintf("/%s/%s/%s", r.Namespace, r.GroupVersionKind.Kind, r.Name)
 	obj := k.db[key]
 	return &ManifestResponse{obj}, nil
 }
 
 func (k *kubectlMock) DeleteResource(ctx context.Context, r *DeleteRequest) (*ManifestResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	key := fmt.Sprintf("/%s/%s/%s", r.Namespace, r.GroupVersionKind.Kind, r.Name)
-	obj := k.db[key]
+	obj, ok := k.db[key]
 	delete(k.db, key)
+	if ok {
+		k.notify(watch.Deleted, obj)
+	}
 	return &ManifestResponse{obj}, nil
 }
 
 func (k *kubectlMock) PatchResource(ctx context.Context, r *PatchRequest) (*ManifestResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
 	key := fmt.Sprintf("/%s/%s/%s", r.Namespace, r.GroupVersionKind.Kind, r.Name)
 
 	var k8sObj unstructured.Unstructured
@@ -116,12 +139,17 @@ func (k *kubectlMock) PatchResource(ctx context.Context, r *PatchRequest) (*Mani
 	}
 	patchJSON := []byte(r.Patch)
 
-	patch, err := jsonpatch.DecodePatch(patchJSON)
-	if err != nil {
-		panic(err)
-	}
 	obj, err := k8sObj.MarshalJSON()
-	modified, err := patch.Apply(obj)
+	var modified []byte
+	if r.PatchType == string(types.MergePatchType) {
+		modified, err = jsonpatch.MergePatch(obj, patchJSON)
+	} else {
+		patch, err := jsonpatch.DecodePatch(patchJSON)
+		if err != nil {
+			panic(err)
+		}
+		modified, err = patch.Apply(obj)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -131,5 +159,69 @@ func (k *kubectlMock) PatchResource(ctx context.Context, r *PatchRequest) (*Mani
 	if err != nil {
 		return nil, err
 	}
+	k.notify(watch.Modified, response)
 	return &ManifestResponse{response}, nil
 }
+
+func (k *kubectlMock) CreateResource(ctx context.Context, r *CreateRequest) (*ManifestResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	obj := *r.Manifest.DeepCopy()
+	obj.SetNamespace(r.Namespace)
+	obj.SetGroupVersionKind(r.GroupVersionKind)
+	if _, ok := k.db[k.key(obj)]; ok {
+		return nil, fmt.Errorf("object already exists")
+	}
+	k.db[k.key(obj)] = obj
+	k.notify(watch.Added, obj)
+	return &ManifestResponse{obj}, nil
+}
+
+// ListerWatcher lists the objects of the resource across namespaces and watches the changes made through the mock.
+// The kind of an object matches a resource named after it in lower case, in plural or as ns for namespaces.
+func (k *kubectlMock) ListerWatcher(gvr schema.GroupVersionResource) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			k.lock.Lock()
+			defer k.lock.Unlock()
+			list := &unstructured.UnstructuredList{}
+			for _, item := range k.db {
+				if matchesResource(item, gvr) {
+					list.Items = append(list.Items, *item.DeepCopy())
+				}
+			}
+			return list, nil
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			k.lock.Lock()
+			defer k.lock.Unlock()
+			watcher := watch.NewRaceFreeFake()
+			k.watchers = append(k.watchers, mockWatcher{gvr: gvr, watcher: watcher})
+			return watcher, nil
+		},
+	}
+}
+
+func (k *kubectlMock) notify(eventType watch.EventType, obj unstructured.Unstructured) {
+	for _, w := range k.watchers {
+		if !matchesResource(obj, w.gvr) {
+			continue
+		}
+		switch eventType {
+		case watch.Added:
+			w.watcher.Add(obj.DeepCopy())
+		case watch.Modified:
+			w.watcher.Modify(obj.DeepCopy())
+		case watch.Deleted:
+			w.watcher.Delete(obj.DeepCopy())
+		}
+	}
+}
+
+func matchesResource(obj unstructured.Unstructured, gvr schema.GroupVersionResource) bool {
+	resource, kind := strings.ToLower(gvr.Resource), strings.ToLower(obj.GetKind())
+	return resource == kind || resource == kind+"s" || (resource == "ns" && kind == "namespace")
+}
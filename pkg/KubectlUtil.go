@@ -21,15 +21,13 @@ import (
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
-	"k8s.io/client-go/discovery"
-	memory "k8s.io/client-go/discovery/cached"
 	"k8s.io/client-go/restmapper"
-	ctrl "sigs.k8s.io/controller-runtime"
 )
 
 type Mapper struct {
 	mapper             meta.RESTMapper
 	categoryExpanderFn func() (restmapper.CategoryExpander, error)
+	clients            *Clients
 }
 
 type ResourceProcessor struct {
@@ -51,20 +49,15 @@ type APIResourceInfo struct {
 	GroupVersionResource schema.GroupVersionResource
 }
 
-func NewMapperFactory() *Mapper {
-	restConfig := ctrl.GetConfigOrDie()
-	disco, err := discovery.NewDiscoveryClientForConfig(restConfig)
-	if err != nil {
-		panic(err)
-	}
+func NewMapperFactory(clients *Clients) *Mapper {
 	categoryExpanderFn := func() (restmapper.CategoryExpander, error) {
-		return restmapper.NewDiscoveryCategoryExpander(disco), err
+		return restmapper.NewDiscoveryCategoryExpander(clients.Discovery), nil
 	}
-	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(disco))
-	shortcutExpander := restmapper.NewShortcutExpander(mapper, disco)
+	shortcutExpander := restmapper.NewShortcutExpander(clients.RESTMapper, clients.Discovery)
 	return &Mapper{
 		mapper:             shortcutExpander,
 		categoryExpanderFn: categoryExpanderFn,
+		clients:            clients,
 	}
 }
 
@@ -78,14 +71,13 @@ func NewFactory(mapper *Mapper) ArgsProcessor {
 	}
 }
 
-func GetAllAPIResources(isNamespaced bool) ([]APIResourceInfo, error) {
-	restConfig := ctrl.GetConfigOrDie()
-	disco, err := discovery.NewDiscoveryClientForConfig(restConfig)
-	if err != nil {
-		return nil, err
+// GetAllAPIResources returns the preferred version of all the namespaced or cluster scoped resources from the cached
+// discovery information
+func (m *Mapper) GetAllAPIResources(isNamespaced bool) ([]APIResourceInfo, error) {
+	if m.clients == nil {
+		return nil, fmt.Errorf("discovery is not configured")
 	}
-
-	serverResources, err := disco.ServerPreferredResources()
+	serverResources, err := m.clients.Discovery.ServerPreferredResources()
 	if err != nil {
 		if len(serverResources) == 0 {
 			return nil, err
@@ -305,10 +297,21 @@ func ToGroupVersionResource(groupVersion string, apiResource *metav1.APIResource
 //	a.resources = append(a.resources, types...)
 //}
 
+// MappingFor returns the RESTMapping for the resource or kind, resetting the REST mapper and looking it up again when
+// it is not found as it may belong to a custom resource definition created after discovery was cached
+func (r *ResourceProcessor) MappingFor(resourceOrKindArg string) (*meta.RESTMapping, error) {
+	mapping, err := r.mappingFor(resourceOrKindArg)
+	if err != nil && r.mapper.clients != nil {
+		r.mapper.clients.Invalidate()
+		mapping, err = r.mappingFor(resourceOrKindArg)
+	}
+	return mapping, err
+}
+
 // mappingFor returns the RESTMapping for the Kind given, or the Kind referenced by the resource.
 // Prefers a fully specified GroupVersionResource match. If one is not found, we match on a fully
 // specified GroupVersionKind, or fallback to a match on GroupKind.
-func (r *ResourceProcessor) MappingFor(resourceOrKindArg string) (*meta.RESTMapping, error) {
+func (r *ResourceProcessor) mappingFor(resourceOrKindArg string) (*meta.RESTMapping, error) {
 	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(resourceOrKindArg)
 	gvk := schema.GroupVersionKind{}
 	restMapper := r.mapper.mapper
@@ -22,7 +22,7 @@ import (
 )
 
 func TestArgsProcessor_ResourceTypeOrNameArgs(t *testing.T) {
-	mapper := NewMapperFactory()
+	mapper := NewMapperFactory(NewClientsOrDie())
 	type fields struct {
 		mapper *Mapper
 	}
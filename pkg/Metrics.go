@@ -0,0 +1,235 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"io"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPrometheusQueries are the PromQL queries of the metrics read from Prometheus unless replaced
+var DefaultPrometheusQueries = map[string]string{
+	"cpu.total":  `sum(rate(container_cpu_usage_seconds_total{namespace="$namespace",pod=~"$name-.*",container!=""}[$window]))`,
+	"cpu.p95":    `quantile_over_time(0.95, sum(rate(container_cpu_usage_seconds_total{namespace="$namespace",pod=~"$name-.*",container!=""}[1m]))[$window:1m])`,
+	"memory.max": `max_over_time(sum(container_memory_working_set_bytes{namespace="$namespace",pod=~"$name-.*",container!=""})[$window:1m])`,
+}
+
+var podMetricsGVR = schema.GroupVersionResource{Group: "metrics.k8s.io", Version: "v1beta1", Resource: "pods"}
+
+// MetricsSource reads the usage metrics of a workload, keyed by metric name such as cpu.p95 and nested at the dots
+// so that they can be referenced as {{cpu.p95}} in expressions. Metrics without a value are left out.
+type MetricsSource interface {
+	GetMetrics(ctx context.Context, object unstructured.Unstructured) (map[string]interface{}, error)
+}
+
+func NewPrometheusMetrics(prometheusURL string, queries map[string]string, window time.Duration, client *http.Client) MetricsSource {
+	allQueries := make(map[string]string, len(DefaultPrometheusQueries)+len(queries))
+	for name, query := range DefaultPrometheusQueries {
+		allQueries[name] = query
+	}
+	for name, query := range queries {
+		allQueries[name] = query
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &prometheusMetrics{
+		url:     strings.TrimSuffix(prometheusURL, "/"),
+		queries: allQueries,
+		window:  window,
+		client:  client,
+	}
+}
+
+type prometheusMetrics struct {
+	url     string
+	queries map[string]string
+	window  time.Duration
+	client  *http.Client
+}
+
+func (p *prometheusMetrics) GetMetrics(ctx context.Context, object unstructured.Unstructured) (map[string]interface{}, error) {
+	replacer := strings.NewReplacer(
+		"$namespace", object.GetNamespace(),
+		"$name", regexp.QuoteMeta(object.GetName()),
+		"$window", formatPrometheusDuration(p.window),
+	)
+	metrics := make(map[string]interface{})
+	for name, query := range p.queries {
+		value, ok, err := p.query(ctx, replacer.Replace(query))
+		if err != nil {
+			return nil, fmt.Errorf("query %s: %w", name, err)
+		}
+		if ok {
+			setMetric(metrics, name, value)
+		}
+	}
+	return metrics, nil
+}
+
+// query runs an instant query and returns the value of its first sample, a query returning no sample has no value
+func (p *prometheusMetrics) query(ctx context.Context, query string) (float64, bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"/api/v1/query?query="+url.QueryEscape(query), nil)
+	if err != nil {
+		return 0, false, err
+	}
+	response, err := p.client.Do(request)
+	if err != nil {
+		return 0, false, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return 0, false, err
+	}
+	result := gjson.ParseBytes(body)
+	if response.StatusCode != http.StatusOK || result.Get("status").String() != "success" {
+		return 0, false, fmt.Errorf("prometheus returned %d: %s", response.StatusCode, result.Get("error").String())
+	}
+	var sample gjson.Result
+	switch result.Get("data.resultType").String() {
+	case "scalar":
+		sample = result.Get("data.result.1")
+	case "vector":
+		sample = result.Get("data.result.0.value.1")
+	default:
+		return 0, false, fmt.Errorf("unsupported result type %s", result.Get("data.resultType").String())
+	}
+	if !sample.Exists() {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseFloat(sample.String(), 64)
+	if err != nil || math.IsNaN(value) {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
+// formatPrometheusDuration formats the duration in whole seconds as accepted by PromQL
+func formatPrometheusDuration(d time.Duration) string {
+	return strconv.FormatInt(int64(d.Seconds()), 10) + "s"
+}
+
+func NewResourceMetrics(kubectl KubectlCmd) MetricsSource {
+	return &resourceMetrics{kubectl: kubectl}
+}
+
+// resourceMetrics reads the current usage of the pods of a workload from the metrics.k8s.io API, the metrics are
+// aggregated over the pods matching the selector of the workload. The API only serves the latest sample of each pod,
+// so that the metrics are those of the time of the run and not of a window.
+type resourceMetrics struct {
+	kubectl KubectlCmd
+}
+
+func (m *resourceMetrics) GetMetrics(ctx context.Context, object unstructured.Unstructured) (map[string]interface{}, error) {
+	rawSelector, found, err := unstructured.NestedMap(object.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, fmt.Errorf("%s %s has no pod selector", object.GetKind(), object.GetName())
+	}
+	labelSelector := &metav1.LabelSelector{}
+	if err = runtime.DefaultUnstructuredConverter.FromUnstructured(rawSelector, labelSelector); err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	request := &ListRequest{
+		Namespace:            object.GetNamespace(),
+		GroupVersionResource: podMetricsGVR,
+		ListOptions:          metav1.ListOptions{LabelSelector: selector.String()},
+	}
+	resp, err := m.kubectl.ListResources(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	var cpu, memory []float64
+	for _, podMetrics := range resp.Manifests {
+		if podMetrics.GetKind() != "PodMetrics" {
+			continue
+		}
+		containers, _, _ := unstructured.NestedSlice(podMetrics.Object, "containers")
+		podCPU, podMemory := 0.0, 0.0
+		for _, container := range containers {
+			usage, _, _ := unstructured.NestedStringMap(container.(map[string]interface{}), "usage")
+			podCPU += parseQuantity(usage["cpu"])
+			podMemory += parseQuantity(usage["memory"])
+		}
+		cpu = append(cpu, podCPU)
+		memory = append(memory, podMemory)
+	}
+	metrics := map[string]interface{}{"pods": len(cpu)}
+	if len(cpu) > 0 {
+		metrics["cpu"] = aggregate(cpu)
+		metrics["memory"] = aggregate(memory)
+	}
+	return metrics, nil
+}
+
+func parseQuantity(value string) float64 {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return 0
+	}
+	return quantity.AsApproximateFloat64()
+}
+
+// aggregate returns the total, average, maximum and 95th percentile of the values of the pods, podP95 being the
+// percentile across the pods at a single time and not over time as cpu.p95 of Prometheus
+func aggregate(values []float64) map[string]interface{} {
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+	total := 0.0
+	for _, value := range sorted {
+		total += value
+	}
+	return map[string]interface{}{
+		"total":  total,
+		"avg":    total / float64(len(sorted)),
+		"max":    sorted[len(sorted)-1],
+		"podP95": sorted[int(math.Ceil(0.95*float64(len(sorted))))-1],
+	}
+}
+
+// setMetric sets the metric in the metrics, nested at the dots of its name
+func setMetric(metrics map[string]interface{}, name string, value float64) {
+	path := strings.Split(name, ".")
+	for _, key := range path[:len(path)-1] {
+		nested, ok := metrics[key].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			metrics[key] = nested
+		}
+		metrics = nested
+	}
+	metrics[path[len(path)-1]] = value
+}
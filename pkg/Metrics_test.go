@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const podMetricsMock = `
+[
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev"}, "spec": {"replicas": 2, "selector": {"matchLabels": {"app": "web"}}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "api", "namespace": "dev"}, "spec": {"replicas": 1, "selector": {"matchLabels": {"app": "api"}}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker", "namespace": "dev"}, "spec": {"replicas": 1}},
+  {"apiVersion": "metrics.k8s.io/v1beta1", "kind": "PodMetrics", "metadata": {"name": "web-1", "namespace": "dev", "labels": {"app": "web"}},
+   "containers": [{"name": "app", "usage": {"cpu": "500m", "memory": "64Mi"}}, {"name": "proxy", "usage": {"cpu": "250m", "memory": "64Mi"}}]},
+  {"apiVersion": "metrics.k8s.io/v1beta1", "kind": "PodMetrics", "metadata": {"name": "web-2", "namespace": "dev", "labels": {"app": "web"}},
+   "containers": [{"name": "app", "usage": {"cpu": "250m", "memory": "128Mi"}}]}
+]`
+
+func TestPrometheusMetrics_GetMetrics(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("query")
+		queries = append(queries, query)
+		switch {
+		case strings.HasPrefix(query, "scalar"):
+			fmt.Fprint(w, `{"status": "success", "data": {"resultType": "scalar", "result": [1700000000, "2"]}}`)
+		case strings.HasPrefix(query, "empty"):
+			fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": []}}`)
+		case strings.HasPrefix(query, "invalid"):
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"status": "error", "errorType": "bad_data", "error": "parse error"}`)
+		default:
+			fmt.Fprint(w, `{"status": "success", "data": {"resultType": "vector", "result": [{"metric": {}, "value": [1700000000, "0.25"]}]}}`)
+		}
+	}))
+	defer server.Close()
+	object := unstructured.Unstructured{}
+	object.SetNamespace("dev")
+	object.SetName("web.v1")
+	tests := []struct {
+		name        string
+		queries     map[string]string
+		want        map[string]interface{}
+		wantQueries []string
+		wantErr     bool
+	}{
+		{
+			name:        "default queries",
+			want:        map[string]interface{}{"cpu": map[string]interface{}{"total": 0.25, "p95": 0.25}, "memory": map[string]interface{}{"max": 0.25}},
+			wantQueries: []string{`sum(rate(container_cpu_usage_seconds_total{namespace="dev",pod=~"web\.v1-.*",container!=""}[600s]))`},
+		},
+		{
+			name:        "scalar and empty results",
+			queries:     map[string]string{"cpu.total": "scalar(1)", "cpu.p95": "empty", "memory.max": "empty", "requests": `vector(sum(requests{namespace="$namespace"}))`},
+			want:        map[string]interface{}{"cpu": map[string]interface{}{"total": 2.0}, "requests": 0.25},
+			wantQueries: []string{`vector(sum(requests{namespace="dev"}))`},
+		},
+		{
+			name:    "failed query",
+			queries: map[string]string{"cpu.total": "invalid"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries = nil
+			got, err := NewPrometheusMetrics(server.URL+"/", tt.queries, 10*time.Minute, server.Client()).GetMetrics(context.Background(), object)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMetrics() = %v, want %v", got, tt.want)
+			}
+			for _, want := range tt.wantQueries {
+				found := false
+				for _, query := range queries {
+					found = found || query == want
+				}
+				if !found {
+					t.Errorf("GetMetrics() queries = %v, want %s", queries, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResourceMetrics_GetMetrics(t *testing.T) {
+	mb := float64(1 << 20)
+	tests := []struct {
+		name    string
+		object  string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:   "pods of the workload",
+			object: "/dev/Deployment/web",
+			want: map[string]interface{}{
+				"pods":   2,
+				"cpu":    map[string]interface{}{"total": 1.0, "avg": 0.5, "max": 0.75, "podP95": 0.75},
+				"memory": map[string]interface{}{"total": 256 * mb, "avg": 128 * mb, "max": 128 * mb, "podP95": 128 * mb},
+			},
+		},
+		{
+			name:   "workload without pods",
+			object: "/dev/Deployment/api",
+			want:   map[string]interface{}{"pods": 0},
+		},
+		{
+			name:    "workload without a selector",
+			object:  "/dev/Deployment/worker",
+			wantErr: true,
+		},
+	}
+	kubectl := NewKubectlMock(podMetricsMock)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := kubectl.(*kubectlMock).db[tt.object]
+			got, err := NewResourceMetrics(kubectl).GetMetrics(context.Background(), object)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetMetrics() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetMetrics() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,423 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"github.com/tidwall/gjson"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"strings"
+	"sync"
+	"time"
+)
+
+// podSpecPaths is the path to the pod spec of the kinds whose pods can reference other objects
+var podSpecPaths = map[string]string{
+	"Pod":         "spec",
+	"Deployment":  "spec.template.spec",
+	"StatefulSet": "spec.template.spec",
+	"ReplicaSet":  "spec.template.spec",
+	"Rollout":     "spec.template.spec",
+	"DaemonSet":   "spec.template.spec",
+	"Job":         "spec.template.spec",
+	"CronJob":     "spec.jobTemplate.spec.template.spec",
+}
+
+// relatedResource is a resource listed to find the objects related to an object
+type relatedResource struct {
+	schema.GroupVersionResource
+	kind string
+	// optional is set for the resources of custom resource definitions which may not be installed, they are not
+	// cached and are looked up as having no objects when not found
+	optional bool
+}
+
+// claimTemplate is a volume claim template of a stateful set, whose claims are named <template>-<statefulset>-<ordinal>
+// and are kept when the stateful set is scaled down
+type claimTemplate struct {
+	prefix      string
+	statefulSet string
+}
+
+var (
+	podResource       = relatedResource{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, kind: "Pod"}
+	endpointsResource = relatedResource{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "endpoints"}, kind: "Endpoints"}
+	workloadResources = []relatedResource{
+		podResource,
+		{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, kind: "Deployment"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, kind: "ReplicaSet"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, kind: "StatefulSet"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, kind: "DaemonSet"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, kind: "Job"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, kind: "CronJob"},
+		{GroupVersionResource: schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}, kind: "Rollout", optional: true},
+	}
+	namespaceGVK = schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}
+)
+
+// referenceQueries is the queries on a pod spec returning the names of the objects of each kind it references
+var referenceQueries = map[string][]string{
+	"PersistentVolumeClaim": {
+		"volumes.#.persistentVolumeClaim.claimName",
+	},
+	"ConfigMap": {
+		"volumes.#.configMap.name",
+		"volumes.#.projected.sources.#.configMap.name",
+		"containers.#.env.#.valueFrom.configMapKeyRef.name",
+		"containers.#.envFrom.#.configMapRef.name",
+		"initContainers.#.env.#.valueFrom.configMapKeyRef.name",
+		"initContainers.#.envFrom.#.configMapRef.name",
+	},
+	"Secret": {
+		"volumes.#.secret.secretName",
+		"volumes.#.projected.sources.#.secret.name",
+		"containers.#.env.#.valueFrom.secretKeyRef.name",
+		"containers.#.envFrom.#.secretRef.name",
+		"initContainers.#.env.#.valueFrom.secretKeyRef.name",
+		"initContainers.#.envFrom.#.secretRef.name",
+		"imagePullSecrets.#.name",
+	},
+}
+
+// ObjectLookup looks up the objects related to the objects evaluated by the expressions of a selector. Related objects
+// are only fetched when an expression asks for them and each of them, or each list, is fetched once for the lifetime
+// of the lookup, so that evaluating the expressions against all the selected objects does not query them repeatedly.
+// They are fetched within the evaluation asking for them and stop along with it.
+type ObjectLookup struct {
+	ctx     context.Context
+	kubectl KubectlCmd
+	lock    sync.Mutex
+	objects map[string]*unstructured.Unstructured
+	lists   map[string][]unstructured.Unstructured
+	// references is the referencing objects, as Kind/name, keyed by namespace and then by Kind/name of the
+	// referenced object
+	references map[string]map[string][]string
+	// claimTemplates is the volume claim templates of the stateful sets keyed by namespace
+	claimTemplates map[string][]claimTemplate
+}
+
+func NewObjectLookup(ctx context.Context, kubectl KubectlCmd) *ObjectLookup {
+	return &ObjectLookup{
+		ctx:            ctx,
+		kubectl:        kubectl,
+		objects:        make(map[string]*unstructured.Unstructured),
+		lists:          make(map[string][]unstructured.Unstructured),
+		references:     make(map[string]map[string][]string),
+		claimTemplates: make(map[string][]claimTemplate),
+	}
+}
+
+// RelatedResources returns the resources listed by the functions used in the expression, apart from the optional ones
+func RelatedResources(expression string) []schema.GroupVersionResource {
+	var resources []schema.GroupVersionResource
+	if strings.Contains(expression, "ReferencingPods(") || strings.Contains(expression, "ReferencingWorkloads(") {
+		for _, resource := range workloadResources {
+			if !resource.optional {
+				resources = append(resources, resource.GroupVersionResource)
+			}
+		}
+	}
+	if strings.Contains(expression, "EndpointAddresses(") {
+		resources = append(resources, endpointsResource.GroupVersionResource)
+	}
+	return resources
+}
+
+// functions returns the functions of the expressions looking up the objects related to the object within the
+// evaluation
+func (l *ObjectLookup) functions(e *evaluation, object unstructured.Unstructured) map[string]interface{} {
+	return map[string]interface{}{
+		// Namespace returns the namespace of the object, nil for cluster-scoped objects
+		"Namespace": func() (map[string]interface{}, error) {
+			namespace, err := l.namespace(e, object)
+			if err != nil || namespace == nil {
+				return nil, err
+			}
+			return namespace.Object, nil
+		},
+		// NamespaceLabels returns the labels of the namespace of the object
+		"NamespaceLabels": func() (map[string]string, error) {
+			namespace, err := l.namespace(e, object)
+			if err != nil || namespace == nil {
+				return map[string]string{}, err
+			}
+			return namespace.GetLabels(), nil
+		},
+		// Owner returns the controller of the object, or its first owner, nil when it has none
+		"Owner": func() (map[string]interface{}, error) {
+			owner, err := l.owner(e, object)
+			if err != nil || owner == nil {
+				return nil, err
+			}
+			return owner.Object, nil
+		},
+		// ReferencingPods returns the names of the pods using the persistent volume claim, config map or secret
+		"ReferencingPods": func() ([]string, error) {
+			referencing, err := l.referencing(e, object)
+			if err != nil {
+				return nil, err
+			}
+			var pods []string
+			for _, reference := range referencing {
+				if strings.HasPrefix(reference, "Pod/") {
+					pods = append(pods, strings.TrimPrefix(reference, "Pod/"))
+				}
+			}
+			return pods, nil
+		},
+		// ReferencingWorkloads returns the pods and workloads, as Kind/name, whose pods use the persistent volume
+		// claim, config map or secret, including workloads scaled down to zero and the stateful sets whose volume
+		// claim templates the claim was created from
+		"ReferencingWorkloads": func() ([]string, error) {
+			return l.referencing(e, object)
+		},
+		// EndpointAddresses returns the number of ready addresses of the endpoints of the service
+		"EndpointAddresses": func() (int, error) {
+			return l.endpointAddresses(e, object)
+		},
+	}
+}
+
+func (l *ObjectLookup) namespace(e *evaluation, object unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	if len(object.GetNamespace()) == 0 {
+		return nil, nil
+	}
+	return l.get(e, namespaceGVK, "", object.GetNamespace())
+}
+
+func (l *ObjectLookup) owner(e *evaluation, object unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	ownerReferences := object.GetOwnerReferences()
+	if len(ownerReferences) == 0 {
+		return nil, nil
+	}
+	owner := ownerReferences[0]
+	if controller := metav1.GetControllerOfNoCopy(&object); controller != nil {
+		owner = *controller
+	}
+	return l.get(e, schema.FromAPIVersionAndKind(owner.APIVersion, owner.Kind), object.GetNamespace(), owner.Name)
+}
+
+// referencing returns the objects, as Kind/name, whose pods reference the object
+func (l *ObjectLookup) referencing(e *evaluation, object unstructured.Unstructured) ([]string, error) {
+	if _, ok := referenceQueries[object.GetKind()]; !ok {
+		return nil, fmt.Errorf("objects of kind %s are not referenced by pods", object.GetKind())
+	}
+	l.lock.Lock()
+	references, ok := l.references[object.GetNamespace()]
+	claimTemplates := l.claimTemplates[object.GetNamespace()]
+	l.lock.Unlock()
+	if !ok {
+		references = make(map[string][]string)
+		for _, resource := range workloadResources {
+			workloads, err := l.list(e, resource, object.GetNamespace())
+			if err != nil {
+				return nil, err
+			}
+			for _, workload := range workloads {
+				indexReferences(references, workload)
+				claimTemplates = append(claimTemplates, getClaimTemplates(workload)...)
+			}
+		}
+		l.lock.Lock()
+		l.references[object.GetNamespace()] = references
+		l.claimTemplates[object.GetNamespace()] = claimTemplates
+		l.lock.Unlock()
+	}
+	referencing := references[object.GetKind()+"/"+object.GetName()]
+	if object.GetKind() != "PersistentVolumeClaim" {
+		return referencing, nil
+	}
+	for _, template := range claimTemplates {
+		ordinal := strings.TrimPrefix(object.GetName(), template.prefix)
+		if ordinal == object.GetName() || !isOrdinal(ordinal) || containsString(referencing, template.statefulSet) {
+			continue
+		}
+		referencing = append(referencing, template.statefulSet)
+	}
+	return referencing, nil
+}
+
+// indexReferences adds the objects referenced by the pods of the workload to the references
+func indexReferences(references map[string][]string, workload unstructured.Unstructured) {
+	path, ok := podSpecPaths[workload.GetKind()]
+	if !ok {
+		return
+	}
+	j, err := workload.MarshalJSON()
+	if err != nil {
+		return
+	}
+	podSpec := gjson.GetBytes(j, path).Raw
+	referencing := workload.GetKind() + "/" + workload.GetName()
+	for kind, queries := range referenceQueries {
+		found := make(map[string]bool)
+		for _, query := range queries {
+			for _, name := range flatten(gjson.Get(podSpec, query)) {
+				if found[name] {
+					continue
+				}
+				found[name] = true
+				references[kind+"/"+name] = append(references[kind+"/"+name], referencing)
+			}
+		}
+	}
+}
+
+// getClaimTemplates returns the volume claim templates of a stateful set
+func getClaimTemplates(workload unstructured.Unstructured) []claimTemplate {
+	if workload.GetKind() != "StatefulSet" {
+		return nil
+	}
+	j, err := workload.MarshalJSON()
+	if err != nil {
+		return nil
+	}
+	var templates []claimTemplate
+	for _, name := range flatten(gjson.GetBytes(j, "spec.volumeClaimTemplates.#.metadata.name")) {
+		templates = append(templates, claimTemplate{
+			prefix:      name + "-" + workload.GetName() + "-",
+			statefulSet: "StatefulSet/" + workload.GetName(),
+		})
+	}
+	return templates
+}
+
+// isOrdinal reports if s is the ordinal of a stateful set pod
+func isOrdinal(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// flatten returns the strings of the possibly nested arrays returned by a query
+func flatten(result gjson.Result) []string {
+	if !result.IsArray() {
+		if result.Type == gjson.String && len(result.Str) != 0 {
+			return []string{result.Str}
+		}
+		return nil
+	}
+	var values []string
+	for _, item := range result.Array() {
+		values = append(values, flatten(item)...)
+	}
+	return values
+}
+
+func (l *ObjectLookup) endpointAddresses(e *evaluation, object unstructured.Unstructured) (int, error) {
+	if object.GetKind() != "Service" {
+		return 0, fmt.Errorf("objects of kind %s do not have endpoints", object.GetKind())
+	}
+	endpoints, err := l.list(e, endpointsResource, object.GetNamespace())
+	if err != nil {
+		return 0, err
+	}
+	for _, e := range endpoints {
+		if e.GetName() != object.GetName() {
+			continue
+		}
+		j, err := e.MarshalJSON()
+		if err != nil {
+			return 0, err
+		}
+		return len(flatten(gjson.GetBytes(j, "subsets.#.addresses.#.ip"))), nil
+	}
+	return 0, nil
+}
+
+func (l *ObjectLookup) get(e *evaluation, gvk schema.GroupVersionKind, namespace, name string) (*unstructured.Unstructured, error) {
+	key := fmt.Sprintf("/%s/%s/%s", namespace, gvk.String(), name)
+	l.lock.Lock()
+	object, ok := l.objects[key]
+	l.lock.Unlock()
+	if ok {
+		return object, nil
+	}
+	request := &GetRequest{
+		Name:             name,
+		Namespace:        namespace,
+		GroupVersionKind: gvk,
+	}
+	start := time.Now()
+	resp, err := l.kubectl.GetResource(e.ctx, request)
+	e.addWaiting(start)
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil && len(resp.Manifest.Object) != 0 {
+		object = &resp.Manifest
+	}
+	l.lock.Lock()
+	l.objects[key] = object
+	l.lock.Unlock()
+	return object, nil
+}
+
+// list returns the objects of the resource in the namespace, only keeping the objects of its kind in case the
+// objects of other kinds are returned as well
+func (l *ObjectLookup) list(e *evaluation, resource relatedResource, namespace string) ([]unstructured.Unstructured, error) {
+	key := fmt.Sprintf("/%s/%s", namespace, resource.GroupVersionResource.String())
+	l.lock.Lock()
+	objects, ok := l.lists[key]
+	l.lock.Unlock()
+	if ok {
+		return objects, nil
+	}
+	request := &ListRequest{
+		Namespace:            namespace,
+		GroupVersionResource: resource.GroupVersionResource,
+	}
+	start := time.Now()
+	resp, err := l.kubectl.ListResources(e.ctx, request)
+	e.addWaiting(start)
+	if err != nil {
+		// the custom resource definition of an optional resource is not installed
+		if !resource.optional || !(errors.IsNotFound(err) || meta.IsNoMatchError(err)) {
+			return nil, err
+		}
+		resp = &ListResponse{}
+	}
+	for _, object := range resp.Manifests {
+		if object.GetKind() == resource.kind {
+			objects = append(objects, object)
+		}
+	}
+	l.lock.Lock()
+	l.lists[key] = objects
+	l.lock.Unlock()
+	return objects, nil
+}
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"context"
+	goerrors "errors"
+	"github.com/devtron-labs/winter-soldier/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"testing"
+	"time"
+)
+
+const relatedObjectsMock = `
+[
+  {"apiVersion": "v1", "kind": "Namespace", "metadata": {"name": "dev", "labels": {"env": "qa"}}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "orphan", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "settings", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "unused", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "token", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "web", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "idle", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "Endpoints", "metadata": {"name": "web", "namespace": "dev"}, "subsets": [{"addresses": [{"ip": "10.0.0.1"}, {"ip": "10.0.0.2"}]}]},
+  {"apiVersion": "v1", "kind": "Endpoints", "metadata": {"name": "idle", "namespace": "dev"}, "subsets": []},
+  {"apiVersion": "apps/v1", "kind": "ReplicaSet", "metadata": {"name": "web-1", "namespace": "dev"},
+   "spec": {"replicas": 0, "template": {"spec": {"volumes": [{"name": "config", "configMap": {"name": "web-config"}}], "containers": [{"name": "app"}]}}}},
+  {"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "web-config", "namespace": "dev"}},
+  {"apiVersion": "apps/v1", "kind": "StatefulSet", "metadata": {"name": "db", "namespace": "dev"},
+   "spec": {"replicas": 0, "template": {"spec": {"containers": [{"name": "db"}]}}, "volumeClaimTemplates": [{"metadata": {"name": "data"}}]}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data-db-0", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "PersistentVolumeClaim", "metadata": {"name": "data-db-backup", "namespace": "dev"}},
+  {"apiVersion": "argoproj.io/v1alpha1", "kind": "Rollout", "metadata": {"name": "canary", "namespace": "dev"},
+   "spec": {"replicas": 0, "template": {"spec": {"containers": [{"name": "app", "envFrom": [{"secretRef": {"name": "canary-token"}}]}]}}}},
+  {"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "canary-token", "namespace": "dev"}},
+  {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "web-1-a", "namespace": "dev", "ownerReferences": [{"apiVersion": "apps/v1", "kind": "ReplicaSet", "name": "web-1", "controller": true}]},
+   "spec": {"volumes": [{"name": "data", "persistentVolumeClaim": {"claimName": "data"}}], "containers": [{"name": "app", "env": [{"name": "TOKEN", "valueFrom": {"secretKeyRef": {"name": "token", "key": "token"}}}]}]}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker", "namespace": "dev"},
+   "spec": {"replicas": 0, "template": {"spec": {"containers": [{"name": "app", "envFrom": [{"configMapRef": {"name": "settings"}}]}]}}}}
+]`
+
+func TestObjectLookup_functions(t *testing.T) {
+	tests := []struct {
+		name       string
+		object     string
+		expression string
+		want       bool
+	}{
+		{name: "namespace label", object: "/dev/PersistentVolumeClaim/data", expression: "NamespaceLabels().env == 'qa'", want: true},
+		{name: "missing namespace label", object: "/dev/PersistentVolumeClaim/data", expression: "NamespaceLabels().team == 'web'"},
+		{name: "namespace", object: "/dev/PersistentVolumeClaim/data", expression: "Namespace().metadata.name == 'dev'", want: true},
+		{name: "owner", object: "/dev/Pod/web-1-a", expression: "Owner().kind == 'ReplicaSet'", want: true},
+		{name: "no owner", object: "/dev/PersistentVolumeClaim/data", expression: "Owner() == nil", want: true},
+		{name: "mounted claim", object: "/dev/PersistentVolumeClaim/data", expression: "len(ReferencingPods()) == 0"},
+		{name: "unmounted claim", object: "/dev/PersistentVolumeClaim/orphan", expression: "len(ReferencingPods()) == 0", want: true},
+		{name: "config map referenced by a scaled down workload", object: "/dev/ConfigMap/settings", expression: "len(ReferencingWorkloads()) == 0"},
+		{name: "config map referenced by a workload", object: "/dev/ConfigMap/settings", expression: "'Deployment/worker' in ReferencingWorkloads()", want: true},
+		{name: "unreferenced config map", object: "/dev/ConfigMap/unused", expression: "len(ReferencingWorkloads()) == 0", want: true},
+		{name: "secret referenced through env", object: "/dev/Secret/token", expression: "'web-1-a' in ReferencingPods()", want: true},
+		{name: "claim of a stateful set scaled to zero", object: "/dev/PersistentVolumeClaim/data-db-0", expression: "ReferencingWorkloads() == ['StatefulSet/db']", want: true},
+		{name: "claim not created from a claim template", object: "/dev/PersistentVolumeClaim/data-db-backup", expression: "len(ReferencingWorkloads()) == 0", want: true},
+		{name: "config map referenced by a replica set", object: "/dev/ConfigMap/web-config", expression: "'ReplicaSet/web-1' in ReferencingWorkloads()", want: true},
+		{name: "secret referenced by a rollout", object: "/dev/Secret/canary-token", expression: "'Rollout/canary' in ReferencingWorkloads()", want: true},
+		{name: "service with endpoints", object: "/dev/Service/web", expression: "EndpointAddresses() == 2", want: true},
+		{name: "service without endpoints", object: "/dev/Service/idle", expression: "EndpointAddresses() == 0", want: true},
+		{name: "unsupported kind", object: "/dev/Service/web", expression: "len(ReferencingPods()) == 0"},
+	}
+	kubectl := &countingKubectl{KubectlCmd: NewKubectlMock(relatedObjectsMock)}
+	lookup := NewObjectLookup(context.Background(), kubectl)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := getLookupMockObject(t, kubectl, tt.object)
+			if got := RelatedExpressionEvaluator(tt.expression, object, lookup); got != tt.want {
+				t.Errorf("RelatedExpressionEvaluator() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// pods, deployments, replica sets, stateful sets, daemon sets, jobs, cron jobs and rollouts are listed for the
+	// references and then endpoints, each of them once
+	if kubectl.lists != 9 {
+		t.Errorf("ListResources() calls = %d, want 9", kubectl.lists)
+	}
+}
+
+type missingRolloutsKubectl struct {
+	KubectlCmd
+}
+
+func (k *missingRolloutsKubectl) ListResources(ctx context.Context, r *ListRequest) (*ListResponse, error) {
+	if r.GroupVersionResource.Resource == "rollouts" {
+		return nil, errors.NewNotFound(r.GroupVersionResource.GroupResource(), "")
+	}
+	return k.KubectlCmd.ListResources(ctx, r)
+}
+
+func TestObjectLookup_missingOptionalResource(t *testing.T) {
+	kubectl := NewKubectlMock(relatedObjectsMock)
+	lookup := NewObjectLookup(context.Background(), &missingRolloutsKubectl{KubectlCmd: kubectl})
+	object := kubectl.(*kubectlMock).db["/dev/ConfigMap/settings"]
+	got, err := EvaluateRelatedExpressions([]v1alpha1.FieldExpression{{Expression: "'Deployment/worker' in ReferencingWorkloads()"}}, object, lookup)
+	if err != nil || !got {
+		t.Errorf("EvaluateRelatedExpressions() = %v, %v, want true without rollouts installed", got, err)
+	}
+}
+
+// blockingKubectl blocks the lookups until their context is done
+type blockingKubectl struct {
+	KubectlCmd
+}
+
+func (k *blockingKubectl) GetResource(ctx context.Context, r *GetRequest) (*ManifestResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestObjectLookup_cancelledLookup(t *testing.T) {
+	MaxEvaluationTime = 50 * time.Millisecond
+	defer func() { MaxEvaluationTime = DefaultMaxEvaluationTime }()
+	kubectl := NewKubectlMock(relatedObjectsMock)
+	lookup := NewObjectLookup(context.Background(), &blockingKubectl{KubectlCmd: kubectl})
+	object := kubectl.(*kubectlMock).db["/dev/ConfigMap/settings"]
+	for _, language := range []v1alpha1.ExpressionLanguage{v1alpha1.ExprLanguage, v1alpha1.CELLanguage} {
+		_, err := EvaluateRelatedExpressions([]v1alpha1.FieldExpression{{Expression: "NamespaceLabels().env == 'dev'", Language: language}}, object, lookup)
+		if !goerrors.Is(err, ErrExpressionTimeout) {
+			t.Errorf("EvaluateRelatedExpressions() %s error = %v, want %v", language, err, ErrExpressionTimeout)
+		}
+	}
+}
+
+func TestRelatedResources(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		want       int
+	}{
+		{name: "no related objects", expression: "{{spec.replicas}} == 0", want: 0},
+		{name: "namespace", expression: "NamespaceLabels().env == 'qa'", want: 0},
+		{name: "references", expression: "len(ReferencingPods()) == 0", want: 7},
+		{name: "endpoints", expression: "EndpointAddresses() == 0", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RelatedResources(tt.expression); len(got) != tt.want {
+				t.Errorf("RelatedResources() = %v, want %d resources", got, tt.want)
+			}
+		})
+	}
+}
+
+func getLookupMockObject(t *testing.T, kubectl KubectlCmd, key string) unstructured.Unstructured {
+	mock := kubectl.(*countingKubectl).KubectlCmd.(*kubectlMock)
+	object, ok := mock.db[key]
+	if !ok {
+		t.Fatalf("object %s not found", key)
+	}
+	return object
+}
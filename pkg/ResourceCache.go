@@ -0,0 +1,212 @@
+/*
+Copyright 2021 Devtron Labs Pvt Ltd.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/client-go/tools/cache"
+	"sync"
+	"time"
+)
+
+const (
+	labelIndex       = "label"
+	cacheSyncTimeout = 30 * time.Second
+)
+
+// CachedKubectl serves ListResources from shared informers for the resources retained by the hibernators and delegates
+// everything else, including lists of resources not retained, to the wrapped KubectlCmd
+type CachedKubectl interface {
+	KubectlCmd
+	// Retain records the resources listed by owner, starting the informers of newly needed resources and stopping the
+	// informers of resources no longer needed by any owner
+	Retain(owner string, resources []schema.GroupVersionResource)
+	// Release drops the resources needed by owner
+	Release(owner string)
+	// AddEventHandler registers handler with the informers of all the retained resources, including the ones
+	// retained later
+	AddEventHandler(handler cache.ResourceEventHandler)
+}
+
+func NewCachedKubectl(kubectl KubectlCmd) CachedKubectl {
+	return &cachedKubectl{
+		KubectlCmd: kubectl,
+		informers:  make(map[schema.GroupVersionResource]*resourceInformer),
+		owners:     make(map[string][]schema.GroupVersionResource),
+	}
+}
+
+type cachedKubectl struct {
+	KubectlCmd
+	informers map[schema.GroupVersionResource]*resourceInformer
+	owners    map[string][]schema.GroupVersionResource
+	handlers  []cache.ResourceEventHandler
+	lock      sync.Mutex
+}
+
+type resourceInformer struct {
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+	// waited is set once the informer was waited upon to sync, later lists fall back to the wrapped KubectlCmd
+	// without waiting while it is not synced, for example when the resource is not served
+	waited bool
+}
+
+func (c *cachedKubectl) Retain(owner string, resources []schema.GroupVersionResource) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.owners[owner] = resources
+	for _, gvr := range resources {
+		if _, ok := c.informers[gvr]; ok {
+			continue
+		}
+		informer := cache.NewSharedIndexInformer(c.KubectlCmd.ListerWatcher(gvr), &unstructured.Unstructured{}, 0, cache.Indexers{
+			cache.NamespaceIndex: cache.MetaNamespaceIndexFunc,
+			labelIndex:           labelIndexFunc,
+		})
+		for _, handler := range c.handlers {
+			informer.AddEventHandler(handler)
+		}
+		stop := make(chan struct{})
+		go informer.Run(stop)
+		c.informers[gvr] = &resourceInformer{informer: informer, stop: stop}
+	}
+	c.stopUnused()
+}
+
+func (c *cachedKubectl) Release(owner string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.owners, owner)
+	c.stopUnused()
+}
+
+func (c *cachedKubectl) AddEventHandler(handler cache.ResourceEventHandler) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.handlers = append(c.handlers, handler)
+	for _, informer := range c.informers {
+		informer.informer.AddEventHandler(handler)
+	}
+}
+
+func (c *cachedKubectl) stopUnused() {
+	used := make(map[schema.GroupVersionResource]bool)
+	for _, resources := range c.owners {
+		for _, gvr := range resources {
+			used[gvr] = true
+		}
+	}
+	for gvr, informer := range c.informers {
+		if !used[gvr] {
+			close(informer.stop)
+			delete(c.informers, gvr)
+		}
+	}
+}
+
+// ListResources lists from the informer of the resource when it is retained and synced. Field selectors are not
+// indexed and are always listed through the wrapped KubectlCmd.
+func (c *cachedKubectl) ListResources(ctx context.Context, r *ListRequest) (*ListResponse, error) {
+	indexer, ok := c.getSyncedIndexer(ctx, r.GroupVersionResource)
+	if !ok || len(r.FieldSelector) != 0 {
+		return c.KubectlCmd.ListResources(ctx, r)
+	}
+	selector, err := labels.Parse(r.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []interface{}
+	if indexName, indexedValue, ok := getIndexedValue(r.Namespace, selector); ok {
+		items, err = indexer.ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		items = indexer.List()
+	}
+	var manifests []unstructured.Unstructured
+	for _, item := range items {
+		obj, ok := item.(*unstructured.Unstructured)
+		if !ok || (len(r.Namespace) != 0 && obj.GetNamespace() != r.Namespace) || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		manifests = append(manifests, *obj.DeepCopy())
+	}
+	return &ListResponse{Manifests: manifests}, nil
+}
+
+func (c *cachedKubectl) getSyncedIndexer(ctx context.Context, gvr schema.GroupVersionResource) (cache.Indexer, bool) {
+	c.lock.Lock()
+	informer, ok := c.informers[gvr]
+	if !ok {
+		c.lock.Unlock()
+		return nil, false
+	}
+	waited := informer.waited
+	informer.waited = true
+	c.lock.Unlock()
+
+	if informer.informer.HasSynced() {
+		return informer.informer.GetIndexer(), true
+	}
+	if waited {
+		return nil, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.informer.HasSynced) {
+		return nil, false
+	}
+	return informer.informer.GetIndexer(), true
+}
+
+// getIndexedValue returns the most selective index for the request, the first equality requirement of the selector
+// or else the namespace
+func getIndexedValue(namespace string, selector labels.Selector) (indexName, indexedValue string, ok bool) {
+	requirements, _ := selector.Requirements()
+	for _, requirement := range requirements {
+		operator := requirement.Operator()
+		if operator != selection.Equals && operator != selection.DoubleEquals && operator != selection.In {
+			continue
+		}
+		if values := requirement.Values().List(); len(values) == 1 {
+			return labelIndex, requirement.Key() + "=" + values[0], true
+		}
+	}
+	if len(namespace) != 0 {
+		return cache.NamespaceIndex, namespace, true
+	}
+	return "", "", false
+}
+
+func labelIndexFunc(obj interface{}) ([]string, error) {
+	accessor, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, nil
+	}
+	var values []string
+	for key, value := range accessor.GetLabels() {
+		values = append(values, key+"="+value)
+	}
+	return values, nil
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"context"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const cachedObjectsMock = `
+[
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "dev", "labels": {"app": "web"}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "worker", "namespace": "dev", "labels": {"app": "worker"}}},
+  {"apiVersion": "apps/v1", "kind": "Deployment", "metadata": {"name": "web", "namespace": "qa", "labels": {"app": "web"}}},
+  {"apiVersion": "v1", "kind": "Service", "metadata": {"name": "web", "namespace": "dev", "labels": {"app": "web"}}}
+]`
+
+type countingKubectl struct {
+	KubectlCmd
+	lists int32
+}
+
+func (k *countingKubectl) ListResources(ctx context.Context, r *ListRequest) (*ListResponse, error) {
+	atomic.AddInt32(&k.lists, 1)
+	return k.KubectlCmd.ListResources(ctx, r)
+}
+
+func Test_cachedKubectl_ListResources(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	tests := []struct {
+		name      string
+		retain    bool
+		request   ListRequest
+		wantNames []string
+		wantLists int32
+	}{
+		{
+			name:      "label selector served from cache",
+			retain:    true,
+			request:   ListRequest{Namespace: "dev", GroupVersionResource: deployments, ListOptions: metav1.ListOptions{LabelSelector: "app=web"}},
+			wantNames: []string{"dev/web"},
+		},
+		{
+			name:      "all namespaces served from cache",
+			retain:    true,
+			request:   ListRequest{GroupVersionResource: deployments, ListOptions: metav1.ListOptions{LabelSelector: "app in (web)"}},
+			wantNames: []string{"dev/web", "qa/web"},
+		},
+		{
+			name:      "namespace served from cache",
+			retain:    true,
+			request:   ListRequest{Namespace: "dev", GroupVersionResource: deployments},
+			wantNames: []string{"dev/web", "dev/worker"},
+		},
+		{
+			name:      "resource not retained",
+			retain:    false,
+			request:   ListRequest{Namespace: "dev", GroupVersionResource: deployments, ListOptions: metav1.ListOptions{LabelSelector: "app=worker"}},
+			wantNames: []string{"dev/worker"},
+			wantLists: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kubectl := &countingKubectl{KubectlCmd: NewKubectlMock(cachedObjectsMock)}
+			c := NewCachedKubectl(kubectl)
+			if tt.retain {
+				c.Retain("dev/nightly", []schema.GroupVersionResource{deployments})
+				defer c.Release("dev/nightly")
+			}
+			resp, err := c.ListResources(context.Background(), &tt.request)
+			if err != nil {
+				t.Fatalf("ListResources() error = %v", err)
+			}
+			var gotNames []string
+			for _, manifest := range resp.Manifests {
+				gotNames = append(gotNames, manifest.GetNamespace()+"/"+manifest.GetName())
+			}
+			sort.Strings(gotNames)
+			if len(gotNames) != len(tt.wantNames) {
+				t.Errorf("ListResources() got = %v, want %v", gotNames, tt.wantNames)
+			}
+			for i := range gotNames {
+				if i < len(tt.wantNames) && gotNames[i] != tt.wantNames[i] {
+					t.Errorf("ListResources() got = %v, want %v", gotNames, tt.wantNames)
+				}
+			}
+			if lists := atomic.LoadInt32(&kubectl.lists); lists != tt.wantLists {
+				t.Errorf("ListResources() delegated lists = %d, want %d", lists, tt.wantLists)
+			}
+		})
+	}
+}
+
+func Test_cachedKubectl_watchAndRelease(t *testing.T) {
+	deployments := schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	kubectl := &countingKubectl{KubectlCmd: NewKubectlMock(cachedObjectsMock)}
+	c := NewCachedKubectl(kubectl)
+	c.Retain("dev/nightly", []schema.GroupVersionResource{deployments})
+	request := &ListRequest{Namespace: "dev", GroupVersionResource: deployments, ListOptions: metav1.ListOptions{LabelSelector: "app=web"}}
+	if resp, _ := c.ListResources(context.Background(), request); len(resp.Manifests) != 1 {
+		t.Fatalf("ListResources() got = %d, want 1", len(resp.Manifests))
+	}
+
+	_, err := c.PatchResource(context.Background(), &PatchRequest{
+		Name:             "worker",
+		Namespace:        "dev",
+		GroupVersionKind: schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		Patch:            `{"metadata": {"labels": {"app": "web"}}}`,
+		PatchType:        string(types.MergePatchType),
+	})
+	if err != nil {
+		t.Fatalf("PatchResource() error = %v", err)
+	}
+	found := 0
+	for deadline := time.Now().Add(5 * time.Second); found != 2 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		resp, _ := c.ListResources(context.Background(), request)
+		found = len(resp.Manifests)
+	}
+	if found != 2 {
+		t.Errorf("ListResources() after watch event got = %d, want 2", found)
+	}
+	if lists := atomic.LoadInt32(&kubectl.lists); lists != 0 {
+		t.Errorf("ListResources() delegated lists = %d, want 0", lists)
+	}
+
+	c.Release("dev/nightly")
+	c.ListResources(context.Background(), request)
+	if lists := atomic.LoadInt32(&kubectl.lists); lists != 1 {
+		t.Errorf("ListResources() delegated lists after release = %d, want 1", lists)
+	}
+}
@@ -18,14 +18,13 @@ package pkg
 
 import (
 	"context"
-	"k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
-	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	ctrl "sigs.k8s.io/controller-runtime"
 )
 
@@ -34,6 +33,9 @@ type KubectlCmd interface {
 	GetResource(ctx context.Context, r *GetRequest) (*ManifestResponse, error)
 	DeleteResource(ctx context.Context, r *DeleteRequest) (*ManifestResponse, error)
 	PatchResource(ctx context.Context, r *PatchRequest) (*ManifestResponse, error)
+	CreateResource(ctx context.Context, r *CreateRequest) (*ManifestResponse, error)
+	// ListerWatcher returns the lister watcher of the resource across all the namespaces, used to back an informer
+	ListerWatcher(gvr schema.GroupVersionResource) cache.ListerWatcher
 }
 
 // FromKubeConfig creates a Cluster from a kubeConfig chain.
@@ -46,28 +48,19 @@ func FromKubeConfig() (*rest.Config, error) {
 }
 
 type kubectl struct {
-	restConfig          *rest.Config
-	extensionsClientset *clientset.Clientset
+	clients *Clients
 }
 
-func NewKubectl() KubectlCmd {
-	restConfig := ctrl.GetConfigOrDie()
-	extensionsClientset, err := clientset.NewForConfig(restConfig)
-	if err != nil {
-		return nil
-	}
+func NewKubectl(clients *Clients) KubectlCmd {
 	return &kubectl{
-		restConfig:          restConfig,
-		extensionsClientset: extensionsClientset,
+		clients: clients,
 	}
 }
 
 func (k *kubectl) ListResources(ctx context.Context, r *ListRequest) (*ListResponse, error) {
-	dynamicIf, err := dynamic.NewForConfig(k.restConfig)
-	if err != nil {
-		return nil, err
-	}
-	resourceList, err := dynamicIf.Resource(r.GroupVersionResource).Namespace(r.Namespace).List(ctx, r.ListOptions)
+	ctx, cancel := k.clients.requestContext(ctx)
+	defer cancel()
+	resourceList, err := k.clients.Dynamic.Resource(r.GroupVersionResource).Namespace(r.Namespace).List(ctx, r.ListOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -75,20 +68,13 @@ func (k *kubectl) ListResources(ctx context.Context, r *ListRequest) (*ListRespo
 }
 
 func (k *kubectl) GetResource(ctx context.Context, r *GetRequest) (*ManifestResponse, error) {
-	dynamicIf, err := dynamic.NewForConfig(k.restConfig)
-	if err != nil {
-		return nil, err
-	}
-	disco, err := discovery.NewDiscoveryClientForConfig(k.restConfig)
-	if err != nil {
-		return nil, err
-	}
-	apiResource, err := ServerResourceForGroupVersionKind(disco, r.GroupVersionKind)
+	ctx, cancel := k.clients.requestContext(ctx)
+	defer cancel()
+	resource, err := k.clients.ResourceFor(r.GroupVersionKind)
 	if err != nil {
 		return nil, err
 	}
-	resource := r.GroupVersionKind.GroupVersion().WithResource(apiResource.Name)
-	obj, err := dynamicIf.Resource(resource).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
+	obj, err := k.clients.Dynamic.Resource(resource).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -96,24 +82,17 @@ func (k *kubectl) GetResource(ctx context.Context, r *GetRequest) (*ManifestResp
 }
 
 func (k *kubectl) DeleteResource(ctx context.Context, r *DeleteRequest) (*ManifestResponse, error) {
-	dynamicIf, err := dynamic.NewForConfig(k.restConfig)
+	ctx, cancel := k.clients.requestContext(ctx)
+	defer cancel()
+	resource, err := k.clients.ResourceFor(r.GroupVersionKind)
 	if err != nil {
 		return nil, err
 	}
-	disco, err := discovery.NewDiscoveryClientForConfig(k.restConfig)
+	obj, err := k.clients.Dynamic.Resource(resource).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
 	if err != nil {
 		return nil, err
 	}
-	apiResource, err := ServerResourceForGroupVersionKind(disco, r.GroupVersionKind)
-	if err != nil {
-		return nil, err
-	}
-	resource := r.GroupVersionKind.GroupVersion().WithResource(apiResource.Name)
-	obj, err := dynamicIf.Resource(resource).Namespace(r.Namespace).Get(ctx, r.Name, metav1.GetOptions{})
-	if err != nil {
-		return nil, err
-	}
-	err = dynamicIf.Resource(resource).Namespace(r.Namespace).Delete(ctx, r.Name, metav1.DeleteOptions{})
+	err = k.clients.Dynamic.Resource(resource).Namespace(r.Namespace).Delete(ctx, r.Name, metav1.DeleteOptions{})
 	if err != nil {
 		return nil, err
 	}
@@ -121,26 +100,37 @@ func (k *kubectl) DeleteResource(ctx context.Context, r *DeleteRequest) (*Manife
 }
 
 func (k *kubectl) PatchResource(ctx context.Context, r *PatchRequest) (*ManifestResponse, error) {
-	dynamicIf, err := dynamic.NewForConfig(k.restConfig)
+	ctx, cancel := k.clients.requestContext(ctx)
+	defer cancel()
+	resource, err := k.clients.ResourceFor(r.GroupVersionKind)
 	if err != nil {
 		return nil, err
 	}
-	disco, err := discovery.NewDiscoveryClientForConfig(k.restConfig)
+	obj, err := k.clients.Dynamic.Resource(resource).Namespace(r.Namespace).Patch(ctx, r.Name, types.PatchType(r.PatchType), []byte(r.Patch), metav1.PatchOptions{})
 	if err != nil {
 		return nil, err
 	}
-	apiResource, err := ServerResourceForGroupVersionKind(disco, r.GroupVersionKind)
+	return &ManifestResponse{*obj}, nil
+}
+
+func (k *kubectl) CreateResource(ctx context.Context, r *CreateRequest) (*ManifestResponse, error) {
+	ctx, cancel := k.clients.requestContext(ctx)
+	defer cancel()
+	resource, err := k.clients.ResourceFor(r.GroupVersionKind)
 	if err != nil {
 		return nil, err
 	}
-	resource := r.GroupVersionKind.GroupVersion().WithResource(apiResource.Name)
-	obj, err := dynamicIf.Resource(resource).Namespace(r.Namespace).Patch(ctx, r.Name, types.PatchType(r.PatchType), []byte(r.Patch), metav1.PatchOptions{})
+	obj, err := k.clients.Dynamic.Resource(resource).Namespace(r.Namespace).Create(ctx, &r.Manifest, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 	return &ManifestResponse{*obj}, nil
 }
 
+func (k *kubectl) ListerWatcher(gvr schema.GroupVersionResource) cache.ListerWatcher {
+	return dynamicListerWatcher(k.clients.Dynamic, gvr)
+}
+
 // See: https://github.com/ksonnet/ksonnet/blob/master/utils/client.go
 func ServerResourceForGroupVersionKind(disco discovery.DiscoveryInterface, gvk schema.GroupVersionKind) (*metav1.APIResource, error) {
 	resources, err := disco.ServerResourcesForGroupVersion(gvk.GroupVersion().String())
@@ -57,3 +57,9 @@ type PatchRequest struct {
 	Patch            string                  `protobuf:"bytes,4,req,name=patch" json:"patch,omitempty"`
 	PatchType        string                  `protobuf:"bytes,5,req,name=patchType" json:"patchType,omitempty"`
 }
+
+type CreateRequest struct {
+	Namespace        string                    `protobuf:"bytes,1,req,name=namespace" json:"namespace,omitempty"`
+	GroupVersionKind schema.GroupVersionKind   `protobuf:"bytes,2,req,name=groupVersionKind" json:"groupVersionKind,omitempty"`
+	Manifest         unstructured.Unstructured `protobuf:"bytes,3,req,name=manifest" json:"manifest,omitempty"`
+}